@@ -9,8 +9,10 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse/fs"
 	_ "bazil.org/fuse/fs/fstestutil"
@@ -40,12 +42,27 @@ func main() {
 
 	ftHdfsAccessors := make([]hopsfsmount.HdfsAccessor, hopsfsmount.Connectors)
 
+	// all the connectors share the same namespace if the in-memory backend is used
+	var memHdfsAccessor *hopsfsmount.MemHdfsAccessor
+	if hopsfsmount.IsMemNameNodeAddress(hopsRpcAddress) {
+		memHdfsAccessor = createMemHdfsAccessor()
+	}
+
 	for i := 0; i < hopsfsmount.Connectors; i++ {
-		hdfsAccessor, err := hopsfsmount.NewHdfsAccessor(hopsRpcAddress, hopsfsmount.WallClock{}, tlsConfig)
-		if err != nil {
-			logger.Fatal(fmt.Sprintf("Error/NewHopsFSAccessor: %v ", err), nil)
+		var hdfsAccessor hopsfsmount.HdfsAccessor
+		if memHdfsAccessor != nil {
+			hdfsAccessor = memHdfsAccessor
+		} else {
+			var err error
+			hdfsAccessor, err = hopsfsmount.NewHdfsAccessor(hopsRpcAddress, hopsfsmount.WallClock{}, tlsConfig)
+			if err != nil {
+				logger.Fatal(fmt.Sprintf("Error/NewHopsFSAccessor: %v ", err), nil)
+			}
 		}
 		ftHdfsAccessors[i] = hopsfsmount.NewFaultTolerantHdfsAccessor(hdfsAccessor, retryPolicy)
+		if hopsfsmount.EmulateSymlinks {
+			ftHdfsAccessors[i] = hopsfsmount.NewSymlinkEmulatingHdfsAccessor(ftHdfsAccessors[i])
+		}
 	}
 	logger.Info(fmt.Sprintf("Create %d file system clients", len(ftHdfsAccessors)), nil)
 
@@ -68,6 +85,32 @@ func main() {
 		logger.Fatal(fmt.Sprintf("Error/NewFileSystem: %v ", err), nil)
 	}
 
+	// one journal per mount point, the exchanges interrupted by a crash are completed and the
+	// temporary files of the interrupted uploads are deleted before mounting
+	if absMountPoint, err := filepath.Abs(mountPoint); err == nil {
+		fileSystem.JournalDir = filepath.Join(hopsfsmount.StagingDir, "exchanges"+strings.ReplaceAll(absMountPoint, "/", "_"))
+	}
+	fileSystem.RecoverExchanges()
+	fileSystem.RemoveStaleUploads()
+
+	if hopsfsmount.TrashEnabled {
+		trashRoot, err := hopsfsmount.UserTrashRoot()
+		if err != nil {
+			logger.Fatal(fmt.Sprintf("Unable to find the trash directory of the user. Error: %v ", err), nil)
+		}
+		fileSystem.Trash = &hopsfsmount.TrashPolicy{Root: trashRoot, MaxSize: hopsfsmount.TrashMaxSizeMB * 1024 * 1024}
+		if hopsfsmount.TrashExcludedPrefixesString != "" {
+			fileSystem.Trash.ExcludedPrefixes = strings.Split(hopsfsmount.TrashExcludedPrefixesString, ",")
+		}
+	}
+
+	if hopsfsmount.BlockCacheDir != "" {
+		fileSystem.BlockCache, err = hopsfsmount.NewBlockCache(hopsfsmount.BlockCacheDir, hopsfsmount.BlockCacheSizeMB*1024*1024)
+		if err != nil {
+			logger.Fatal(fmt.Sprintf("Unable to open the block cache. Error: %v ", err), nil)
+		}
+	}
+
 	mountOptions := hopsfsmount.GetMountOptions(hopsfsmount.ReadOnly)
 	c, err := fileSystem.Mount(mountPoint, mountOptions...)
 	if err != nil {
@@ -102,7 +145,15 @@ func main() {
 			retryPolicy.MaxDelay = 0
 		}
 	}()
-	err = fs.Serve(c, fileSystem)
+	server := fs.New(c, nil)
+	fileSystem.Invalidator = server
+	if hopsfsmount.WatchPathsString != "" {
+		watcher := hopsfsmount.NewWatcher(fileSystem, strings.Split(hopsfsmount.WatchPathsString, ","),
+			time.Duration(hopsfsmount.WatchIntervalSecs)*time.Second, hopsfsmount.WatchMaxRPCsPerSec)
+		watcher.Start()
+		defer watcher.Stop()
+	}
+	err = server.Serve(fileSystem)
 	if err != nil {
 		logger.Fatal(fmt.Sprintf("Failed to serve FS. Error: %v", err), nil)
 	}
@@ -114,6 +165,19 @@ func createStagingDir() {
 	}
 }
 
+// Creates the in-memory backend. The source directory is created so that it can be mounted
+func createMemHdfsAccessor() *hopsfsmount.MemHdfsAccessor {
+	memHdfsAccessor, err := hopsfsmount.NewMemHdfsAccessor(hopsfsmount.WallClock{})
+	if err != nil {
+		logger.Fatal(fmt.Sprintf("Error/NewMemHdfsAccessor: %v ", err), nil)
+	}
+	if err := memHdfsAccessor.MkdirAll(hopsfsmount.MntSrcDir, 0755); err != nil {
+		logger.Fatal(fmt.Sprintf("Unable to create source mount directory %s. Error: %v ", hopsfsmount.MntSrcDir, err), nil)
+	}
+	logger.Warn("Using in-memory backend. All the data is lost when the file system is unmounted", nil)
+	return memHdfsAccessor
+}
+
 func checkSrcMountPath(hdfsAccessor hopsfsmount.HdfsAccessor) error {
 	_, err := hdfsAccessor.Stat(hopsfsmount.MntSrcDir)
 	if err != nil {
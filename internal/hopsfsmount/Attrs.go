@@ -12,17 +12,20 @@ import (
 
 // Attributes common to the file/directory HDFS nodes
 type Attrs struct {
-	Inode        uint64
-	Name         string
-	Mode         os.FileMode
-	Size         uint64
-	Uid          uint32
-	Gid          uint32
-	DFSUserName  string
-	DFSGroupName string
-	Mtime        time.Time
-	Ctime        time.Time
-	Expires      time.Time // indicates when cached attribute information expires
+	Inode         uint64
+	Name          string
+	Mode          os.FileMode
+	Size          uint64
+	Uid           uint32
+	Gid           uint32
+	DFSUserName   string
+	DFSGroupName  string
+	Mtime         time.Time
+	Atime         time.Time
+	Ctime         time.Time
+	Expires       time.Time // indicates when cached attribute information expires
+	Target        string    // target of a symbolic link, empty if not known yet
+	Snapshottable bool      // snapshots of the directory can be taken, they are found in its .snapshot directory
 }
 
 // FsInfo provides information about HDFS
@@ -32,6 +35,23 @@ type FsInfo struct {
 	remaining uint64
 }
 
+// HopsFS metadata of a file or directory which has no POSIX equivalent
+type Metadata struct {
+	Replication   uint16 // replication factor, 0 for directories
+	BlockSize     uint64 // 0 for directories
+	StoragePolicy uint32 // id of the storage policy, 0 if none is set
+}
+
+// Summary of the subtree rooted at a file or directory, as computed by the name node
+type ContentSummary struct {
+	Length         int64 // total size of the files
+	FileCount      int
+	DirectoryCount int   // includes the directory itself
+	SpaceConsumed  int64 // size of the files multiplied by their replication
+	NameQuota      int   // -1 if not set
+	SpaceQuota     int64 // -1 if not set
+}
+
 // Converts Attrs datastructure into FUSE represnetation
 func (attrs *Attrs) ConvertAttrToFuse(a *fuse.Attr) error {
 	a.Inode = attrs.Inode
@@ -42,15 +62,18 @@ func (attrs *Attrs) ConvertAttrToFuse(a *fuse.Attr) error {
 	a.Uid = attrs.Uid
 	a.Gid = attrs.Gid
 	a.Mtime = attrs.Mtime
+	a.Atime = attrs.Atime
 	a.Ctime = attrs.Ctime
 	a.Valid = CacheAttrsTimeDuration
 	return nil
 }
 
-// returns fuse.DirentType for this attributes (DT_Dir or DT_File)
+// returns fuse.DirentType for this attributes (DT_Dir, DT_Link or DT_File)
 func (attrs *Attrs) FuseNodeType() fuse.DirentType {
 	if (attrs.Mode & os.ModeDir) == os.ModeDir {
 		return fuse.DT_Dir
+	} else if (attrs.Mode & os.ModeSymlink) == os.ModeSymlink {
+		return fuse.DT_Link
 	} else {
 		return fuse.DT_File
 	}
@@ -0,0 +1,306 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// Size of the blocks in which remote files are cached
+const blockCacheBlockSize = 1024 * 1024
+
+// Name of the file holding the modification time and size of a cached file
+const blockCacheMetaFile = "meta"
+
+// Persistent, size-capped cache of the blocks of remote files. The blocks are stored in
+// Dir/<file id>/<block index>, together with the modification time and size of the file
+// they were read from. The blocks of a file are dropped once its modification time or size
+// changes. The least recently used blocks are evicted when the cache is full, and the
+// directory of a file is removed along with its last block.
+// The index is rebuilt from Dir on startup, so the cache survives remounts.
+// Concurrency: thread safe
+type BlockCache struct {
+	Dir      string
+	Capacity int64 // maximum number of bytes held by the cache
+	files    map[uint64]*cachedFile
+	lru      *list.List // of *cachedBlock, the most recently used first
+	used     int64      // number of bytes held by the cache
+	mutex    sync.Mutex
+}
+
+// Version of a file in the cache
+type cachedFile struct {
+	mtime  time.Time
+	size   uint64
+	blocks map[int64]*list.Element
+}
+
+type cachedBlock struct {
+	fileID uint64
+	index  int64
+	size   int64
+}
+
+// Opens the cache in the given directory and loads the blocks cached by previous mounts
+func NewBlockCache(dir string, capacity int64) (*BlockCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	cache := &BlockCache{Dir: dir, Capacity: capacity, files: make(map[uint64]*cachedFile), lru: list.New()}
+	if err := cache.load(); err != nil {
+		return nil, err
+	}
+	cache.mutex.Lock()
+	cache.evict()
+	cache.mutex.Unlock()
+	logger.Info(fmt.Sprintf("Opened block cache. %d files, %d bytes", len(cache.files), cache.used), logger.Fields{Path: dir})
+	return cache, nil
+}
+
+// Drops the cached blocks of the file if they were read from a different version of the file
+func (cache *BlockCache) Validate(fileID uint64, mtime time.Time, size uint64) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if f, ok := cache.files[fileID]; ok && !f.isVersion(mtime, size) {
+		logger.Debug("File has changed, dropping its cached blocks", logger.Fields{ID: fileID, Entries: len(f.blocks)})
+		cache.removeFile(fileID)
+	}
+}
+
+// Reads the part of a cached block starting at off. Returns false if the block is not cached
+func (cache *BlockCache) ReadAt(fileID uint64, index int64, b []byte, off int64) (int, bool) {
+	cache.mutex.Lock()
+	f, ok := cache.files[fileID]
+	if !ok {
+		cache.mutex.Unlock()
+		return 0, false
+	}
+	e, ok := f.blocks[index]
+	if !ok {
+		cache.mutex.Unlock()
+		return 0, false
+	}
+	cache.lru.MoveToFront(e)
+	size := e.Value.(*cachedBlock).size
+	cache.mutex.Unlock()
+
+	if off >= size {
+		return 0, false
+	}
+	if off+int64(len(b)) > size {
+		b = b[:size-off]
+	}
+	block, err := os.Open(cache.blockPath(fileID, index))
+	if err != nil {
+		// the block has been evicted meanwhile
+		return 0, false
+	}
+	defer block.Close()
+	n, err := block.ReadAt(b, off)
+	if err != nil && !(err == io.EOF && n == len(b)) {
+		return 0, false
+	}
+	return n, true
+}
+
+// Adds a block of the given version of a file to the cache. The cached blocks of other versions
+// of the file are dropped
+func (cache *BlockCache) Put(fileID uint64, mtime time.Time, size uint64, index int64, data []byte) {
+	cache.mutex.Lock()
+	if int64(len(data)) > cache.Capacity {
+		cache.mutex.Unlock()
+		return
+	}
+	f, ok := cache.files[fileID]
+	if ok && !f.isVersion(mtime, size) {
+		cache.removeFile(fileID)
+		ok = false
+	}
+	if ok && f.blocks[index] != nil {
+		cache.mutex.Unlock()
+		return
+	}
+	if !ok {
+		if f = cache.addFile(fileID, mtime, size); f == nil {
+			cache.mutex.Unlock()
+			return
+		}
+	}
+	cache.mutex.Unlock()
+
+	// the block becomes visible to the next mount only once it is complete
+	blockPath := cache.blockPath(fileID, index)
+	tmpPath := blockPath + TempFileSuffix
+	err := os.WriteFile(tmpPath, data, 0600)
+	if err == nil {
+		err = os.Rename(tmpPath, blockPath)
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if err != nil {
+		logger.Warn("Unable to write to block cache", logger.Fields{Path: blockPath, Error: err})
+		os.Remove(tmpPath)
+		cache.removeFileIfEmpty(fileID, f)
+		return
+	}
+	if cache.files[fileID] != f || f.blocks[index] != nil {
+		// the file has changed or the block has been cached by another read meanwhile
+		if cache.files[fileID] != f {
+			os.Remove(blockPath)
+		}
+		return
+	}
+	f.blocks[index] = cache.lru.PushFront(&cachedBlock{fileID: fileID, index: index, size: int64(len(data))})
+	cache.used += int64(len(data))
+	cache.evict()
+}
+
+// Returns the number of bytes held by the cache
+func (cache *BlockCache) Used() int64 {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.used
+}
+
+// Removes the least recently used blocks until the cache fits its capacity. The files whose
+// last block is removed are removed too
+func (cache *BlockCache) evict() {
+	for cache.used > cache.Capacity {
+		e := cache.lru.Back()
+		block := e.Value.(*cachedBlock)
+		cache.lru.Remove(e)
+		f := cache.files[block.fileID]
+		delete(f.blocks, block.index)
+		cache.used -= block.size
+		if err := os.Remove(cache.blockPath(block.fileID, block.index)); err != nil {
+			logger.Warn("Unable to remove block from block cache", logger.Fields{ID: block.fileID, Error: err})
+		}
+		cache.removeFileIfEmpty(block.fileID, f)
+	}
+}
+
+// Creates the directory of a file, holding the version its blocks are read from.
+// Returns nil if the directory can not be created
+func (cache *BlockCache) addFile(fileID uint64, mtime time.Time, size uint64) *cachedFile {
+	fileDir := cache.fileDir(fileID)
+	meta := fmt.Sprintf("%d %d", mtime.UnixNano(), size)
+	if err := os.MkdirAll(fileDir, 0700); err != nil {
+		logger.Warn("Unable to create block cache directory", logger.Fields{Path: fileDir, Error: err})
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(fileDir, blockCacheMetaFile), []byte(meta), 0600); err != nil {
+		logger.Warn("Unable to write block cache metadata", logger.Fields{Path: fileDir, Error: err})
+		os.RemoveAll(fileDir)
+		return nil
+	}
+	f := &cachedFile{mtime: mtime, size: size, blocks: make(map[int64]*list.Element)}
+	cache.files[fileID] = f
+	return f
+}
+
+func (cache *BlockCache) removeFile(fileID uint64) {
+	for _, e := range cache.files[fileID].blocks {
+		cache.used -= e.Value.(*cachedBlock).size
+		cache.lru.Remove(e)
+	}
+	delete(cache.files, fileID)
+	if err := os.RemoveAll(cache.fileDir(fileID)); err != nil {
+		logger.Warn("Unable to remove file from block cache", logger.Fields{ID: fileID, Error: err})
+	}
+}
+
+// Removes the file if it is still f and has no blocks left
+func (cache *BlockCache) removeFileIfEmpty(fileID uint64, f *cachedFile) {
+	if cache.files[fileID] == f && len(f.blocks) == 0 {
+		cache.removeFile(fileID)
+	}
+}
+
+func (f *cachedFile) isVersion(mtime time.Time, size uint64) bool {
+	return f.mtime.Equal(mtime) && f.size == size
+}
+
+// Rebuilds the index from the blocks in the cache directory. The blocks are ordered by their
+// modification time, as the access order of the previous mounts is not known
+func (cache *BlockCache) load() error {
+	entries, err := os.ReadDir(cache.Dir)
+	if err != nil {
+		return err
+	}
+
+	type loadedBlock struct {
+		cachedBlock
+		mtime time.Time
+	}
+	var blocks []loadedBlock
+	for _, entry := range entries {
+		fileID, err := strconv.ParseUint(entry.Name(), 16, 64)
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		fileDir := cache.fileDir(fileID)
+		var mtimeNanos int64
+		var size uint64
+		meta, err := os.ReadFile(filepath.Join(fileDir, blockCacheMetaFile))
+		if err == nil {
+			_, err = fmt.Sscanf(string(meta), "%d %d", &mtimeNanos, &size)
+		}
+		if err != nil {
+			logger.Warn("Dropping cached file without metadata", logger.Fields{Path: fileDir, Error: err})
+			os.RemoveAll(fileDir)
+			continue
+		}
+		cache.files[fileID] = &cachedFile{mtime: time.Unix(0, mtimeNanos), size: size, blocks: make(map[int64]*list.Element)}
+
+		blockEntries, err := os.ReadDir(fileDir)
+		if err != nil {
+			return err
+		}
+		for _, blockEntry := range blockEntries {
+			index, err := strconv.ParseInt(blockEntry.Name(), 10, 64)
+			if err != nil {
+				if blockEntry.Name() != blockCacheMetaFile {
+					// incomplete block of a previous mount
+					os.Remove(filepath.Join(fileDir, blockEntry.Name()))
+				}
+				continue
+			}
+			info, err := blockEntry.Info()
+			if err != nil {
+				continue
+			}
+			blocks = append(blocks, loadedBlock{cachedBlock{fileID: fileID, index: index, size: info.Size()}, info.ModTime()})
+		}
+	}
+
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].mtime.After(blocks[j].mtime) })
+	for i := range blocks {
+		block := blocks[i].cachedBlock
+		cache.files[block.fileID].blocks[block.index] = cache.lru.PushBack(&block)
+		cache.used += block.size
+	}
+	for fileID, f := range cache.files {
+		cache.removeFileIfEmpty(fileID, f)
+	}
+	return nil
+}
+
+func (cache *BlockCache) fileDir(fileID uint64) string {
+	return filepath.Join(cache.Dir, strconv.FormatUint(fileID, 16))
+}
+
+func (cache *BlockCache) blockPath(fileID uint64, index int64) string {
+	return filepath.Join(cache.fileDir(fileID), strconv.FormatInt(index, 10))
+}
@@ -0,0 +1,160 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func readCachedBlock(t *testing.T, cache *BlockCache, fileID uint64, index int64) (string, bool) {
+	buf := make([]byte, 64)
+	n, ok := cache.ReadAt(fileID, index, buf, 0)
+	return string(buf[:n]), ok
+}
+
+func TestBlockCache(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Unix(1000, 0)
+	cache, err := NewBlockCache(dir, 30)
+	assert.Nil(t, err)
+
+	// validating a file that has no cached blocks does not add it
+	cache.Validate(1, mtime, 100)
+	assert.Equal(t, 0, len(cache.files))
+	_, err = os.Stat(cache.fileDir(1))
+	assert.True(t, os.IsNotExist(err))
+
+	cache.Put(1, mtime, 100, 0, []byte("0123456789"))
+	cache.Put(1, mtime, 100, 1, []byte("abcdefghij"))
+	cache.Put(2, mtime, 10, 0, []byte("ABCDEFGHIJ"))
+	data, ok := readCachedBlock(t, cache, 1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, "0123456789", data)
+	assert.Equal(t, int64(30), cache.Used())
+
+	// the least recently used block is evicted
+	cache.Put(3, mtime, 10, 0, []byte("klmnopqrst"))
+	_, ok = readCachedBlock(t, cache, 1, 1)
+	assert.False(t, ok)
+	_, ok = readCachedBlock(t, cache, 1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(30), cache.Used())
+
+	// the cache survives remounts
+	cache, err = NewBlockCache(dir, 30)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(30), cache.Used())
+	cache.Validate(2, mtime, 10)
+	data, ok = readCachedBlock(t, cache, 2, 0)
+	assert.True(t, ok)
+	assert.Equal(t, "ABCDEFGHIJ", data)
+
+	// a modified file is not served from the cache
+	cache.Validate(2, mtime.Add(time.Second), 10)
+	_, ok = readCachedBlock(t, cache, 2, 0)
+	assert.False(t, ok)
+	cache.Validate(3, mtime, 11)
+	_, ok = readCachedBlock(t, cache, 3, 0)
+	assert.False(t, ok)
+	assert.Equal(t, int64(10), cache.Used())
+	_, err = os.Stat(cache.fileDir(2))
+	assert.True(t, os.IsNotExist(err))
+
+	// a block of another version of a file replaces the cached blocks
+	cache.Put(1, mtime, 101, 2, []byte("uvwxyz"))
+	_, ok = readCachedBlock(t, cache, 1, 0)
+	assert.False(t, ok)
+	data, ok = readCachedBlock(t, cache, 1, 2)
+	assert.True(t, ok)
+	assert.Equal(t, "uvwxyz", data)
+	assert.Equal(t, int64(6), cache.Used())
+
+	// a file is removed along with its last block
+	cache.Put(4, mtime, 10, 0, []byte("0123456789"))
+	cache.Put(5, mtime, 10, 0, []byte("0123456789"))
+	cache.Put(6, mtime, 10, 0, []byte("0123456789"))
+	_, ok = readCachedBlock(t, cache, 1, 2)
+	assert.False(t, ok)
+	assert.Equal(t, 3, len(cache.files))
+	_, err = os.Stat(cache.fileDir(1))
+	assert.True(t, os.IsNotExist(err))
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+}
+
+// Accessor counting the reads of the files in DFS
+type readCountingAccessor struct {
+	*MemHdfsAccessor
+	stats ReaderStats
+}
+
+type countingReader struct {
+	ReadSeekCloser
+	stats *ReaderStats
+}
+
+func (a *readCountingAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	reader, err := a.MemHdfsAccessor.OpenRead(path)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReader{ReadSeekCloser: reader, stats: &a.stats}, nil
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	r.stats.IncrementRead()
+	return r.ReadSeekCloser.Read(b)
+}
+
+func TestBlockCacheReads(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	data := make([]byte, 3*blockCacheBlockSize+1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	memWriteFile(t, mem, "/dataset", string(data))
+	accessor := &readCountingAccessor{MemHdfsAccessor: mem}
+
+	fs, _ := NewFileSystem([]HdfsAccessor{accessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	cache, err := NewBlockCache(t.TempDir(), 10*blockCacheBlockSize)
+	assert.Nil(t, err)
+	fs.BlockCache = cache
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "dataset")
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+
+	readAll := func() {
+		h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+		fileHandle := h.(*FileHandle)
+		for off := int64(0); off < int64(len(data)); off += 100000 {
+			resp := &fuse.ReadResponse{Data: make([]byte, 100000)}
+			assert.Nil(t, fileHandle.Read(nil, &fuse.ReadRequest{Offset: off, Size: 100000}, resp))
+			end := off + 100000
+			if end > int64(len(data)) {
+				end = int64(len(data))
+			}
+			if !bytes.Equal(data[off:end], resp.Data) {
+				t.Fatalf("Unexpected content at offset %d", off)
+			}
+		}
+		assert.Nil(t, fileHandle.Release(nil, nil))
+	}
+
+	readAll()
+	assert.Equal(t, int64(len(data)), cache.Used())
+	reads := accessor.stats.ReadCount
+	assert.True(t, reads > 0)
+
+	// the second pass is served from the cache
+	readAll()
+	assert.Equal(t, reads, accessor.stats.ReadCount)
+}
@@ -10,6 +10,7 @@ import (
 	"path"
 	"sync"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -19,17 +20,20 @@ import (
 
 // Encapsulates state and operations for directory node on the HDFS file system
 type DirINode struct {
-	FileSystem    *FileSystem        // Pointer to the owning filesystem
-	Attrs         Attrs              // Cached attributes of the directory, TODO: add TTL
-	Parent        *DirINode          // Pointer to the parent directory (allows computing fully-qualified paths on demand)
-	children      map[string]fs.Node // Cahed directory entries
-	childrenMutex sync.Mutex         // for concurrent read and updates
-	dirMutex      sync.Mutex         // One read or write operation on a directory at a time
+	FileSystem    *FileSystem       // Pointer to the owning filesystem
+	Attrs         Attrs             // Cached attributes of the directory, TODO: add TTL
+	Parent        *DirINode         // Pointer to the parent directory (allows computing fully-qualified paths on demand)
+	children      map[string]uint64 // Cahed directory entries, ids of the inode table
+	childrenMutex sync.Mutex        // for concurrent read and updates
+	dirMutex      sync.Mutex        // One read or write operation on a directory at a time
+	listing       []fuse.Dirent     // Cached listing of the directory, nil if not cached. Guarded by childrenMutex
+	listingExpiry time.Time         // Time until the cached listing is served
+	Snapshot      bool              // The dir is the .snapshot directory or is in a snapshot, it is read-only
 }
 
 // Verify that *Dir implements necesary FUSE interfaces
 var _ fs.Node = (*DirINode)(nil)
-var _ fs.HandleReadDirAller = (*DirINode)(nil)
+var _ fs.NodeOpener = (*DirINode)(nil)
 var _ fs.NodeStringLookuper = (*DirINode)(nil)
 var _ fs.NodeMkdirer = (*DirINode)(nil)
 var _ fs.NodeRemover = (*DirINode)(nil)
@@ -40,6 +44,10 @@ var _ fs.NodeReadlinker = (*DirINode)(nil)
 var _ fs.NodeLinker = (*DirINode)(nil)
 var _ fs.NodeCreater = (*DirINode)(nil)
 var _ fs.NodeFsyncer = (*DirINode)(nil)
+var _ fs.NodeGetxattrer = (*DirINode)(nil)
+var _ fs.NodeListxattrer = (*DirINode)(nil)
+var _ fs.NodeSetxattrer = (*DirINode)(nil)
+var _ fs.NodeRemovexattrer = (*DirINode)(nil)
 
 // Returns absolute path of the dir in HDFS namespace
 func (dir *DirINode) AbsolutePath() string {
@@ -60,7 +68,7 @@ func (dir *DirINode) Attr(ctx context.Context, a *fuse.Attr) error {
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
-	if dir.Parent != nil && dir.FileSystem.Clock.Now().After(dir.Attrs.Expires) {
+	if dir.Parent != nil && !dir.isSnapshotDir() && dir.FileSystem.Clock.Now().After(dir.Attrs.Expires) {
 		_, err := dir.Parent.statInodeInHopsFS(GetattrDir, dir.Attrs.Name, &dir.Attrs)
 		if err != nil {
 			return err
@@ -69,7 +77,13 @@ func (dir *DirINode) Attr(ctx context.Context, a *fuse.Attr) error {
 		logger.Info("Stat successful. Returning from Cache ", logger.Fields{Operation: GetattrDir, Path: path.Join(dir.AbsolutePath()), FileSize: dir.Attrs.Size,
 			IsDir: dir.Attrs.Mode.IsDir(), IsRegular: dir.Attrs.Mode.IsRegular()})
 	}
-	return dir.Attrs.ConvertAttrToFuse(a)
+	if err := dir.Attrs.ConvertAttrToFuse(a); err != nil {
+		return err
+	}
+	if dir.Parent != nil {
+		a.Inode = dir.FileSystem.inodeNumber(dir.Parent, dir.Attrs.Name, dir)
+	}
+	return nil
 }
 
 func (dir *DirINode) getChildInode(operation, name string) fs.Node {
@@ -77,11 +91,19 @@ func (dir *DirINode) getChildInode(operation, name string) fs.Node {
 	defer dir.unlockChildrenMutex()
 
 	if dir.children == nil {
-		dir.children = make(map[string]fs.Node)
+		dir.children = make(map[string]uint64)
 		return nil
 	}
 
-	node := dir.children[name]
+	var node fs.Node
+	if id, ok := dir.children[name]; ok {
+		node = dir.FileSystem.INodes.Get(id)
+		if node == nil || !dir.isChildInode(name, node) {
+			// the inode has been forgotten, or moved to another dir by another client
+			delete(dir.children, name)
+			node = nil
+		}
+	}
 	if node != nil {
 		logger.Debug("Children's List. getChildInode ", logger.Fields{Operation: operation, Parent: dir.AbsolutePath(), Child: name, NumChildren: len(dir.children)})
 	} else {
@@ -91,41 +113,118 @@ func (dir *DirINode) getChildInode(operation, name string) fs.Node {
 	return node
 }
 
+// Returns true if the inode is the child of this directory with the given name
+func (dir *DirINode) isChildInode(name string, node fs.Node) bool {
+	if fnode, ok := node.(*FileINode); ok {
+		return fnode.Parent == dir && fnode.Attrs.Name == name
+	} else if dnode, ok := node.(*DirINode); ok {
+		return dnode.Parent == dir && dnode.Attrs.Name == name
+	}
+	return false
+}
+
 func (dir *DirINode) addOrUpdateChildInodeAttrs(operation, name string, attrs Attrs) fs.Node {
+	if dir.Snapshot {
+		// the entries of the snapshots share the file ids of the live files
+		attrs.Inode = 0
+	}
 	dir.lockChildrenMutex()
-	defer dir.unlockChildrenMutex()
 
 	if dir.children == nil {
-		dir.children = make(map[string]fs.Node)
+		dir.children = make(map[string]uint64)
+	}
+
+	inodes := dir.FileSystem.INodes
+	var node fs.Node
+	var replaced fs.Node
+	if id, ok := dir.children[name]; ok {
+		node = inodes.Get(id)
+		if node != nil && attrs.Inode != 0 && attrs.Inode != id {
+			if id >= localINodeIDBase || isINodeOpen(node) {
+				// the file id of a new inode is known now, or an open file has been replaced on flush
+				inodes.Rekey(node, attrs.Inode)
+			} else {
+				// the path has been deleted and created again. The old inode stays with the kernel until it is forgotten
+				logger.Debug("Children's List. addOrUpdateChildInodeAttrs. Replaced ", logger.Fields{Operation: operation, Parent: dir.AbsolutePath(), Child: name, ID: attrs.Inode})
+				replaced = node
+				node = nil
+			}
+		}
+	}
+	if node == nil && attrs.Inode != 0 {
+		// the inode may be known under another name, e.g. after a rename by another client
+		if known := inodes.Get(attrs.Inode); known != nil && ((attrs.Mode&os.ModeDir) == 0) == isFileINode(known) {
+			node = known
+		}
 	}
 
-	if node, ok := dir.children[name]; ok {
+	var dropped []fs.Node
+	if node != nil {
+		dir.invalidateIfChanged(node, *attrsOfInode(node), attrs)
 		if fnode, ok := (node).(*FileINode); ok {
 			fnode.Attrs = attrs
+			fnode.Parent = dir
 		} else if dnode, ok := (node).(*DirINode); ok {
 			dnode.Attrs = attrs
+			dnode.Parent = dir
 		}
+		id, ok := inodes.ID(node)
+		if !ok {
+			id, dropped = inodes.Add(node, attrs.Inode)
+		}
+		dir.children[name] = id
 		logger.Debug("Children's List. addOrUpdateChildInodeAttrs. Update ", logger.Fields{Operation: operation, Parent: dir.AbsolutePath(), Child: name, NumChildren: len(dir.children)})
-		return node
 	} else {
-		var node fs.Node
 		if (attrs.Mode & os.ModeDir) == 0 {
-			node = &FileINode{FileSystem: dir.FileSystem, Parent: dir, Attrs: attrs}
+			node = &FileINode{FileSystem: dir.FileSystem, Parent: dir, Attrs: attrs, Snapshot: dir.Snapshot}
 		} else {
-			node = &DirINode{FileSystem: dir.FileSystem, Parent: dir, Attrs: attrs}
+			node = &DirINode{FileSystem: dir.FileSystem, Parent: dir, Attrs: attrs, Snapshot: dir.Snapshot}
 		}
-		dir.children[name] = node
+		var id uint64
+		id, dropped = inodes.Add(node, attrs.Inode)
+		dir.children[name] = id
 		logger.Debug("Children's List. addOrUpdateChildInodeAttrs. Add ", logger.Fields{Operation: operation, Parent: dir.AbsolutePath(), Child: name, NumChildren: len(dir.children)})
-		return node
 	}
+	dir.unlockChildrenMutex()
+
+	if replaced != nil {
+		logger.Info("Path replaced by another client, invalidating kernel cache", logger.Fields{Operation: operation, Parent: dir.AbsolutePath(), Child: name, ID: attrs.Inode})
+		dir.FileSystem.invalidateNode(replaced)
+		dir.FileSystem.invalidateEntry(dir, name)
+	}
+	detachINodes(dropped)
+	return node
+}
+
+// Tells the kernel to drop its cached data of the child if the attributes read from the backend
+// show that the child has been changed by another client
+func (dir *DirINode) invalidateIfChanged(node fs.Node, old Attrs, attrs Attrs) {
+	if old.Inode == 0 || attrs.Inode == 0 {
+		// the attributes have not been read from the backend
+		return
+	}
+	if old.Inode == attrs.Inode && old.Size == attrs.Size && old.Mtime.Equal(attrs.Mtime) {
+		return
+	}
+	if fnode, ok := node.(*FileINode); ok && fnode.isOpenForWriting() {
+		// changed through this mount
+		return
+	}
+	logger.Info("Remote change detected, invalidating kernel cache", logger.Fields{Operation: Invalidate, Parent: dir.AbsolutePath(), Child: attrs.Name,
+		FileSize: attrs.Size, ID: attrs.Inode})
+	dir.FileSystem.invalidateNode(node)
 }
 
 func (dir *DirINode) removeChildInode(operation, name string) {
 	dir.lockChildrenMutex()
 	defer dir.unlockChildrenMutex()
 
-	if dir.children != nil {
+	if id, ok := dir.children[name]; ok {
 		delete(dir.children, name)
+		// the inode stays in the table while the kernel references it
+		if node := dir.FileSystem.INodes.Get(id); node != nil {
+			dir.FileSystem.INodes.Release(node)
+		}
 		logger.Debug("Children's List. removeChildInode ", logger.Fields{Operation: operation, Parent: dir.AbsolutePath(), Child: name, NumChildren: len(dir.children)})
 	}
 }
@@ -133,10 +232,9 @@ func (dir *DirINode) removeChildInode(operation, name string) {
 // used in rename. when an inode is moved from one dir to another
 func (dir *DirINode) adoptChildInode(operation, name string, node fs.Node) {
 	dir.lockChildrenMutex()
-	defer dir.unlockChildrenMutex()
 
 	if dir.children == nil {
-		dir.children = make(map[string]fs.Node)
+		dir.children = make(map[string]uint64)
 	}
 
 	if _, ok := dir.children[name]; ok {
@@ -145,7 +243,112 @@ func (dir *DirINode) adoptChildInode(operation, name string, node fs.Node) {
 		logger.Debug("Children's List. Adopted inode. Added new node ", logger.Fields{Operation: operation, Parent: dir.AbsolutePath(), Child: name, NumChildren: len(dir.children)})
 	}
 
-	dir.children[name] = node
+	var dropped []fs.Node
+	id, ok := dir.FileSystem.INodes.ID(node)
+	if !ok {
+		id, dropped = dir.FileSystem.INodes.Add(node, attrsOfInode(node).Inode)
+	}
+	dir.children[name] = id
+	dir.unlockChildrenMutex()
+
+	detachINodes(dropped)
+}
+
+// Removes the name of an inode which has been dropped from the inode table
+func (dir *DirINode) forgetChildInode(operation, name string) {
+	dir.lockChildrenMutex()
+	defer dir.unlockChildrenMutex()
+
+	if id, ok := dir.children[name]; ok && dir.FileSystem.INodes.Get(id) == nil {
+		delete(dir.children, name)
+		logger.Debug("Children's List. forgetChildInode ", logger.Fields{Operation: operation, Parent: dir.AbsolutePath(), Child: name, NumChildren: len(dir.children)})
+	}
+}
+
+// Removes the inodes dropped from the inode table from their parents
+func detachINodes(nodes []fs.Node) {
+	for _, node := range nodes {
+		if fnode, ok := node.(*FileINode); ok {
+			fnode.Parent.forgetChildInode(Forget, fnode.Attrs.Name)
+		} else if dnode, ok := node.(*DirINode); ok && dnode.Parent != nil {
+			dnode.Parent.forgetChildInode(Forget, dnode.Attrs.Name)
+		}
+	}
+}
+
+func isFileINode(node fs.Node) bool {
+	_, ok := node.(*FileINode)
+	return ok
+}
+
+// Returns the cached listing of the directory, or nil if it is not cached or has expired
+func (dir *DirINode) getCachedListing() []fuse.Dirent {
+	dir.lockChildrenMutex()
+	defer dir.unlockChildrenMutex()
+
+	if dir.listing == nil || !dir.FileSystem.Clock.Now().Before(dir.listingExpiry) {
+		dir.listing = nil
+		return nil
+	}
+	entries := make([]fuse.Dirent, len(dir.listing))
+	copy(entries, dir.listing)
+	return entries
+}
+
+func (dir *DirINode) setCachedListing(entries []fuse.Dirent) {
+	if CacheDirListingTimeDuration <= 0 {
+		return
+	}
+
+	dir.lockChildrenMutex()
+	defer dir.unlockChildrenMutex()
+
+	dir.listing = make([]fuse.Dirent, len(entries))
+	copy(dir.listing, entries)
+	dir.listingExpiry = dir.FileSystem.Clock.Now().Add(CacheDirListingTimeDuration)
+}
+
+// Drops the cached listing, so that the next read of the directory lists it on the backend
+func (dir *DirINode) invalidateListing(operation string) {
+	dir.lockChildrenMutex()
+	defer dir.unlockChildrenMutex()
+
+	if dir.listing != nil {
+		dir.listing = nil
+		logger.Debug("Dropped cached listing", logger.Fields{Operation: operation, Path: dir.AbsolutePath()})
+	}
+}
+
+// Adds or replaces the entry of the child in the cached listing, if there is one
+func (dir *DirINode) addToListing(name string, node fs.Node) {
+	inode := dir.FileSystem.inodeNumber(dir, name, node)
+	dir.lockChildrenMutex()
+	defer dir.unlockChildrenMutex()
+
+	if dir.listing == nil {
+		return
+	}
+	entry := fuse.Dirent{Inode: inode, Name: name, Type: attrsOfInode(node).FuseNodeType()}
+	for i := range dir.listing {
+		if dir.listing[i].Name == name {
+			dir.listing[i] = entry
+			return
+		}
+	}
+	dir.listing = append(dir.listing, entry)
+}
+
+// Removes the entry of the child from the cached listing, if there is one
+func (dir *DirINode) removeFromListing(name string) {
+	dir.lockChildrenMutex()
+	defer dir.unlockChildrenMutex()
+
+	for i := range dir.listing {
+		if dir.listing[i].Name == name {
+			dir.listing = append(dir.listing[:i], dir.listing[i+1:]...)
+			return
+		}
+	}
 }
 
 // Responds on FUSE request to lookup the directory
@@ -153,14 +356,22 @@ func (dir *DirINode) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
-	return dir.LookupInt(Lookup, name)
+	node, err := dir.LookupInt(Lookup, name)
+	if err == nil {
+		dir.FileSystem.INodes.Lookup(node)
+	}
+	return node, err
 }
 
 func (dir *DirINode) LookupInt(opName string, name string) (fs.Node, error) {
-	if !dir.FileSystem.IsPathAllowed(dir.AbsolutePathForChild(name)) {
+	if !dir.FileSystem.IsPathAllowed(dir.AbsolutePathForChild(name)) || isTempSibling(name) {
 		return nil, syscall.ENOENT
 	}
 
+	if name == snapshotDirName && dir.isSnapshottable() {
+		return dir.lookupSnapshotDir(opName), nil
+	}
+
 	if node := dir.getChildInode(opName, name); node != nil {
 		return node, nil
 	}
@@ -173,35 +384,9 @@ func (dir *DirINode) LookupInt(opName string, name string) (fs.Node, error) {
 	return node, nil
 }
 
-// Responds on FUSE request to read directory
-func (dir *DirINode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-	dir.lockMutex()
-	defer dir.unlockMutex()
-
-	absolutePath := dir.AbsolutePath()
-	logger.Info("Read directory", logger.Fields{Operation: ReadDir, Path: absolutePath})
-
-	allAttrs, err := dir.FileSystem.getDFSConnector().ReadDir(absolutePath)
-	if err != nil {
-		logger.Warn("Failed to list DFS directory", logger.Fields{Operation: ReadDir, Path: absolutePath, Error: err})
-		return nil, err
-	}
-
-	entries := make([]fuse.Dirent, 0, len(allAttrs))
-	for _, a := range allAttrs {
-		if dir.FileSystem.IsPathAllowed(dir.AbsolutePathForChild(a.Name)) {
-			// Creating Dirent structure as required by FUSE
-			entries = append(entries, fuse.Dirent{
-				Inode: a.Inode,
-				Name:  a.Name,
-				Type:  a.FuseNodeType()})
-			// Speculatively pre-creating child Dir or File node with cached attributes,
-			// since it's highly likely that we will have Lookup() call for this name
-			// This is the key trick which dramatically speeds up 'ls'
-			dir.addOrUpdateChildInodeAttrs(ReadDir, a.Name, a)
-		}
-	}
-	return entries, nil
+// Responds on FUSE request to open the directory. The returned handle streams the listing to the kernel
+func (dir *DirINode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	return &DirHandle{Dir: dir}, nil
 }
 
 // Performs Stat() query on the backend
@@ -213,9 +398,12 @@ func (dir *DirINode) statInodeInHopsFS(operation, name string, attrs *Attrs) (fs
 		dir.removeChildInode(operation, name)
 		return nil, err
 	}
+	// attrs may point to the attributes of the child, which are compared with the new ones first
+	if dir.Snapshot {
+		a.Inode = 0
+	}
+	inode := dir.addOrUpdateChildInodeAttrs(operation, name, a)
 	*attrs = a
-
-	inode := dir.addOrUpdateChildInodeAttrs(operation, name, *attrs)
 	logger.Info("Stat successful on backend", logger.Fields{Operation: operation, Path: path.Join(dir.AbsolutePath(), name), FileSize: attrs.Size,
 		IsDir: attrs.Mode.IsDir(), IsRegular: attrs.Mode.IsRegular()})
 	return inode, nil
@@ -226,6 +414,10 @@ func (dir *DirINode) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
+	if err := dir.checkNotInSnapshot(req.Name); err != nil {
+		return nil, err
+	}
+
 	// check user and group information first.
 	userName, err := getUserName(req.Uid)
 	if err != nil {
@@ -247,6 +439,8 @@ func (dir *DirINode) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node
 		logger.Info("mkdir failed", logger.Fields{Operation: Mkdir, Path: path.Join(dir.AbsolutePath(), req.Name), Error: err})
 		return nil, err
 	}
+	// the inode id of the new dir is not known, the listing has to be fetched again
+	dir.invalidateListing(Mkdir)
 	logger.Debug("mkdir successful", logger.Fields{Operation: Mkdir, Path: path.Join(dir.AbsolutePath(), req.Name)})
 
 	err = ChownOp(dir.FileSystem, dir.AbsolutePathForChild(req.Name), userName, groupName)
@@ -267,6 +461,7 @@ func (dir *DirINode) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node
 			DFSUserName:  userName,
 			DFSGroupName: groupName,
 		})
+	dir.FileSystem.INodes.Lookup(newInode)
 	return newInode, nil
 }
 
@@ -275,6 +470,10 @@ func (dir *DirINode) Create(ctx context.Context, req *fuse.CreateRequest, resp *
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
+	if err := dir.checkNotInSnapshot(req.Name); err != nil {
+		return nil, nil, err
+	}
+
 	req.Mode = ComputePermissions(req.Mode)
 	logger.Info("Creating a new file", logger.Fields{Operation: Create, Path: dir.AbsolutePathForChild(req.Name), Mode: req.Mode, Flags: req.Flags})
 
@@ -326,8 +525,11 @@ func (dir *DirINode) Create(ctx context.Context, req *fuse.CreateRequest, resp *
 	_, err = dir.statInodeInHopsFS(Create, file.Attrs.Name, &file.Attrs)
 	if err != nil {
 		dir.removeChildInode(Create, req.Name)
+		dir.invalidateListing(Create)
 		return nil, nil, err
 	}
+	dir.addToListing(req.Name, file)
+	dir.FileSystem.INodes.Lookup(file)
 
 	return file, handle, nil
 }
@@ -337,11 +539,19 @@ func (dir *DirINode) Remove(ctx context.Context, req *fuse.RemoveRequest) error
 	dir.lockMutex()
 	defer dir.unlockMutex()
 
+	if err := dir.checkNotInSnapshot(req.Name); err != nil {
+		return err
+	}
+
 	path := dir.AbsolutePathForChild(req.Name)
 	logger.Debug("Removing path", logger.Fields{Operation: Remove, Path: path})
-	err := dir.FileSystem.getDFSConnector().Remove(path)
+	trashed, err := dir.FileSystem.moveToTrash(path, req.Dir)
+	if err == nil && !trashed {
+		err = dir.FileSystem.getDFSConnector().Remove(path)
+	}
 	if err == nil {
 		dir.removeChildInode(Remove, req.Name)
+		dir.removeFromListing(req.Name)
 		logger.Info("Removed path", logger.Fields{Operation: Remove, Path: path})
 	} else {
 		logger.Warn("Failed to remove path", logger.Fields{Operation: Remove, Path: path, Error: err})
@@ -354,6 +564,12 @@ func (srcParent *DirINode) Rename(ctx context.Context, req *fuse.RenameRequest,
 	srcParent.lockMutex()
 	defer srcParent.unlockMutex()
 
+	if err := srcParent.checkNotInSnapshot(req.OldName); err != nil {
+		return err
+	}
+	if err := dstParentDir.(*DirINode).checkNotInSnapshot(req.NewName); err != nil {
+		return err
+	}
 	return srcParent.renameInt(Rename, req.OldName, req.NewName, dstParentDir, hdfs.RENAME_OPTION_NONE)
 }
 
@@ -384,6 +600,7 @@ func (srcParent *DirINode) renameInt(operationName, oldName, newName string, dst
 	if srcInode != nil {
 		srcParent.removeChildInode(Rename, oldName)
 	}
+	srcParent.removeFromListing(oldName)
 
 	// disconnect dst inode
 	if dstInode != nil {
@@ -407,21 +624,48 @@ func (srcParent *DirINode) renameInt(operationName, oldName, newName string, dst
 		dstParentDir.(*DirINode).adoptChildInode(Rename, newName, dnode)
 	}
 
+	if attrsOfInode(srcInode) != nil {
+		dstParentDir.(*DirINode).addToListing(newName, srcInode)
+	} else {
+		dstParentDir.(*DirINode).invalidateListing(operationName)
+	}
+
 	logger.Info("Renamed", logger.Fields{Operation: operationName, From: oldPath, To: newPath})
 	return nil
 }
 
+// Returns the cached attributes of a file or dir inode
+func attrsOfInode(node fs.Node) *Attrs {
+	if fnode, ok := node.(*FileINode); ok {
+		return &fnode.Attrs
+	}
+	if dnode, ok := node.(*DirINode); ok {
+		return &dnode.Attrs
+	}
+	return nil
+}
+
 // Responds on FUSE Rename request
 func (srcParent *DirINode) Rename2(ctx context.Context, req *fuse.Rename2Request, dstParentDir fs.Node) error {
 	srcParent.lockMutex()
 	defer srcParent.unlockMutex()
 
-	if req.Flags&fuse.RENAME_EXCHANGE == fuse.RENAME_EXCHANGE ||
-		req.Flags&fuse.RENAME_WHITEOUT == fuse.RENAME_WHITEOUT {
+	if req.Flags&fuse.RENAME_WHITEOUT == fuse.RENAME_WHITEOUT ||
+		(req.Flags&fuse.RENAME_EXCHANGE == fuse.RENAME_EXCHANGE && req.Flags&fuse.RENAME_NOREPLACE == fuse.RENAME_NOREPLACE) {
 		logger.Error("Rename2. Unsupported Flags ", logger.Fields{Operation: Rename2, Flags: req.Flags.String()})
 		return syscall.EINVAL
 	}
 
+	if err := srcParent.checkNotInSnapshot(req.OldName); err != nil {
+		return err
+	}
+	if err := dstParentDir.(*DirINode).checkNotInSnapshot(req.NewName); err != nil {
+		return err
+	}
+	if req.Flags&fuse.RENAME_EXCHANGE == fuse.RENAME_EXCHANGE {
+		return srcParent.exchangeInt(Exchange, req.OldName, req.NewName, dstParentDir.(*DirINode))
+	}
+
 	options := hdfs.RENAME_OPTION_NONE
 	if req.Flags&fuse.RENAME_NOREPLACE == fuse.RENAME_NOREPLACE {
 		options = options | hdfs.RENAME_NOREPLACE
@@ -437,6 +681,9 @@ func (dir *DirINode) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp
 
 	path := dir.AbsolutePath()
 
+	if dir.Snapshot {
+		return syscall.EROFS
+	}
 	if req.Valid.Size() {
 		logger.Error(fmt.Sprintf("Unsupported operation. Can not set size of a directory"), logger.Fields{Operation: Chmod, Path: path})
 		return syscall.ENOTSUP
@@ -463,22 +710,15 @@ func (dir *DirINode) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp
 	return nil
 }
 
-// Responds on FUSE request to forget inode
+// Responds on FUSE request to forget inode. The kernel has forgotten all the lookups of the dir
 func (dir *DirINode) Forget() {
 	dir.lockMutex()
 	defer dir.unlockMutex()
-	// inodes are removed on delete and rename operations.
-	// this forget call is redundant and it causes problems.
-	// In the mount point we identify inodes by names.
-	// For example, we remove a file /some/dir/file. Before
-	// the forget call is processed if the user recreates the
-	// file /some/dir/file then processing forget request
-	// would lead to deleting a correct inode
-	// to fix this issue we have to use inode IDs
 
-	// ask parent to remove me from the children list
-	// logger.Debug(fmt.Sprintf("Forget for dir %s", dir.Attrs.Name), nil)
-	// dir.Parent.removeChildInode(Forget, dir.Attrs.Name)
+	if dir.FileSystem.INodes.Forget(dir) && dir.Parent != nil {
+		logger.Debug("Forgot dir inode", logger.Fields{Operation: Forget, Path: dir.AbsolutePath()})
+		dir.Parent.forgetChildInode(Forget, dir.Attrs.Name)
+	}
 }
 
 func (dir *DirINode) lockMutex() {
@@ -497,9 +737,55 @@ func (dir *DirINode) unlockChildrenMutex() {
 	dir.childrenMutex.Unlock()
 }
 
+// Responds on FUSE Symlink request
 func (dir *DirINode) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (fs.Node, error) {
-	logger.Error("Unsupported Symlink operation.", logger.Fields{Operation: Symlink, Path: dir.AbsolutePath()})
-	return nil, syscall.ENOTSUP
+	dir.lockMutex()
+	defer dir.unlockMutex()
+
+	if err := dir.checkNotInSnapshot(req.NewName); err != nil {
+		return nil, err
+	}
+
+	linkPath := dir.AbsolutePathForChild(req.NewName)
+	userName, err := getUserName(req.Uid)
+	if err != nil {
+		logger.Error("Unable to find user information. ", logger.Fields{Operation: Symlink,
+			Path: linkPath, UID: req.Uid, HopsFSUserName: ForceOverrideUsername})
+		return nil, err
+	}
+
+	groupName, err := getGroupName(linkPath, req.Gid)
+	if err != nil {
+		logger.Error("Unable to find group information. ", logger.Fields{Operation: Symlink,
+			Path: linkPath, GID: req.Gid,
+			GetGroupFromHopsFSDatasetPath: UseGroupFromHopsFsDatasetPath})
+		return nil, err
+	}
+
+	err = dir.FileSystem.getDFSConnector().CreateSymlink(req.Target, linkPath)
+	if err != nil {
+		logger.Info("symlink failed", logger.Fields{Operation: Symlink, Path: linkPath, Error: err})
+		return nil, err
+	}
+
+	err = ChownOp(dir.FileSystem, linkPath, userName, groupName)
+	if err != nil {
+		logger.Warn("Unable to change ownership of new symlink", logger.Fields{Operation: Symlink, Path: linkPath,
+			UID: req.Uid, GID: req.Gid, Error: err})
+		//unable to change the ownership of the link. so delete it as the operation as a whole failed
+		dir.FileSystem.getDFSConnector().Remove(linkPath)
+		return nil, err
+	}
+
+	var attrs Attrs
+	newInode, err := dir.statInodeInHopsFS(Symlink, req.NewName, &attrs)
+	if err != nil {
+		return nil, err
+	}
+	dir.addToListing(req.NewName, newInode)
+	logger.Debug("symlink successful", logger.Fields{Operation: Symlink, Path: linkPath})
+	dir.FileSystem.INodes.Lookup(newInode)
+	return newInode, nil
 }
 
 func (dir *DirINode) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
@@ -0,0 +1,172 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"io"
+	"sync"
+	"unsafe"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// Represents a handle to an open directory. The listing is fetched from the backend page by page
+// as the kernel reads it, so that huge directories are never held in memory as a whole.
+// The offsets passed to the kernel are the indexes of the entries in the listing
+// Concurrency: thread safe
+type DirHandle struct {
+	Dir     *DirINode
+	lister  DirLister     // nil until the first page is fetched, and after the last one
+	entries []fuse.Dirent // listed entries not consumed by the kernel yet
+	offset  int64         // index of entries[0] in the listing
+	eof     bool          // the last entry of the listing is in entries
+	mutex   sync.Mutex
+}
+
+// Verify that *DirHandle implements necesary FUSE interfaces
+var _ fs.HandleReader = (*DirHandle)(nil)
+var _ fs.HandleReleaser = (*DirHandle)(nil)
+
+// Responds on FUSE request to read the directory, starting at the entry with index req.Offset
+func (dh *DirHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	dh.mutex.Lock()
+	defer dh.mutex.Unlock()
+
+	if req.Offset == 0 || req.Offset < dh.offset {
+		// rewinddir(3) or seekdir(3) backwards
+		dh.restart()
+	}
+
+	// drop the entries consumed by the kernel
+	for dh.offset < req.Offset {
+		if len(dh.entries) == 0 {
+			if dh.eof {
+				resp.Data = resp.Data[:0]
+				return nil
+			}
+			if err := dh.fetch(); err != nil {
+				return err
+			}
+			continue
+		}
+		dh.entries = dh.entries[1:]
+		dh.offset++
+	}
+
+	data := resp.Data[:0]
+	for i := 0; ; i++ {
+		if i == len(dh.entries) {
+			if dh.eof {
+				break
+			}
+			if err := dh.fetch(); err != nil {
+				if i > 0 {
+					// return the entries listed so far, the next read retries
+					break
+				}
+				return err
+			}
+			i--
+			continue
+		}
+		next := appendDirent(data, dh.entries[i], uint64(dh.offset)+uint64(i)+1)
+		if len(next) > req.Size {
+			break
+		}
+		data = next
+	}
+	resp.Data = data
+	return nil
+}
+
+// Responds on FUSE request to close the directory
+func (dh *DirHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	dh.mutex.Lock()
+	defer dh.mutex.Unlock()
+
+	dh.closeLister()
+	dh.entries = nil
+	return nil
+}
+
+// Starts the listing over. A listing cached by the directory is used if it has not expired
+func (dh *DirHandle) restart() {
+	dh.closeLister()
+	dh.entries = nil
+	dh.offset = 0
+	dh.eof = false
+	if entries := dh.Dir.getCachedListing(); entries != nil {
+		logger.Info("Read directory. Returning from Cache", logger.Fields{Operation: ReadDir, Path: dh.Dir.AbsolutePath(), Entries: len(entries)})
+		dh.entries = entries
+		dh.eof = true
+	}
+}
+
+// Appends the next page of the listing to entries
+func (dh *DirHandle) fetch() error {
+	dir := dh.Dir
+	dir.lockMutex()
+	defer dir.unlockMutex()
+
+	absolutePath := dir.AbsolutePath()
+	if dh.lister == nil {
+		logger.Info("Read directory", logger.Fields{Operation: ReadDir, Path: absolutePath})
+		lister, err := dir.FileSystem.getDFSConnector().OpenDir(absolutePath)
+		if err != nil {
+			logger.Warn("Failed to list DFS directory", logger.Fields{Operation: ReadDir, Path: absolutePath, Error: err})
+			return err
+		}
+		dh.lister = lister
+	}
+
+	page, err := dh.lister.Next(ReadDirPageSize)
+	if err == io.EOF {
+		dh.eof = true
+		dh.closeLister()
+		dh.entries = dir.appendSnapshotDirent(dh.entries)
+		if dh.offset == 0 {
+			// the whole listing is in memory, small directories are cached as before
+			dir.setCachedListing(dh.entries)
+		}
+		return nil
+	}
+	if err != nil {
+		logger.Warn("Failed to list DFS directory", logger.Fields{Operation: ReadDir, Path: absolutePath, Error: err})
+		return err
+	}
+
+	for _, a := range page {
+		if dir.FileSystem.IsPathAllowed(dir.AbsolutePathForChild(a.Name)) && !isTempSibling(a.Name) {
+			// Speculatively pre-creating child Dir or File node with cached attributes,
+			// since it's highly likely that we will have Lookup() call for this name
+			// This is the key trick which dramatically speeds up 'ls'
+			node := dir.addOrUpdateChildInodeAttrs(ReadDir, a.Name, a)
+			dh.entries = append(dh.entries, fuse.Dirent{
+				Inode: dir.FileSystem.inodeNumber(dir, a.Name, node),
+				Name:  a.Name,
+				Type:  a.FuseNodeType()})
+		}
+	}
+	logger.Debug("Listed directory page", logger.Fields{Operation: ReadDir, Path: absolutePath, Offset: dh.offset, Entries: len(page)})
+	return nil
+}
+
+func (dh *DirHandle) closeLister() {
+	if dh.lister != nil {
+		dh.lister.Close()
+		dh.lister = nil
+	}
+}
+
+// Encodes the entry like fuse.AppendDirent does, but with next as its offset. The kernel passes
+// the offset of the last entry it consumed back in the next read
+func appendDirent(data []byte, entry fuse.Dirent, next uint64) []byte {
+	start := len(data)
+	data = fuse.AppendDirent(data, entry)
+	// the offset follows the inode number in struct fuse_dirent
+	*(*uint64)(unsafe.Pointer(&data[start+8])) = next
+	return data
+}
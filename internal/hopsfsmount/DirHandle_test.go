@@ -0,0 +1,189 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Decodes the entries returned by a directory read, with the offsets passed back by the kernel
+func decodeDirents(data []byte) ([]fuse.Dirent, []int64) {
+	var entries []fuse.Dirent
+	var offsets []int64
+	for len(data) > 0 {
+		offsets = append(offsets, int64(binary.LittleEndian.Uint64(data[8:16])))
+		nameLen := int(binary.LittleEndian.Uint32(data[16:20]))
+		entries = append(entries, fuse.Dirent{
+			Inode: binary.LittleEndian.Uint64(data[0:8]),
+			Type:  fuse.DirentType(binary.LittleEndian.Uint32(data[20:24])),
+			Name:  string(data[24 : 24+nameLen])})
+		data = data[(24+nameLen+7)&^7:]
+	}
+	return entries, offsets
+}
+
+// Decodes the names of the entries returned by a directory read, see decodeDirents
+func parseDirents(data []byte) ([]string, []int64) {
+	entries, offsets := decodeDirents(data)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	return names, offsets
+}
+
+// Lists the directory through a handle, as the kernel does
+func readDir(dir *DirINode) ([]fuse.Dirent, error) {
+	h, err := dir.Open(nil, &fuse.OpenRequest{Dir: true}, &fuse.OpenResponse{})
+	if err != nil {
+		return nil, err
+	}
+	defer h.(*DirHandle).Release(nil, nil)
+	var entries []fuse.Dirent
+	off := int64(0)
+	for {
+		resp := &fuse.ReadResponse{}
+		if err := h.(*DirHandle).Read(nil, &fuse.ReadRequest{Dir: true, Offset: off, Size: 4096}, resp); err != nil {
+			return nil, err
+		}
+		page, offsets := decodeDirents(resp.Data)
+		if len(page) == 0 {
+			return entries, nil
+		}
+		entries = append(entries, page...)
+		off = offsets[len(offsets)-1]
+	}
+}
+
+func TestDirHandleStreaming(t *testing.T) {
+	pageSize := ReadDirPageSize
+	defer func() { ReadDirPageSize = pageSize }()
+	ReadDirPageSize = 7
+
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	assert.Nil(t, mem.Mkdir("/big", os.ModeDir|0755))
+	var expected []string
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("f%02d", i)
+		memWriteFile(t, mem, "/big/"+name, name)
+		expected = append(expected, name)
+	}
+	accessor := &listingCountingAccessor{MemHdfsAccessor: mem}
+	fs, _ := NewFileSystem([]HdfsAccessor{accessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "big")
+	assert.Nil(t, err)
+	h, err := node.(*DirINode).Open(nil, &fuse.OpenRequest{Dir: true}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	dh := h.(*DirHandle)
+
+	read := func(off int64, size int) ([]string, []int64) {
+		resp := &fuse.ReadResponse{Data: make([]byte, 0, size)}
+		assert.Nil(t, dh.Read(nil, &fuse.ReadRequest{Dir: true, Offset: off, Size: size}, resp))
+		assert.True(t, len(resp.Data) <= size)
+		return parseDirents(resp.Data)
+	}
+
+	// the kernel continues from the offset of the last entry it consumed
+	var listed []string
+	off := int64(0)
+	for {
+		names, offsets := read(off, 200)
+		if len(names) == 0 {
+			break
+		}
+		// only the first entries are consumed, the rest is returned again
+		consumed := (len(names) + 1) / 2
+		listed = append(listed, names[:consumed]...)
+		off = offsets[consumed-1]
+		assert.True(t, len(dh.entries) <= len(names)+ReadDirPageSize)
+	}
+	assert.Equal(t, expected, listed)
+	assert.Equal(t, 1, accessor.listings)
+	assert.Nil(t, dh.lister)
+
+	// seekdir and rewinddir start the listing over
+	names, _ := read(10, 200)
+	assert.Equal(t, "f10", names[0])
+	names, _ = read(0, 200)
+	assert.Equal(t, "f00", names[0])
+
+	// the listing of a huge directory is not cached, nodes are created for its entries
+	assert.Nil(t, node.(*DirINode).getCachedListing())
+	assert.NotNil(t, node.(*DirINode).getChildInode(Lookup, "f49"))
+	assert.Nil(t, dh.Release(nil, nil))
+}
+
+func TestDirHandleCachedListing(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "a")
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	dir := node.(*DirINode)
+
+	readAll := func() []string {
+		h, err := dir.Open(nil, &fuse.OpenRequest{Dir: true}, &fuse.OpenResponse{})
+		assert.Nil(t, err)
+		resp := &fuse.ReadResponse{}
+		assert.Nil(t, h.(*DirHandle).Read(nil, &fuse.ReadRequest{Dir: true, Size: 4096}, resp))
+		assert.Nil(t, h.(*DirHandle).Release(nil, nil))
+		names, _ := parseDirents(resp.Data)
+		return names
+	}
+
+	// small directories are listed in one page and cached
+	assert.Equal(t, []string{"a"}, readAll())
+	memWriteFile(t, mem, "/dir/b", "b")
+	assert.Equal(t, []string{"a"}, readAll())
+	mockClock.NotifyTimeElapsed(CacheDirListingTimeDuration)
+	assert.Equal(t, []string{"a", "b"}, readAll())
+}
+
+func TestDirHandleInodes(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	lister := NewMockDirLister(mockCtrl)
+	hdfsAccessor.EXPECT().OpenDir("/").Return(lister, nil)
+	expires := mockClock.Now().Add(time.Hour)
+	lister.EXPECT().Next(ReadDirPageSize).Return([]Attrs{
+		{Name: "a", Inode: 5, Expires: expires},
+		{Name: "b", Expires: expires},
+	}, nil)
+	lister.EXPECT().Next(ReadDirPageSize).Return(nil, io.EOF)
+	lister.EXPECT().Close().Return(nil)
+
+	// the entries whose file id is not known are given the id of the inode table
+	entries, err := readDir(root.(*DirINode))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, uint64(5), entries[0].Inode)
+	assert.NotEqual(t, uint64(0), entries[1].Inode)
+
+	// the same numbers are reported by stat
+	for _, e := range entries {
+		node, err := root.(*DirINode).Lookup(nil, e.Name)
+		assert.Nil(t, err)
+		var attr fuse.Attr
+		assert.Nil(t, node.Attr(nil, &attr))
+		assert.Equal(t, e.Inode, attr.Inode)
+	}
+}
@@ -4,6 +4,7 @@
 package hopsfsmount
 
 import (
+	"io"
 	"syscall"
 
 	"bazil.org/fuse"
@@ -61,14 +62,18 @@ func TestReadDirWithFiltering(t *testing.T) {
 	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
 	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"foo", "bar"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
 	root, _ := fs.Root()
-	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+	lister := NewMockDirLister(mockCtrl)
+	hdfsAccessor.EXPECT().OpenDir("/").Return(lister, nil)
+	lister.EXPECT().Next(ReadDirPageSize).Return([]Attrs{
 		{Name: "quz", Mode: os.ModeDir},
 		{Name: "foo", Mode: os.ModeDir},
 		{Name: "bar", Mode: os.ModeDir},
 		{Name: "foobar", Mode: os.ModeDir},
 		{Name: "baz", Mode: os.ModeDir},
 	}, nil)
-	dirents, err := root.(*DirINode).ReadDirAll(nil)
+	lister.EXPECT().Next(ReadDirPageSize).Return(nil, io.EOF)
+	lister.EXPECT().Close().Return(nil)
+	dirents, err := readDir(root.(*DirINode))
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(dirents))
 	assert.Equal(t, "foo", dirents[0].Name)
@@ -82,12 +87,16 @@ func TestReadDirWithZipExpansionDisabled(t *testing.T) {
 	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
 	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
 	root, _ := fs.Root()
-	hdfsAccessor.EXPECT().ReadDir("/").Return([]Attrs{
+	lister := NewMockDirLister(mockCtrl)
+	hdfsAccessor.EXPECT().OpenDir("/").Return(lister, nil)
+	lister.EXPECT().Next(ReadDirPageSize).Return([]Attrs{
 		{Name: "foo.zipx"},
 		{Name: "dir.zip", Mode: os.ModeDir},
 		{Name: "bar.zip"},
 	}, nil)
-	dirents, err := root.(*DirINode).ReadDirAll(nil)
+	lister.EXPECT().Next(ReadDirPageSize).Return(nil, io.EOF)
+	lister.EXPECT().Close().Return(nil)
+	dirents, err := readDir(root.(*DirINode))
 	assert.Nil(t, err)
 	assert.Equal(t, 3, len(dirents))
 	assert.Equal(t, "foo.zipx", dirents[0].Name)
@@ -145,3 +154,193 @@ func TestSetattr(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, uint32(0), node.(*DirINode).Attrs.Uid)
 }
+
+// Testing that the times set by touch, rsync -t and cp -p are stored in HopsFS
+func TestSetTimes(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	mockClock.now = time.Unix(1700000000, 0)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "a")
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	dir := node.(*DirINode)
+	node, err = dir.Lookup(nil, "a")
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+	stamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// both times are set
+	err = file.Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrAtime | fuse.SetattrMtime, Atime: stamp, Mtime: stamp.Add(time.Hour)}, &fuse.SetattrResponse{})
+	assert.Nil(t, err)
+	attrs, err := mem.Stat("/dir/a")
+	assert.Nil(t, err)
+	assert.Equal(t, stamp.Unix(), attrs.Atime.Unix())
+	assert.Equal(t, stamp.Add(time.Hour).Unix(), attrs.Mtime.Unix())
+	var attr fuse.Attr
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Equal(t, stamp.Unix(), attr.Atime.Unix())
+
+	// the time which is not set is kept
+	err = file.Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrMtime, Mtime: stamp}, &fuse.SetattrResponse{})
+	assert.Nil(t, err)
+	attrs, err = mem.Stat("/dir/a")
+	assert.Nil(t, err)
+	assert.Equal(t, stamp.Unix(), attrs.Atime.Unix())
+	assert.Equal(t, stamp.Unix(), attrs.Mtime.Unix())
+
+	// times of directories, set to the current time
+	err = dir.Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrMtime | fuse.SetattrMtimeNow}, &fuse.SetattrResponse{})
+	assert.Nil(t, err)
+	attrs, err = mem.Stat("/dir")
+	assert.Nil(t, err)
+	assert.Equal(t, mockClock.Now().Unix(), attrs.Mtime.Unix())
+	assert.Equal(t, mockClock.Now().Unix(), dir.Attrs.Mtime.Unix())
+
+	// the times set before the file is uploaded are kept, as cp -p does
+	node, h, err := dir.Create(nil, &fuse.CreateRequest{Name: "b", Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Data: []byte("b")}, &fuse.WriteResponse{}))
+	err = node.(*FileINode).Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrAtime | fuse.SetattrMtime, Atime: stamp, Mtime: stamp}, &fuse.SetattrResponse{})
+	assert.Nil(t, err)
+	mockClock.NotifyTimeElapsed(time.Minute)
+	assert.Nil(t, handle.Flush(nil, nil))
+	assert.Nil(t, handle.Release(nil, nil))
+	assert.Equal(t, "b", memReadFile(t, mem, "/dir/b"))
+	attrs, err = mem.Stat("/dir/b")
+	assert.Nil(t, err)
+	assert.Equal(t, stamp.Unix(), attrs.Mtime.Unix())
+}
+
+// Counts the directory listings served by the backend
+type listingCountingAccessor struct {
+	*MemHdfsAccessor
+	listings int
+}
+
+func (a *listingCountingAccessor) ReadDir(path string) ([]Attrs, error) {
+	a.listings++
+	return a.MemHdfsAccessor.ReadDir(path)
+}
+
+func (a *listingCountingAccessor) OpenDir(path string) (DirLister, error) {
+	a.listings++
+	return a.MemHdfsAccessor.OpenDir(path)
+}
+
+func direntNames(entries []fuse.Dirent) map[string]bool {
+	names := make(map[string]bool)
+	for _, e := range entries {
+		names[e.Name] = true
+	}
+	return names
+}
+
+// Testing whether directory listings are cached and patched by local changes
+func TestReadDirCaching(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	assert.Nil(t, mem.Mkdir("/other", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "a")
+	memWriteFile(t, mem, "/dir/b", "b")
+	memWriteFile(t, mem, "/other/c", "c")
+	accessor := &listingCountingAccessor{MemHdfsAccessor: mem}
+	fs, _ := NewFileSystem([]HdfsAccessor{accessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	dir := node.(*DirINode)
+	node, err = root.(*DirINode).Lookup(nil, "other")
+	assert.Nil(t, err)
+	other := node.(*DirINode)
+
+	entries, err := readDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, direntNames(entries))
+	_, err = readDir(other)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, accessor.listings)
+
+	// changes made through the mount are applied to the cached listings
+	assert.Nil(t, dir.Remove(nil, &fuse.RemoveRequest{Name: "a"}))
+	assert.Nil(t, dir.Rename(nil, &fuse.RenameRequest{OldName: "b", NewName: "c"}, other))
+	_, h, err := dir.Create(nil, &fuse.CreateRequest{Name: "d", Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*FileHandle).Release(nil, nil))
+	entries, err = readDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"d": true}, direntNames(entries))
+	entries, err = readDir(other)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"c": true}, direntNames(entries))
+	attrs, err := mem.Stat("/other/c")
+	assert.Nil(t, err)
+	assert.Equal(t, attrs.Inode, entries[0].Inode)
+	assert.Equal(t, 2, accessor.listings)
+
+	// the listing is fetched again after Mkdir, as the inode id of the new dir is not known
+	_, err = dir.Mkdir(nil, &fuse.MkdirRequest{Name: "e", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+	entries, err = readDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"d": true, "e": true}, direntNames(entries))
+	assert.Equal(t, 3, accessor.listings)
+
+	// changes made by other clients are seen once the listing expires
+	memWriteFile(t, mem, "/dir/f", "f")
+	entries, err = readDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	mockClock.NotifyTimeElapsed(CacheDirListingTimeDuration)
+	entries, err = readDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"d": true, "e": true, "f": true}, direntNames(entries))
+	assert.Equal(t, 4, accessor.listings)
+}
+
+func TestSymlink(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "a")
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	dir := node.(*DirINode)
+
+	link, err := dir.Symlink(nil, &fuse.SymlinkRequest{NewName: "l", Target: "a"})
+	assert.Nil(t, err)
+	var attr fuse.Attr
+	assert.Nil(t, link.Attr(nil, &attr))
+	assert.Equal(t, os.ModeSymlink, attr.Mode&os.ModeType)
+	assert.Equal(t, uint64(1), attr.Size)
+	target, err := link.(*FileINode).Readlink(nil, &fuse.ReadlinkRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, "a", target)
+	_, err = dir.Symlink(nil, &fuse.SymlinkRequest{NewName: "l", Target: "b"})
+	assert.Equal(t, syscall.EEXIST, err)
+
+	// links are listed as links, and their target is read from the backend if it is not known
+	entries, err := readDir(dir)
+	assert.Nil(t, err)
+	for _, e := range entries {
+		if e.Name == "l" {
+			assert.Equal(t, fuse.DT_Link, e.Type)
+		} else {
+			assert.Equal(t, fuse.DT_File, e.Type)
+		}
+	}
+	link.(*FileINode).Attrs.Target = ""
+	target, err = link.(*FileINode).Readlink(nil, &fuse.ReadlinkRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, "a", target)
+
+	file, err := dir.Lookup(nil, "a")
+	assert.Nil(t, err)
+	_, err = file.(*FileINode).Readlink(nil, &fuse.ReadlinkRequest{})
+	assert.Equal(t, syscall.EINVAL, err)
+}
@@ -0,0 +1,222 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse/fs"
+	"github.com/colinmarc/hdfs/v2"
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// HopsFS can not swap two entries atomically. RENAME_EXCHANGE is emulated with three renames:
+// the old entry is moved to a hidden temporary name, the new entry is moved in its place and the
+// temporary entry is moved in place of the new one. A record of the exchange is kept in a local
+// journal until the last rename succeeds. The exchanges interrupted by a crash are rolled forward
+// on the next mount, see RecoverExchanges
+
+// Prefix of the hidden temporary name of the old entry, created in its parent directory
+const exchangeTmpPrefix = ".hopsfs-exchange-"
+
+// Suffix of the files of the journal
+const exchangeRecordSuffix = ".exchange"
+
+// Record of an exchange in progress
+type exchangeRecord struct {
+	OldPath string `json:"old"`
+	NewPath string `json:"new"`
+	TmpPath string `json:"tmp"` // temporary name of the old entry, unique to the exchange
+}
+
+// Swaps two entries, which may be in different directories. Both of them must exist
+func (srcParent *DirINode) exchangeInt(operationName, oldName, newName string, dstParent *DirINode) error {
+	oldPath := srcParent.AbsolutePathForChild(oldName)
+	newPath := dstParent.AbsolutePathForChild(newName)
+	logger.Debug("Exchanging", logger.Fields{Operation: operationName, From: oldPath, To: newPath})
+
+	srcInode, err := srcParent.LookupInt(operationName, oldName)
+	if err != nil {
+		return err
+	}
+	dstInode, err := dstParent.LookupInt(operationName, newName)
+	if err != nil {
+		return err
+	}
+
+	record := exchangeRecord{
+		OldPath: oldPath,
+		NewPath: newPath,
+		TmpPath: srcParent.AbsolutePathForChild(fmt.Sprintf("%s%016x", exchangeTmpPrefix, rand.Uint64()))}
+	journalPath, err := srcParent.FileSystem.writeExchangeRecord(&record)
+	if err != nil {
+		logger.Error("Exchange failed. Unable to write the journal", logger.Fields{Operation: operationName, From: oldPath, To: newPath, Error: err})
+		return syscall.EIO
+	}
+	err = srcParent.FileSystem.exchange(&record, journalPath)
+	if err != nil {
+		logger.Error("Exchange failed at the backend", logger.Fields{Operation: operationName, From: oldPath, To: newPath, Error: err})
+		// the entries may be half way, they are looked up again
+		srcParent.removeChildInode(operationName, oldName)
+		srcParent.invalidateListing(operationName)
+		dstParent.removeChildInode(operationName, newName)
+		dstParent.invalidateListing(operationName)
+		return err
+	}
+
+	srcParent.removeChildInode(operationName, oldName)
+	dstParent.removeChildInode(operationName, newName)
+	moveInode(operationName, srcInode, dstParent, newName)
+	moveInode(operationName, dstInode, srcParent, oldName)
+
+	logger.Info("Exchanged", logger.Fields{Operation: operationName, From: oldPath, To: newPath})
+	return nil
+}
+
+// Attaches a file or dir inode to its new parent, under its new name
+func moveInode(operationName string, node fs.Node, parent *DirINode, name string) {
+	if fnode, ok := node.(*FileINode); ok {
+		fnode.Attrs.Name = name
+		fnode.Parent = parent
+	} else if dnode, ok := node.(*DirINode); ok {
+		dnode.Attrs.Name = name
+		dnode.Parent = parent
+	}
+	parent.adoptChildInode(operationName, name, node)
+	parent.addToListing(name, node)
+}
+
+// Runs the renames of an exchange. The first two renames are undone if the second one fails.
+// The record is removed unless the exchange is left half way
+func (filesystem *FileSystem) exchange(record *exchangeRecord, journalPath string) error {
+	connector := filesystem.getDFSConnector()
+	noReplace := hdfs.RenameOptions(hdfs.RENAME_NOREPLACE)
+	if err := connector.Rename2(record.OldPath, record.TmpPath, noReplace); err != nil {
+		removeExchangeRecord(journalPath)
+		return err
+	}
+	if err := connector.Rename2(record.NewPath, record.OldPath, noReplace); err != nil {
+		if connector.Rename2(record.TmpPath, record.OldPath, noReplace) == nil {
+			removeExchangeRecord(journalPath)
+		}
+		return err
+	}
+	if err := connector.Rename2(record.TmpPath, record.NewPath, noReplace); err != nil {
+		// the record is kept, the exchange is completed on the next mount
+		return err
+	}
+	removeExchangeRecord(journalPath)
+	return nil
+}
+
+// Completes an interrupted exchange. The progress is found from the entries which exist:
+// nothing is done if the temporary entry does not exist, the exchange has not started or is complete
+func (filesystem *FileSystem) rollForwardExchange(record *exchangeRecord) error {
+	connector := filesystem.getDFSConnector()
+	noReplace := hdfs.RenameOptions(hdfs.RENAME_NOREPLACE)
+	if _, err := connector.Stat(record.TmpPath); err == syscall.ENOENT {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := connector.Stat(record.OldPath); err == syscall.ENOENT {
+		if err := connector.Rename2(record.NewPath, record.OldPath, noReplace); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return connector.Rename2(record.TmpPath, record.NewPath, noReplace)
+}
+
+// Completes the exchanges which were interrupted while this file system was last mounted.
+// The records which can not be rolled forward are kept for the next mount
+func (filesystem *FileSystem) RecoverExchanges() {
+	if filesystem.JournalDir == "" {
+		return
+	}
+	files, err := os.ReadDir(filesystem.JournalDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("Unable to read the exchange journal", logger.Fields{Operation: Exchange, Path: filesystem.JournalDir, Error: err})
+		}
+		return
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), exchangeRecordSuffix) {
+			continue
+		}
+		journalPath := filepath.Join(filesystem.JournalDir, file.Name())
+		var record exchangeRecord
+		data, err := os.ReadFile(journalPath)
+		if err == nil {
+			err = json.Unmarshal(data, &record)
+		}
+		if err != nil {
+			logger.Warn("Unable to read an exchange record", logger.Fields{Operation: Exchange, Path: journalPath, Error: err})
+			continue
+		}
+		if err := filesystem.rollForwardExchange(&record); err != nil {
+			logger.Warn("Unable to complete an interrupted exchange", logger.Fields{Operation: Exchange, From: record.OldPath, To: record.NewPath, Error: err})
+			continue
+		}
+		logger.Info("Completed an interrupted exchange", logger.Fields{Operation: Exchange, From: record.OldPath, To: record.NewPath})
+		removeExchangeRecord(journalPath)
+	}
+}
+
+// Stores the record of an exchange in the journal, the record is on disk once this call returns.
+// Returns the path of the record, empty if the journal is disabled
+func (filesystem *FileSystem) writeExchangeRecord(record *exchangeRecord) (string, error) {
+	return filesystem.writeJournalRecord(filepath.Base(record.TmpPath)+exchangeRecordSuffix, record)
+}
+
+// Stores a record under the given name in the journal, see writeExchangeRecord
+func (filesystem *FileSystem) writeJournalRecord(name string, record interface{}) (string, error) {
+	if filesystem.JournalDir == "" {
+		return "", nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filesystem.JournalDir, 0700); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(filesystem.JournalDir, "record")
+	if err != nil {
+		return "", err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	// records are only read once complete
+	journalPath := filepath.Join(filesystem.JournalDir, name)
+	if err == nil {
+		err = os.Rename(file.Name(), journalPath)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return journalPath, nil
+}
+
+func removeExchangeRecord(journalPath string) {
+	if journalPath == "" {
+		return
+	}
+	if err := os.Remove(journalPath); err != nil {
+		logger.Warn("Unable to remove an exchange record", logger.Fields{Operation: Exchange, Path: journalPath, Error: err})
+	}
+}
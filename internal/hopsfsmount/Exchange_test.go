@@ -0,0 +1,113 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/colinmarc/hdfs/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameExchange(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	assert.Nil(t, mem.Mkdir("/other", os.ModeDir|0755))
+	assert.Nil(t, mem.Mkdir("/other/sub", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "a")
+	memWriteFile(t, mem, "/other/sub/b", "b")
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.JournalDir = t.TempDir()
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	dir := node.(*DirINode)
+	node, err = root.(*DirINode).Lookup(nil, "other")
+	assert.Nil(t, err)
+	other := node.(*DirINode)
+	file, err := dir.Lookup(nil, "a")
+	assert.Nil(t, err)
+	sub, err := other.Lookup(nil, "sub")
+	assert.Nil(t, err)
+
+	// a file and a directory in different parents are swapped
+	err = dir.Rename2(nil, &fuse.Rename2Request{OldName: "a", NewName: "sub", Flags: fuse.RENAME_EXCHANGE}, other)
+	assert.Nil(t, err)
+	attrs, err := mem.Stat("/dir/a")
+	assert.Nil(t, err)
+	assert.True(t, attrs.Mode.IsDir())
+	assert.Equal(t, "b", memReadFile(t, mem, "/dir/a/b"))
+	assert.Equal(t, "a", memReadFile(t, mem, "/other/sub"))
+
+	// the inodes are moved along with the entries
+	node, err = dir.Lookup(nil, "a")
+	assert.Nil(t, err)
+	assert.Equal(t, sub, node)
+	assert.Equal(t, dir, sub.(*DirINode).Parent)
+	assert.Equal(t, "a", sub.(*DirINode).Attrs.Name)
+	node, err = other.Lookup(nil, "sub")
+	assert.Nil(t, err)
+	assert.Equal(t, file, node)
+	assert.Equal(t, other, file.(*FileINode).Parent)
+	assert.Equal(t, "/other/sub", file.(*FileINode).AbsolutePath())
+	entries, err := readDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []fuse.Dirent{{Inode: sub.(*DirINode).Attrs.Inode, Type: fuse.DT_Dir, Name: "a"}}, entries)
+
+	// no temporary entry nor record is left
+	entries, err = readDir(other)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	records, err := os.ReadDir(fs.JournalDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+
+	// both entries must exist
+	err = dir.Rename2(nil, &fuse.Rename2Request{OldName: "a", NewName: "missing", Flags: fuse.RENAME_EXCHANGE}, other)
+	assert.Equal(t, syscall.ENOENT, err)
+	err = dir.Rename2(nil, &fuse.Rename2Request{OldName: "a", NewName: "sub", Flags: fuse.RENAME_EXCHANGE | fuse.RENAME_NOREPLACE}, other)
+	assert.Equal(t, syscall.EINVAL, err)
+}
+
+func TestRecoverExchanges(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "a")
+	memWriteFile(t, mem, "/dir/b", "b")
+	memWriteFile(t, mem, "/dir/c", "c")
+	memWriteFile(t, mem, "/dir/d", "d")
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.JournalDir = t.TempDir()
+	noReplace := hdfs.RenameOptions(hdfs.RENAME_NOREPLACE)
+
+	// interrupted after the first rename
+	first := exchangeRecord{OldPath: "/dir/a", NewPath: "/dir/b", TmpPath: "/dir/" + exchangeTmpPrefix + "1"}
+	_, err := fs.writeExchangeRecord(&first)
+	assert.Nil(t, err)
+	assert.Nil(t, mem.Rename2(first.OldPath, first.TmpPath, noReplace))
+	// interrupted after the second rename
+	second := exchangeRecord{OldPath: "/dir/c", NewPath: "/dir/d", TmpPath: "/dir/" + exchangeTmpPrefix + "2"}
+	_, err = fs.writeExchangeRecord(&second)
+	assert.Nil(t, err)
+	assert.Nil(t, mem.Rename2(second.OldPath, second.TmpPath, noReplace))
+	assert.Nil(t, mem.Rename2(second.NewPath, second.OldPath, noReplace))
+	// not started
+	third := exchangeRecord{OldPath: "/dir/x", NewPath: "/dir/y", TmpPath: "/dir/" + exchangeTmpPrefix + "3"}
+	_, err = fs.writeExchangeRecord(&third)
+	assert.Nil(t, err)
+
+	fs.RecoverExchanges()
+	assert.Equal(t, "b", memReadFile(t, mem, "/dir/a"))
+	assert.Equal(t, "a", memReadFile(t, mem, "/dir/b"))
+	assert.Equal(t, "d", memReadFile(t, mem, "/dir/c"))
+	assert.Equal(t, "c", memReadFile(t, mem, "/dir/d"))
+	allAttrs, err := mem.ReadDir("/dir")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(allAttrs))
+	records, err := os.ReadDir(fs.JournalDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+}
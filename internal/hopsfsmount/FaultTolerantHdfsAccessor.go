@@ -4,6 +4,7 @@ package hopsfsmount
 
 import (
 	"os"
+	"time"
 
 	"github.com/colinmarc/hdfs/v2"
 )
@@ -57,6 +58,12 @@ func (fta *FaultTolerantHdfsAccessor) CreateFile(path string, mode os.FileMode,
 	return fta.Impl.CreateFile(path, mode, overwrite)
 }
 
+// Opens HDFS file for appending
+func (fta *FaultTolerantHdfsAccessor) Append(path string) (HdfsWriter, error) {
+	// Not retried, the caller decides what to do if the file can not be appended
+	return fta.Impl.Append(path)
+}
+
 // Enumerates HDFS directory
 func (fta *FaultTolerantHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
 	op := fta.RetryPolicy.StartOperation()
@@ -71,6 +78,23 @@ func (fta *FaultTolerantHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
 	}
 }
 
+// Opens HDFS directory for enumerating it page by page. The pages are retried too
+func (fta *FaultTolerantHdfsAccessor) OpenDir(path string) (DirLister, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		result, err := fta.Impl.OpenDir(path)
+		if err == nil {
+			return &FaultTolerantDirLister{Impl: result, Accessor: fta.Impl, Path: path, RetryPolicy: fta.RetryPolicy}, nil
+		}
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] OpenDir: %s", path, err) {
+			return nil, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
 // Retrieves file/directory attributes
 func (fta *FaultTolerantHdfsAccessor) Stat(path string) (Attrs, error) {
 	op := fta.RetryPolicy.StartOperation()
@@ -113,6 +137,20 @@ func (fta *FaultTolerantHdfsAccessor) Mkdir(path string, mode os.FileMode) error
 	}
 }
 
+// Creates a directory along with its missing parents
+func (fta *FaultTolerantHdfsAccessor) MkdirAll(path string, mode os.FileMode) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.MkdirAll(path, mode)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] MkdirAll %s: %s", path, mode, err) {
+			return err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
 // Removes a file or directory
 func (fta *FaultTolerantHdfsAccessor) Remove(path string) error {
 	op := fta.RetryPolicy.StartOperation()
@@ -169,6 +207,20 @@ func (fta *FaultTolerantHdfsAccessor) Chmod(path string, mode os.FileMode) error
 	}
 }
 
+// Changes the access and modification times of a file or directory
+func (fta *FaultTolerantHdfsAccessor) SetTimes(path string, atime, mtime time.Time) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.SetTimes(path, atime, mtime)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("SetTimes [%s] to [%s %s]: %s", path, atime, mtime, err) {
+			return err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
 // Chown file or directory
 func (fta *FaultTolerantHdfsAccessor) Chown(path string, user, group string) error {
 	op := fta.RetryPolicy.StartOperation()
@@ -183,6 +235,132 @@ func (fta *FaultTolerantHdfsAccessor) Chown(path string, user, group string) err
 	}
 }
 
+// Creates a symbolic link
+func (fta *FaultTolerantHdfsAccessor) CreateSymlink(target string, link string) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.CreateSymlink(target, link)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] Symlink to %s: %s", link, target, err) {
+			return err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Returns the target of a symbolic link
+func (fta *FaultTolerantHdfsAccessor) ReadLink(path string) (string, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		target, err := fta.Impl.ReadLink(path)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] ReadLink: %s", path, err) {
+			return target, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Returns the value of an extended attribute
+func (fta *FaultTolerantHdfsAccessor) GetXAttr(path string, name string) ([]byte, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		value, err := fta.Impl.GetXAttr(path, name)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] GetXAttr %s: %s", path, name, err) {
+			return value, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Returns the names of the extended attributes
+func (fta *FaultTolerantHdfsAccessor) ListXAttrs(path string) ([]string, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		names, err := fta.Impl.ListXAttrs(path)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] ListXAttrs: %s", path, err) {
+			return names, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Creates or replaces an extended attribute
+func (fta *FaultTolerantHdfsAccessor) SetXAttr(path string, name string, value []byte) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.SetXAttr(path, name, value)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] SetXAttr %s: %s", path, name, err) {
+			return err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Removes an extended attribute
+func (fta *FaultTolerantHdfsAccessor) RemoveXAttr(path string, name string) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.RemoveXAttr(path, name)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] RemoveXAttr %s: %s", path, name, err) {
+			return err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Retrieves the HopsFS metadata of a file or directory
+func (fta *FaultTolerantHdfsAccessor) GetMetadata(path string) (Metadata, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		metadata, err := fta.Impl.GetMetadata(path)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] GetMetadata: %s", path, err) {
+			return metadata, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Retrieves the summary of a subtree
+func (fta *FaultTolerantHdfsAccessor) GetContentSummary(path string) (ContentSummary, error) {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		summary, err := fta.Impl.GetContentSummary(path)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] GetContentSummary: %s", path, err) {
+			return summary, err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
+// Changes the replication factor of a file
+func (fta *FaultTolerantHdfsAccessor) SetReplication(path string, replication uint16) error {
+	op := fta.RetryPolicy.StartOperation()
+	for {
+		err := fta.Impl.SetReplication(path, replication)
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] SetReplication %d: %s", path, replication, err) {
+			return err
+		} else {
+			// Clean up the bad connection, to let underline connection to get automatic refresh
+			fta.Impl.Close()
+		}
+	}
+}
+
 // Close underline connection if needed
 func (fta *FaultTolerantHdfsAccessor) Close() error {
 	return fta.Impl.Close()
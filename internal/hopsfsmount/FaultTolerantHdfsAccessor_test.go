@@ -5,7 +5,9 @@ package hopsfsmount
 
 import (
 	"errors"
+	"io"
 	"os"
+	"syscall"
 	"testing"
 	"time"
 
@@ -64,6 +66,37 @@ func TestReadDirWithRetries(t *testing.T) {
 	assert.Equal(t, 10, len(result))
 }
 
+// Testing retry logic for paginated listings. A reopened listing continues after the last returned entry
+func TestOpenDirWithRetries(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	lister1 := NewMockDirLister(mockCtrl)
+	lister2 := NewMockDirLister(mockCtrl)
+	hdfsAccessor.EXPECT().OpenDir("/test/dir").Return(lister1, nil)
+	lister1.EXPECT().Next(2).Return([]Attrs{{Name: "a"}, {Name: "b"}}, nil)
+	lister1.EXPECT().Next(2).Return(nil, errors.New("Injected failure"))
+	lister1.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().OpenDir("/test/dir").Return(lister2, nil)
+	lister2.EXPECT().Next(2).Return([]Attrs{{Name: "a"}, {Name: "b"}}, nil)
+	lister2.EXPECT().Next(2).Return([]Attrs{{Name: "c"}}, nil)
+	lister2.EXPECT().Next(2).Return(nil, io.EOF)
+	lister2.EXPECT().Close().Return(nil)
+
+	lister, err := ftHdfsAccessor.OpenDir("/test/dir")
+	assert.Nil(t, err)
+	page, err := lister.Next(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []Attrs{{Name: "a"}, {Name: "b"}}, page)
+	page, err = lister.Next(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []Attrs{{Name: "c"}}, page)
+	_, err = lister.Next(2)
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, lister.Close())
+}
+
 // generates a test retry policy which allows 2 attempst
 func atMost2Attempts() *RetryPolicy {
 	clock := &MockClock{}
@@ -72,3 +105,74 @@ func atMost2Attempts() *RetryPolicy {
 	rp.TimeLimit = time.Hour
 	return rp
 }
+
+// Testing that unsupported operations are not retried
+func TestCreateSymlinkNotSupported(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	hdfsAccessor.EXPECT().CreateSymlink("target", "/test/link").Return(syscall.ENOTSUP)
+	err := ftHdfsAccessor.CreateSymlink("target", "/test/link")
+	assert.Equal(t, syscall.ENOTSUP, err)
+	hdfsAccessor.EXPECT().ReadLink("/test/file").Return("", syscall.EINVAL)
+	_, err = ftHdfsAccessor.ReadLink("/test/file")
+	assert.Equal(t, syscall.EINVAL, err)
+
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{}, syscall.EINVAL)
+	_, err = ftHdfsAccessor.Stat("/test/file")
+	assert.Equal(t, syscall.EINVAL, err)
+
+	// the connection errors are retried
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{}, syscall.EIO)
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().Stat("/test/file").Return(Attrs{Name: "file"}, nil)
+	attrs, err := ftHdfsAccessor.Stat("/test/file")
+	assert.Nil(t, err)
+	assert.Equal(t, "file", attrs.Name)
+}
+
+// Testing retry logic for SetXAttr()
+func TestSetXAttrWithRetries(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	hdfsAccessor.EXPECT().SetXAttr("/test/file", "user.key", []byte("value")).Return(errors.New("Injected failure"))
+	hdfsAccessor.EXPECT().SetXAttr("/test/file", "user.key", []byte("value")).Return(nil)
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	err := ftHdfsAccessor.SetXAttr("/test/file", "user.key", []byte("value"))
+	assert.Nil(t, err)
+}
+
+func TestSetReplicationWithRetries(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	hdfsAccessor.EXPECT().SetReplication("/test/file", uint16(2)).Return(errors.New("Injected failure"))
+	hdfsAccessor.EXPECT().SetReplication("/test/file", uint16(2)).Return(nil)
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	err := ftHdfsAccessor.SetReplication("/test/file", 2)
+	assert.Nil(t, err)
+}
+
+// Testing that missing xattrs are not retried
+func TestGetXAttrNotFound(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	hdfsAccessor.EXPECT().GetXAttr("/test/file", "user.key").Return(nil, syscall.ENODATA)
+	_, err := ftHdfsAccessor.GetXAttr("/test/file", "user.key")
+	assert.Equal(t, syscall.ENODATA, err)
+}
+
+// Testing retry logic for SetTimes()
+func TestSetTimesWithRetries(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	ftHdfsAccessor := NewFaultTolerantHdfsAccessor(hdfsAccessor, atMost2Attempts())
+	stamp := time.Unix(1700000000, 0)
+	hdfsAccessor.EXPECT().SetTimes("/test/file", stamp, stamp).Return(errors.New("Injected failure"))
+	hdfsAccessor.EXPECT().SetTimes("/test/file", stamp, stamp).Return(nil)
+	hdfsAccessor.EXPECT().Close().Return(nil)
+	err := ftHdfsAccessor.SetTimes("/test/file", stamp, stamp)
+	assert.Nil(t, err)
+}
@@ -6,7 +6,6 @@ package hopsfsmount
 
 import (
 	"fmt"
-	"io"
 	"io/ioutil"
 	"math/rand"
 	"os"
@@ -26,11 +25,13 @@ type FileINode struct {
 	FileSystem *FileSystem // pointer to the FieSystem which owns this file
 	Attrs      Attrs       // Cache of file attributes // TODO: implement TTL
 	Parent     *DirINode   // Pointer to the parent directory (allows computing fully-qualified paths on demand)
+	Snapshot   bool        // The file is in a snapshot, it is read-only
 
 	activeHandles   []*FileHandle // list of opened file handles
 	fileMutex       sync.Mutex    // mutex for file operation such as open, delete
 	fileProxy       FileProxy     // file proxy. Could be LocalRWFileProxy or RemoteFileProxy
 	fileHandleMutex sync.Mutex    // mutex for file handle
+	timesSet        bool          // times set while the file is open for writing, set again after the upload. Guarded by fileHandleMutex
 }
 
 // Verify that *File implements necesary FUSE interfaces
@@ -39,6 +40,11 @@ var _ fs.NodeOpener = (*FileINode)(nil)
 var _ fs.NodeFsyncer = (*FileINode)(nil)
 var _ fs.NodeSetattrer = (*FileINode)(nil)
 var _ fs.NodeForgetter = (*FileINode)(nil)
+var _ fs.NodeReadlinker = (*FileINode)(nil)
+var _ fs.NodeGetxattrer = (*FileINode)(nil)
+var _ fs.NodeListxattrer = (*FileINode)(nil)
+var _ fs.NodeSetxattrer = (*FileINode)(nil)
+var _ fs.NodeRemovexattrer = (*FileINode)(nil)
 
 // Retuns absolute path of the file in HDFS namespace
 func (file *FileINode) AbsolutePath() string {
@@ -63,6 +69,9 @@ func (file *FileINode) Attr(ctx context.Context, a *fuse.Attr) error {
 		// update the local cache
 		file.Attrs.Size = uint64(fileInfo.Size())
 		file.Attrs.Mtime = fileInfo.ModTime()
+	} else if streamingProxy, ok := file.fileProxy.(*StreamingWriteFileProxy); ok {
+		file.Attrs.Size = uint64(streamingProxy.Size())
+		file.Attrs.Mtime = file.FileSystem.Clock.Now()
 	} else {
 		if file.FileSystem.Clock.Now().After(file.Attrs.Expires) {
 			_, err := file.Parent.statInodeInHopsFS(GetattrFile, file.Attrs.Name, &file.Attrs)
@@ -73,8 +82,11 @@ func (file *FileINode) Attr(ctx context.Context, a *fuse.Attr) error {
 			logger.Info("Stat successful. Returning from Cache ", logger.Fields{Operation: GetattrFile, Path: file.AbsolutePath(), FileSize: file.Attrs.Size, IsDir: file.Attrs.Mode.IsDir(), IsRegular: file.Attrs.Mode.IsRegular()})
 		}
 	}
-	return file.Attrs.ConvertAttrToFuse(a)
-
+	if err := file.Attrs.ConvertAttrToFuse(a); err != nil {
+		return err
+	}
+	a.Inode = file.FileSystem.inodeNumber(file.Parent, file.Attrs.Name, file)
+	return nil
 }
 
 // Responds to the FUSE file open request (creates new file handle)
@@ -83,6 +95,9 @@ func (file *FileINode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fu
 	defer file.unlockFile()
 
 	logger.Debug("Opening file", logger.Fields{Operation: Open, Path: file.AbsolutePath(), Flags: req.Flags, FileSize: file.Attrs.Size})
+	if file.Snapshot && !req.Flags.IsReadOnly() {
+		return nil, syscall.EROFS
+	}
 	handle, err := file.NewFileHandle(true, req.Flags)
 	if err != nil {
 		return nil, err
@@ -157,6 +172,21 @@ func (file *FileINode) Fsync(ctx context.Context, req *fuse.FsyncRequest) error
 	return retErr
 }
 
+// Sets again the times set while the file was open for writing, once the file has been uploaded
+func (file *FileINode) restoreTimes(operation string) {
+	file.lockFileHandles()
+	timesSet := file.timesSet
+	file.timesSet = false
+	file.unlockFileHandles()
+	if !timesSet {
+		return
+	}
+	path := file.AbsolutePath()
+	if err := file.FileSystem.getDFSConnector().SetTimes(path, file.Attrs.Atime, file.Attrs.Mtime); err != nil {
+		logger.Warn("Unable to restore the times of the file", file.logInfo(logger.Fields{Operation: operation, Error: err}))
+	}
+}
+
 // Invalidates metadata cache, so next ls or stat gives up-to-date file attributes
 func (file *FileINode) InvalidateMetadataCache() {
 	logger.Debug("InvalidateMetadataCache ", file.logInfo(logger.Fields{}))
@@ -170,6 +200,9 @@ func (file *FileINode) Setattr(ctx context.Context, req *fuse.SetattrRequest, re
 
 	logger.Debug("Setattr request received: ", logger.Fields{Operation: Setattr})
 
+	if file.Snapshot {
+		return syscall.EROFS
+	}
 	if req.Valid.Size() {
 		var err_out error = nil
 		logger.Info(fmt.Sprintf("Dispatching truncate request to all open handles: %d", len(file.activeHandles)), logger.Fields{Operation: Setattr})
@@ -201,18 +234,58 @@ func (file *FileINode) Setattr(ctx context.Context, req *fuse.SetattrRequest, re
 	if err := UpdateTS(&file.Attrs, file.FileSystem, path, req, resp); err != nil {
 		return err
 	}
+	if (req.Valid.Atime() || req.Valid.Mtime()) && file.isOpenForWriting() {
+		// e.g. cp -p sets the times before closing the file, the upload would change them
+		file.lockFileHandles()
+		file.timesSet = true
+		file.unlockFileHandles()
+	}
 
 	return nil
 }
 
-// Responds on FUSE request to forget inode
+// Responds on FUSE request to forget inode. The kernel has forgotten all the lookups of the file
 func (file *FileINode) Forget() {
 	file.lockFile()
 	defer file.unlockFile()
-	// see comment in Dir.go for Forget handler
-	// ask parent to remove me from the children list
-	// logger.Debug(fmt.Sprintf("Forget for file %s", file.Attrs.Name), nil)
-	// file.Parent.removeChildInode(Forget, file.Attrs.Name)
+
+	if file.FileSystem.INodes.Forget(file) {
+		logger.Debug("Forgot file inode", file.logInfo(logger.Fields{Operation: Forget}))
+		file.Parent.forgetChildInode(Forget, file.Attrs.Name)
+	}
+}
+
+// Responds on FUSE Readlink request. Symbolic links are file inodes with os.ModeSymlink
+func (file *FileINode) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	file.lockFile()
+	defer file.unlockFile()
+
+	if file.Attrs.Mode&os.ModeSymlink == 0 {
+		return "", syscall.EINVAL
+	}
+	if file.Attrs.Target != "" {
+		return file.Attrs.Target, nil
+	}
+	target, err := file.FileSystem.getDFSConnector().ReadLink(file.AbsolutePath())
+	if err != nil {
+		logger.Warn("Failed to read symlink", file.logInfo(logger.Fields{Operation: ReadLink, Error: err}))
+		return "", err
+	}
+	file.Attrs.Target = target
+	return target, nil
+}
+
+// Returns true if the file has handles open for writing through this mount
+func (file *FileINode) isOpenForWriting() bool {
+	file.lockFileHandles()
+	defer file.unlockFileHandles()
+
+	for _, handle := range file.activeHandles {
+		if !handle.fileFlags.IsReadOnly() {
+			return true
+		}
+	}
+	return false
 }
 
 func (file *FileINode) countActiveHandles() int {
@@ -221,66 +294,68 @@ func (file *FileINode) countActiveHandles() int {
 	return len(file.activeHandles)
 }
 
-func (file *FileINode) createStagingFile(operation string, existsInDFS bool) (*os.File, error) {
+// Creates the staging file of the file and returns it together with the size of the file in DFS.
+// The staging file has the size of the file in DFS and its content is fetched on demand, unless
+// truncate is set, then the staging file is empty
+func (file *FileINode) createStagingFile(operation string, existsInDFS bool, truncate bool) (*os.File, Attrs, error) {
 	if file.fileProxy != nil {
-		return nil, nil // there is already an active handle.
+		return nil, Attrs{}, nil // there is already an active handle.
 	}
 
 	//create staging file
 	absPath := file.AbsolutePath()
+	remote := Attrs{} // attributes of the file in DFS, the attributes of a new file are not known
 	hdfsAccessor := file.FileSystem.getDFSConnector()
 	if !existsInDFS { // it  is a new file so create it in the DFS
 		w, err := hdfsAccessor.CreateFile(absPath, ComputePermissions(file.Attrs.Mode), false)
 		if err != nil {
 			logger.Error("Failed to create file in DFS", file.logInfo(logger.Fields{Operation: operation, Error: err}))
-			return nil, err
+			return nil, Attrs{}, err
 		}
 		logger.Info("Created an empty file in DFS", file.logInfo(logger.Fields{Operation: operation}))
 		w.Close()
 	} else {
 		// Request to write to existing file
-		_, err := hdfsAccessor.Stat(absPath)
+		attrs, err := hdfsAccessor.Stat(absPath)
 		if err != nil {
 			logger.Error("Failed to stat file in DFS", file.logInfo(logger.Fields{Operation: operation, Error: err}))
-			return nil, syscall.ENOENT
+			return nil, Attrs{}, syscall.ENOENT
 		}
+		remote = attrs
 	}
+	remoteSize := int64(remote.Size)
 
 	stagingFile, err := ioutil.TempFile(StagingDir, "stage")
 	if err != nil {
 		logger.Error("Failed to create staging file", file.logInfo(logger.Fields{Operation: operation, Error: err}))
-		return nil, err
+		return nil, Attrs{}, err
 	}
 	os.Remove(stagingFile.Name())
 	logger.Info("Created staging file", file.logInfo(logger.Fields{Operation: operation, TmpFile: stagingFile.Name()}))
 
-	if existsInDFS {
-		if err := file.downloadToStaging(stagingFile, operation); err != nil {
-			return nil, err
-		}
+	if truncate {
+		logger.Info("Discarding the existing content", file.logInfo(logger.Fields{Operation: operation, FileSize: remoteSize}))
+		return stagingFile, remote, nil
 	}
-	return stagingFile, nil
-}
-
-func (file *FileINode) downloadToStaging(stagingFile *os.File, operation string) error {
-	hdfsAccessor := file.FileSystem.getDFSConnector()
-	absPath := file.AbsolutePath()
 
-	reader, err := hdfsAccessor.OpenRead(absPath)
-	if err != nil {
-		logger.Error("Failed to open file in DFS", file.logInfo(logger.Fields{Operation: operation, Error: err}))
-		// TODO remove the staging file if there are no more active handles
-		return err
+	// the content is fetched on demand, see LocalRWFileProxy
+	if err := stagingFile.Truncate(remoteSize); err != nil {
+		logger.Error("Failed to resize staging file", file.logInfo(logger.Fields{Operation: operation, Error: err}))
+		stagingFile.Close()
+		return nil, Attrs{}, err
 	}
+	return stagingFile, remote, nil
+}
 
-	nc, err := io.Copy(stagingFile, reader)
+// Creates the file in DFS and keeps it open for writing
+func (file *FileINode) createStreamingProxy(operation string) (*StreamingWriteFileProxy, error) {
+	w, err := file.FileSystem.getDFSConnector().CreateFile(file.AbsolutePath(), ComputePermissions(file.Attrs.Mode), false)
 	if err != nil {
-		logger.Error("Failed to copy content to staging file", file.logInfo(logger.Fields{Operation: operation, Error: err}))
-		return err
+		logger.Error("Failed to create file in DFS", file.logInfo(logger.Fields{Operation: operation, Error: err}))
+		return nil, err
 	}
-	reader.Close()
-	logger.Info(fmt.Sprintf("Downloaded a copy to stating dir. %d bytes copied", nc), file.logInfo(logger.Fields{Operation: operation}))
-	return nil
+	logger.Info("Created file in DFS for streaming", file.logInfo(logger.Fields{Operation: operation}))
+	return &StreamingWriteFileProxy{hdfsWriter: w, file: file}, nil
 }
 
 // Creates new file handle
@@ -299,14 +374,23 @@ func (file *FileINode) NewFileHandle(existsInDFS bool, flags fuse.OpenFlags) (*F
 		if file.fileProxy != nil {
 			logger.Panic("Unexpected file state during creation", file.logInfo(logger.Fields{Flags: flags}))
 		}
+		if StreamingWrites {
+			streamingProxy, err := file.createStreamingProxy(operation)
+			if err != nil {
+				return nil, err
+			}
+			fh.File.fileProxy = streamingProxy
+			logger.Info("Opened file, streaming RW handle", fh.logInfo(logger.Fields{Operation: operation, Flags: fh.fileFlags}))
+			return fh, nil
+		}
 		if err := file.checkDiskSpace(); err != nil {
 			return nil, err
 		}
-		stagingFile, err := file.createStagingFile(operation, existsInDFS)
+		stagingFile, remote, err := file.createStagingFile(operation, existsInDFS, false)
 		if err != nil {
 			return nil, err
 		}
-		fh.File.fileProxy = &LocalRWFileProxy{localFile: stagingFile, file: file}
+		fh.File.fileProxy = newLocalRWFileProxy(stagingFile, file, remote)
 		logger.Info("Opened file, RW handle", fh.logInfo(logger.Fields{Operation: operation, Flags: fh.fileFlags}))
 	} else {
 		if file.fileProxy != nil {
@@ -316,12 +400,18 @@ func (file *FileINode) NewFileHandle(existsInDFS bool, flags fuse.OpenFlags) (*F
 			// we alway open the file in RO mode. when the client writes to the file
 			// then we upgrade the handle. However, if the file is already opened in
 			// in RW state then we use the existing RW handle
+			if file.FileSystem.BlockCache != nil && file.FileSystem.Clock.Now().After(file.Attrs.Expires) {
+				// the cached blocks are validated against the attributes
+				if _, err := file.Parent.statInodeInHopsFS(operation, file.Attrs.Name, &file.Attrs); err != nil {
+					return nil, err
+				}
+			}
 			reader, err := file.FileSystem.getDFSConnector().OpenRead(file.AbsolutePath())
 			if err != nil {
 				logger.Warn("Opening file failed", fh.logInfo(logger.Fields{Operation: operation, Flags: fh.fileFlags, Error: err}))
 				return nil, err
 			} else {
-				fh.File.fileProxy = &RemoteROFileProxy{hdfsReader: reader, file: file}
+				fh.File.fileProxy = newRemoteROFileProxy(reader, file)
 				logger.Info("Opened file, RO handle", fh.logInfo(logger.Fields{Operation: operation, Flags: fh.fileFlags}))
 			}
 		}
@@ -330,14 +420,18 @@ func (file *FileINode) NewFileHandle(existsInDFS bool, flags fuse.OpenFlags) (*F
 	return fh, nil
 }
 
-// changes RO file handle to RW
-func (file *FileINode) upgradeHandleForWriting(me *FileHandle, operation string) error {
+// changes RO file handle to RW. If truncate is set the existing content is discarded, the staging
+// file starts empty. The kernel does not pass O_TRUNC on open, as atomic O_TRUNC is not negotiated,
+// it truncates the file opened for writing with Setattr(size=0) instead
+func (file *FileINode) upgradeHandleForWriting(me *FileHandle, operation string, truncate bool) error {
 	file.lockFileHandles()
 	defer file.unlockFileHandles()
 
 	var upgrade = false
 	if _, ok := file.fileProxy.(*LocalRWFileProxy); ok {
 		upgrade = false
+	} else if _, ok := file.fileProxy.(*StreamingWriteFileProxy); ok {
+		upgrade = false
 	} else if _, ok := file.fileProxy.(*RemoteROFileProxy); ok {
 		upgrade = true
 	} else {
@@ -359,19 +453,23 @@ func (file *FileINode) upgradeHandleForWriting(me *FileHandle, operation string)
 		}
 
 		remoteROFileProxy, _ := file.fileProxy.(*RemoteROFileProxy)
-		remoteROFileProxy.hdfsReader.Close() // close this read only handle
+		remoteROFileProxy.Close() // close this read only handle
 		file.fileProxy = nil
 
 		if err := file.checkDiskSpace(); err != nil {
 			return err
 		}
 
-		stagingFile, err := file.createStagingFile("Open", true)
+		stagingFile, remote, err := file.createStagingFile("Open", true, truncate)
 		if err != nil {
 			return err
 		}
 
-		file.fileProxy = &LocalRWFileProxy{localFile: stagingFile, file: file}
+		file.fileProxy = newLocalRWFileProxy(stagingFile, file, remote)
+		if truncate {
+			// the discarded content counts as modified, so that the file is replaced on flush
+			me.totalBytesWritten += int64(remote.Size)
+		}
 		logger.Info("Open handle upgrade to support RW ", file.logInfo(logger.Fields{Operation: operation}))
 		return nil
 	}
@@ -6,7 +6,9 @@ package hopsfsmount
 import (
 	"io"
 	"os"
+	"syscall"
 	"testing"
+	"time"
 
 	"bazil.org/fuse"
 	"github.com/golang/mock/gomock"
@@ -69,7 +71,12 @@ func TestFaultTolerantWriteFile(t *testing.T) {
 
 	hdfsAccessor.EXPECT().StatFs().Return(FsInfo{capacity: uint64(100), used: uint64(20), remaining: uint64(80)}, nil).AnyTimes()
 	hdfsAccessor.EXPECT().Remove("/testWriteFile_1").Return(nil).AnyTimes()
-	hdfsAccessor.EXPECT().CreateFile(fileName, os.FileMode(0757), gomock.Any()).DoAndReturn(func(path string,
+	// the file is uploaded to a temporary sibling which then replaces the file
+	hdfsAccessor.EXPECT().Remove(gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().Chmod(gomock.Any(), os.FileMode(0757)).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().Rename2(gomock.Any(), fileName, gomock.Any()).Return(nil).AnyTimes()
+	hdfsAccessor.EXPECT().ListXAttrs(gomock.Any()).Return(nil, nil).AnyTimes()
+	hdfsAccessor.EXPECT().CreateFile(gomock.Any(), os.FileMode(0757), gomock.Any()).DoAndReturn(func(path string,
 		mode os.FileMode, overwrite bool) (HdfsWriter, error) {
 		return hdfswriter, nil
 	}).AnyTimes()
@@ -159,3 +166,309 @@ func TestFlushFile(t *testing.T) {
 	err = fileHandle.Release(nil, nil)
 	assert.Nil(t, err)
 }
+
+func TestAppendOnFlush(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	memWriteFile(t, mem, "/log", "hello")
+	before, err := mem.Stat("/log")
+	assert.Nil(t, err)
+
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "log")
+	assert.Nil(t, err)
+	h, err := node.(*FileINode).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+
+	// appended data is sent to the existing file
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte(" world"), Offset: 5}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	after, err := mem.Stat("/log")
+	assert.Nil(t, err)
+	assert.Equal(t, before.Inode, after.Inode)
+	assert.Equal(t, "hello world", memReadFile(t, mem, "/log"))
+
+	// only the new tail is appended on the next flush
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("!"), Offset: 11}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	after, err = mem.Stat("/log")
+	assert.Nil(t, err)
+	assert.Equal(t, before.Inode, after.Inode)
+	assert.Equal(t, "hello world!", memReadFile(t, mem, "/log"))
+
+	// modifying the existing content rewrites the file
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("J"), Offset: 0}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	after, err = mem.Stat("/log")
+	assert.Nil(t, err)
+	assert.NotEqual(t, before.Inode, after.Inode)
+	assert.Equal(t, "Jello world!", memReadFile(t, mem, "/log"))
+
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+func TestAppendOnFlushAfterRemoteChange(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	memWriteFile(t, mem, "/log", "hello")
+
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "log")
+	assert.Nil(t, err)
+	h, err := node.(*FileINode).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+
+	// the file is replaced by one of the same size, the tail is not appended to it
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte(" world"), Offset: 5}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	memWriteFile(t, mem, "/log", "HELLO")
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Equal(t, "hello world", memReadFile(t, mem, "/log"))
+
+	// the file is modified in place without changing its size
+	mockClock.now = mockClock.now.Add(time.Second)
+	memAppendFile(t, mem, "/log", "")
+	before, err := mem.Stat("/log")
+	assert.Nil(t, err)
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("!"), Offset: 11}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	after, err := mem.Stat("/log")
+	assert.Nil(t, err)
+	assert.NotEqual(t, before.Inode, after.Inode)
+	assert.Equal(t, "hello world!", memReadFile(t, mem, "/log"))
+
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+func TestAtomicReplaceOnFlush(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	memWriteFile(t, mem, "/readonly", "hello world")
+	assert.Nil(t, mem.Chmod("/readonly", 0444))
+
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "readonly")
+	assert.Nil(t, err)
+	h, err := node.(*FileINode).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("J"), Offset: 0}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+
+	assert.Equal(t, "Jello world", memReadFile(t, mem, "/readonly"))
+	attrs, err := mem.Stat("/readonly")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0444), attrs.Mode)
+	assert.Equal(t, mem.UserName, attrs.DFSUserName)
+
+	// no temporary files are left behind
+	entries, err := mem.ReadDir("/")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+}
+
+func TestXAttrsSurviveFlush(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	memWriteFile(t, mem, "/file", "hello world")
+	assert.Nil(t, mem.SetXAttr("/file", "user.tag", []byte("x")))
+
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "file")
+	assert.Nil(t, err)
+	h, err := node.(*FileINode).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+
+	// the file is rewritten, through a temporary sibling which replaces it
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("J"), Offset: 0}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+
+	assert.Equal(t, "Jello world", memReadFile(t, mem, "/file"))
+	value, err := mem.GetXAttr("/file", "user.tag")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("x"), value)
+}
+
+func TestLazyStaging(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	data := make([]byte, 3*stagingBlockSize+stagingBlockSize/2)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	memWriteFile(t, mem, "/big", string(data))
+
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "big")
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+
+	// a small write fetches only the block it modifies
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("abc"), Offset: stagingBlockSize + 10}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	lrwfp := file.fileProxy.(*LocalRWFileProxy)
+	assert.Equal(t, map[int64]bool{1: true}, lrwfp.fetched)
+	copy(data[stagingBlockSize+10:], "abc")
+
+	// reading fetches the block that is read
+	resp := &fuse.ReadResponse{Data: make([]byte, 10)}
+	err = fileHandle.Read(nil, &fuse.ReadRequest{Offset: 2*stagingBlockSize + 5, Size: 10}, resp)
+	assert.Nil(t, err)
+	assert.Equal(t, data[2*stagingBlockSize+5:2*stagingBlockSize+15], resp.Data)
+	assert.Equal(t, map[int64]bool{1: true, 2: true}, lrwfp.fetched)
+
+	// a sequential read stops at the end of a fetched block, the next one is read from DFS
+	lrwfp.readPos = 3*stagingBlockSize - 5
+	buf := make([]byte, 10)
+	n, err := lrwfp.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, data[3*stagingBlockSize-5:3*stagingBlockSize], buf[:n])
+	n, err = lrwfp.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, data[3*stagingBlockSize:3*stagingBlockSize+10], buf[:n])
+	assert.Equal(t, map[int64]bool{1: true, 2: true}, lrwfp.fetched)
+
+	// the truncated remote content does not come back when the file grows again
+	var attr fuse.Attr
+	assert.Nil(t, file.Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 3*stagingBlockSize + 100}, &fuse.SetattrResponse{}))
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("end"), Offset: 3*stagingBlockSize + 200}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	data = append(data[:3*stagingBlockSize+100], make([]byte, 100)...)
+	data = append(data, "end"...)
+	assert.Nil(t, file.Attr(nil, &attr))
+	assert.Equal(t, uint64(len(data)), attr.Size)
+
+	// the first block is never fetched, it is uploaded straight from DFS
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Equal(t, map[int64]bool{1: true, 2: true, 3: true}, lrwfp.fetched)
+	assert.Equal(t, string(data), memReadFile(t, mem, "/big"))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+func TestLazyStagingOfReplacedFile(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	data := make([]byte, 3*stagingBlockSize)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	memWriteFile(t, mem, "/big", string(data))
+
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "big")
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+
+	// the blocks that are not fetched are read from the uploaded file
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("abc"), Offset: 10}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	copy(data[10:], "abc")
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	resp := &fuse.ReadResponse{Data: make([]byte, 10)}
+	err = fileHandle.Read(nil, &fuse.ReadRequest{Offset: stagingBlockSize + 5, Size: 10}, resp)
+	assert.Nil(t, err)
+	assert.Equal(t, data[stagingBlockSize+5:stagingBlockSize+15], resp.Data)
+
+	// the file is replaced in DFS by someone else after the next upload, the blocks that are
+	// not fetched can not be read
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("def"), Offset: 20}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	memWriteFile(t, mem, "/big", string(make([]byte, len(data))))
+	err = fileHandle.Read(nil, &fuse.ReadRequest{Offset: 2*stagingBlockSize + 5, Size: 10}, resp)
+	assert.Equal(t, syscall.EIO, err)
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("ghi"), Offset: 30}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, syscall.EIO, fileHandle.Flush(nil, nil))
+	lrwfp := file.fileProxy.(*LocalRWFileProxy)
+	assert.Equal(t, map[int64]bool{0: true, 1: true}, lrwfp.fetched)
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+func TestTruncatingOpen(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	memWriteFile(t, mem, "/file", "hello world")
+	assert.Nil(t, mem.Chmod("/file", 0640))
+
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "file")
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+
+	// the kernel opens the file without O_TRUNC and then truncates it, the handle being upgraded
+	// for writing starts from an empty staging file
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+	_, ok := file.fileProxy.(*RemoteROFileProxy)
+	assert.True(t, ok)
+	truncate := &fuse.SetattrRequest{Valid: fuse.SetattrSize | fuse.SetattrHandle, Handle: fuse.HandleID(fileHandle.fhID), Size: 0}
+	assert.Nil(t, file.Setattr(nil, truncate, &fuse.SetattrResponse{}))
+	lrwfp, ok := file.fileProxy.(*LocalRWFileProxy)
+	assert.True(t, ok)
+	fileInfo, err := lrwfp.localFile.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), fileInfo.Size())
+	assert.Equal(t, int64(0), lrwfp.remoteLimit)
+
+	// closing the handle without writing leaves the file empty
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+	assert.Equal(t, "", memReadFile(t, mem, "/file"))
+	attrs, err := mem.Stat("/file")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0640), attrs.Mode)
+	assert.Equal(t, mem.UserName, attrs.DFSUserName)
+
+	// truncating a file that is open for reading empties the shared staging file
+	memWriteFile(t, mem, "/file", "hello world")
+	reader, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	h, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fileHandle = h.(*FileHandle)
+	truncate.Handle = fuse.HandleID(fileHandle.fhID)
+	assert.Nil(t, file.Setattr(nil, truncate, &fuse.SetattrResponse{}))
+	resp := &fuse.ReadResponse{Data: make([]byte, 10)}
+	assert.Nil(t, reader.(*FileHandle).Read(nil, &fuse.ReadRequest{Size: 10}, resp))
+	assert.Equal(t, 0, len(resp.Data))
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("bye"), Offset: 0}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+	assert.Nil(t, reader.(*FileHandle).Release(nil, nil))
+	assert.Equal(t, "bye", memReadFile(t, mem, "/file"))
+}
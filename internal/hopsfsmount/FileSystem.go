@@ -17,23 +17,51 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"path"
 	"strings"
 	"sync"
+	"time"
 )
 
 type FileSystem struct {
-	HdfsAccessors      []HdfsAccessor // Interface to access HDFS
-	hdfsAccessorsIndex int
-	SrcDir             string       // Src directory that will mounted
-	AllowedPrefixes    []string     // List of allowed path prefixes (only those prefixes are exposed via mountpoint)
-	ReadOnly           bool         // Indicates whether mount filesystem with readonly
-	Mounted            bool         // True if filesystem is mounted
-	RetryPolicy        *RetryPolicy // Retry policy
-	Clock              Clock        // interface to get wall clock time
-	FsInfo             FsInfo       // Usage of HDFS, including capacity, remaining, used sizes.
+	HdfsAccessors   *HdfsAccessorPool      // Interface to access HDFS
+	SrcDir          string                 // Src directory that will mounted
+	AllowedPrefixes []string               // List of allowed path prefixes (only those prefixes are exposed via mountpoint)
+	ReadOnly        bool                   // Indicates whether mount filesystem with readonly
+	Mounted         bool                   // True if filesystem is mounted
+	RetryPolicy     *RetryPolicy           // Retry policy
+	Clock           Clock                  // interface to get wall clock time
+	FsInfo          FsInfo                 // Usage of HDFS, including capacity, remaining, used sizes.
+	BlockCache      *BlockCache            // Persistent cache of the blocks of remote files, nil if disabled
+	INodes          *INodeTable            // Inodes of the files and dirs, keyed by HopsFS file id
+	Invalidator     KernelCacheInvalidator // Notifies the kernel of changes made by other clients, nil if not serving
+	JournalDir      string                 // Local directory of the records of the exchanges and uploads in progress, no records are kept if empty
+	Trash           *TrashPolicy           // Removed entries are moved to the trash of the user, they are deleted permanently if nil
 
 	closeOnUnmount     []io.Closer // list of opened files (zip archives) to be closed on unmount
 	closeOnUnmountLock sync.Mutex  // mutex to protet closeOnUnmount
+	root               *DirINode   // root directory, created on the first call to Root()
+	rootOnce           sync.Once
+	quota              ContentSummary // content summary of the directory whose quotas bound the mount
+	hasQuota           bool           // false if neither SrcDir nor its ancestors have quotas
+	quotaExpires       time.Time      // the usage of the quotas is looked up again after this time
+	quotaDir           string         // directory whose quotas bound the mount, empty if there is none
+	quotaDirExpires    time.Time      // the ancestors of SrcDir are searched for quotas again after this time
+	quotaMutex         sync.Mutex     // mutex to protect the quota fields
+}
+
+// Duration for which the usage of the quotas found by Statfs is cached
+const quotaCacheDuration = 30 * time.Second
+
+// Duration for which the directory whose quotas bound the mount is remembered, or the absence of
+// quotas. Each step of the search computes the content summary of a larger subtree, and the quotas
+// are rarely set or cleared, unlike their usage
+const quotaDirCacheDuration = 10 * time.Minute
+
+// Notifications which make the kernel drop cached data, implemented by *fs.Server
+type KernelCacheInvalidator interface {
+	InvalidateNodeData(node fs.Node) error             // Drops the cached pages and attributes of the node
+	InvalidateEntry(parent fs.Node, name string) error // Drops the cached directory entry
 }
 
 // Verify that *FileSystem implements necesary FUSE interfaces
@@ -43,12 +71,13 @@ var _ fs.FSStatfser = (*FileSystem)(nil)
 // Creates an instance of mountable file system
 func NewFileSystem(hdfsAccessors []HdfsAccessor, srcDir string, allowedPrefixes []string, readOnly bool, retryPolicy *RetryPolicy, clock Clock) (*FileSystem, error) {
 	return &FileSystem{
-		HdfsAccessors:   hdfsAccessors,
+		HdfsAccessors:   NewHdfsAccessorPool(hdfsAccessors, clock),
 		Mounted:         false,
 		AllowedPrefixes: allowedPrefixes,
 		ReadOnly:        readOnly,
 		RetryPolicy:     retryPolicy,
 		Clock:           clock,
+		INodes:          NewINodeTable(clock),
 		SrcDir:          srcDir}, nil
 }
 
@@ -77,6 +106,8 @@ func (filesystem *FileSystem) Unmount(mountPoint string) {
 	cmd := exec.Command("fusermount3", "-zu", mountPoint)
 	err := cmd.Run()
 
+	filesystem.HdfsAccessors.Close()
+
 	// Closing all the files
 	filesystem.closeOnUnmountLock.Lock()
 	defer filesystem.closeOnUnmountLock.Unlock()
@@ -91,6 +122,14 @@ func (filesystem *FileSystem) Unmount(mountPoint string) {
 
 // Returns root directory of the filesystem
 func (filesystem *FileSystem) Root() (fs.Node, error) {
+	// the same node is returned to the server and to the watcher
+	filesystem.rootOnce.Do(func() {
+		filesystem.root = filesystem.newRoot()
+	})
+	return filesystem.root, nil
+}
+
+func (filesystem *FileSystem) newRoot() *DirINode {
 	//get UID and GID for the current user
 	cu, err := user.Current()
 	if err != nil {
@@ -106,7 +145,7 @@ func (filesystem *FileSystem) Root() (fs.Node, error) {
 		Mode:  0755 | os.ModeDir,
 		Mtime: filesystem.Clock.Now(),
 		Ctime: filesystem.Clock.Now()},
-	}, nil
+	}
 }
 
 // Returns if given absoute path allowed by any of the prefixes
@@ -126,6 +165,44 @@ func (filesystem *FileSystem) IsPathAllowed(path string) bool {
 	return false
 }
 
+// Tells the kernel to drop the cached pages and attributes of the node. The notification is sent
+// asynchronously, as it can not be sent while a request on the same node is being served
+func (filesystem *FileSystem) invalidateNode(node fs.Node) {
+	if filesystem.Invalidator == nil {
+		return
+	}
+	go func() {
+		if err := filesystem.Invalidator.InvalidateNodeData(node); err != nil && err != fuse.ErrNotCached {
+			logger.Warn("Failed to invalidate kernel cache of inode", logger.Fields{Operation: Invalidate, Error: err})
+		}
+	}()
+}
+
+// Tells the kernel to drop the cached entry of the directory, see invalidateNode
+func (filesystem *FileSystem) invalidateEntry(parent fs.Node, name string) {
+	if filesystem.Invalidator == nil {
+		return
+	}
+	go func() {
+		if err := filesystem.Invalidator.InvalidateEntry(parent, name); err != nil && err != fuse.ErrNotCached {
+			logger.Warn("Failed to invalidate kernel cache of directory entry", logger.Fields{Operation: Invalidate, Child: name, Error: err})
+		}
+	}()
+}
+
+// Returns the inode number of the node: its HopsFS file id, or its id in the inode table if the file id
+// is not known, as for new directories. The number is never 0, the kernel expects one in the listings
+func (filesystem *FileSystem) inodeNumber(parent *DirINode, name string, node fs.Node) uint64 {
+	if attrs := attrsOfInode(node); attrs != nil && attrs.Inode != 0 {
+		return attrs.Inode
+	}
+	if id, ok := filesystem.INodes.ID(node); ok {
+		return id
+	}
+	// the inode has been dropped from the table, a number is derived from the parent, as bazil.org/fuse does
+	return fs.GenerateDynamicInode(filesystem.inodeNumber(parent.Parent, parent.Attrs.Name, parent), name)
+}
+
 // Register a file to be closed on Unmount()
 func (filesystem *FileSystem) CloseOnUnmount(file io.Closer) {
 	filesystem.closeOnUnmountLock.Lock()
@@ -145,11 +222,85 @@ func (filesystem *FileSystem) Statfs(ctx context.Context, req *fuse.StatfsReques
 	resp.Bfree = fsInfo.remaining / uint64(resp.Bsize)
 	resp.Bavail = resp.Bfree
 	resp.Blocks = fsInfo.capacity / uint64(resp.Bsize)
+
+	// the quotas of the mounted directory are reported instead of the cluster's capacity
+	quota, ok := filesystem.getQuota()
+	if !ok {
+		return nil
+	}
+	if quota.SpaceQuota > 0 {
+		resp.Blocks = uint64(quota.SpaceQuota) / uint64(resp.Bsize)
+		var free uint64
+		if quota.SpaceConsumed < quota.SpaceQuota {
+			free = uint64(quota.SpaceQuota-quota.SpaceConsumed) / uint64(resp.Bsize)
+		}
+		if free < resp.Bfree {
+			resp.Bfree = free
+		}
+		resp.Bavail = resp.Bfree
+	}
+	if quota.NameQuota > 0 {
+		resp.Files = uint64(quota.NameQuota)
+		used := uint64(quota.FileCount + quota.DirectoryCount)
+		if used < resp.Files {
+			resp.Ffree = resp.Files - used
+		}
+	}
 	return nil
 }
 
+// Returns the content summary of the directory whose quotas bound the mount, false if there is none.
+// The usage is cached for quotaCacheDuration, the directory for quotaDirCacheDuration
+func (filesystem *FileSystem) getQuota() (ContentSummary, bool) {
+	filesystem.quotaMutex.Lock()
+	defer filesystem.quotaMutex.Unlock()
+	now := filesystem.Clock.Now()
+	if now.Before(filesystem.quotaExpires) {
+		return filesystem.quota, filesystem.hasQuota
+	}
+	filesystem.quotaExpires = now.Add(quotaCacheDuration)
+	if now.Before(filesystem.quotaDirExpires) {
+		if filesystem.quotaDir == "" {
+			return ContentSummary{}, false
+		}
+		summary, err := filesystem.getDFSConnector().GetContentSummary(filesystem.quotaDir)
+		if err == nil && hasQuotas(summary) {
+			filesystem.quota = summary
+			return summary, true
+		}
+		// the directory is gone or its quotas were cleared, the ancestors are searched again
+	}
+	dir, summary, err := filesystem.findQuota()
+	filesystem.quotaDir, filesystem.quota, filesystem.hasQuota = dir, summary, dir != ""
+	if err == nil {
+		filesystem.quotaDirExpires = now.Add(quotaDirCacheDuration)
+	}
+	return filesystem.quota, filesystem.hasQuota
+}
+
+// Looks for the quotas of SrcDir, or of its nearest ancestor which has quotas. Returns the directory
+// and its content summary, an empty directory if there is none or the search failed.
+// The root directory is skipped, its quotas are the limits of the whole cluster
+func (filesystem *FileSystem) findQuota() (string, ContentSummary, error) {
+	connector := filesystem.getDFSConnector()
+	for p := path.Clean("/" + filesystem.SrcDir); p != "/"; p = path.Dir(p) {
+		summary, err := connector.GetContentSummary(p)
+		if err != nil {
+			logger.Warn("Unable to get the quotas, the capacity of the cluster is reported", logger.Fields{Operation: StatFS, Path: p, Error: err})
+			return "", ContentSummary{}, err
+		}
+		if hasQuotas(summary) {
+			return p, summary, nil
+		}
+	}
+	return "", ContentSummary{}, nil
+}
+
+func hasQuotas(summary ContentSummary) bool {
+	return summary.SpaceQuota > 0 || summary.NameQuota > 0
+}
+
+// Returns the least loaded healthy connector
 func (filesystem *FileSystem) getDFSConnector() HdfsAccessor {
-	filesystem.hdfsAccessorsIndex = filesystem.hdfsAccessorsIndex + 1
-	index := filesystem.hdfsAccessorsIndex % len(filesystem.HdfsAccessors)
-	return filesystem.HdfsAccessors[index]
+	return filesystem.HdfsAccessors.Get()
 }
@@ -13,14 +13,13 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"bazil.org/fuse/fs/fstestutil"
-	"github.com/colinmarc/hdfs/v2"
 	"golang.org/x/sys/unix"
 	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
-	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/ugcache"
 )
 
 func TestReadWriteEmptyFile(t *testing.T) {
@@ -328,38 +327,24 @@ func TestSeekSmallFile(t *testing.T) {
 }
 
 func seekTest(t *testing.T, dataSize int, diskSeekTestFile string, dfsSeekTestFile string) {
-	addresses := make([]string, 1)
-	addresses[0] = "localhost:8020"
-
-	user, err := ugcache.CurrentUserName()
-	if err != nil {
-		t.Fatalf("couldn't determine user: %s", err)
-	}
-
-	hdfsOptions := hdfs.ClientOptions{
-		Addresses: addresses,
-		User:      user,
-	}
-
-	client, err := hdfs.NewClient(hdfsOptions)
-	if err != nil {
+	hdfsAccessor := newTestHdfsAccessor(t)
+	if err := hdfsAccessor.EnsureConnected(); err != nil {
 		t.Fatalf("Failed %v", err)
 	}
-	defer client.Close()
 	fmt.Printf("Connected to DFS\n")
 
-	prepare(t, client, dataSize, diskSeekTestFile, dfsSeekTestFile)
+	prepare(t, hdfsAccessor, dataSize, diskSeekTestFile, dfsSeekTestFile)
 
-	testSeeks(t, client, diskSeekTestFile, dfsSeekTestFile)
+	testSeeks(t, hdfsAccessor, diskSeekTestFile, dfsSeekTestFile)
 
-	err = client.Remove(dfsSeekTestFile)
+	err := hdfsAccessor.Remove(dfsSeekTestFile)
 	if err != nil {
 		t.Fatalf("Failed %v", err)
 	}
 
 }
 
-func prepare(t *testing.T, client *hdfs.Client, dataSize int, diskTestFile string, dfsTestFile string) {
+func prepare(t *testing.T, hdfsAccessor HdfsAccessor, dataSize int, diskTestFile string, dfsTestFile string) {
 
 	logger.Info("Creating test data ...", nil)
 	recreateDFSFile := false
@@ -378,11 +363,11 @@ func prepare(t *testing.T, client *hdfs.Client, dataSize int, diskTestFile strin
 	}
 
 	if recreateDFSFile {
-		client.Remove(dfsTestFile)
+		hdfsAccessor.Remove(dfsTestFile)
 	}
 
-	if _, err := client.Stat(dfsTestFile); errors.Is(err, os.ErrNotExist) {
-		dfsWriter, err := client.Create(dfsTestFile)
+	if _, err := hdfsAccessor.Stat(dfsTestFile); errors.Is(err, syscall.ENOENT) {
+		dfsWriter, err := hdfsAccessor.CreateFile(dfsTestFile, 0644, false)
 		if err != nil {
 			t.Fatalf("Failed %v", err)
 		}
@@ -416,10 +401,14 @@ func prepare(t *testing.T, client *hdfs.Client, dataSize int, diskTestFile strin
 
 }
 
-func testSeeks(t *testing.T, client *hdfs.Client, diskTestFile string, dfsTestFile string) {
+func testSeeks(t *testing.T, hdfsAccessor HdfsAccessor, diskTestFile string, dfsTestFile string) {
 	fileInfo, _ := os.Stat(diskTestFile)
 	diskReader, _ := os.Open(diskTestFile)
-	dfsReader, _ := client.Open(dfsTestFile)
+	dfsReader, err := hdfsAccessor.OpenRead(dfsTestFile)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer dfsReader.Close()
 	bufferSize := 4 * 1024
 	for i := 0; i < 100000; i++ {
 
@@ -450,7 +439,10 @@ func testSeeks(t *testing.T, client *hdfs.Client, diskTestFile string, dfsTestFi
 		//fmt.Printf("%d) Seek %d, Bytes read from disk are %d, error: %v. Data: %s\n", i, seek, diskReadBytes, diskErr, string(buffer1)[:30])
 
 		buffer2 := make([]byte, bufferSize)
-		n, err = dfsReader.Seek(seek, 0)
+		err = dfsReader.Seek(seek)
+		if err == nil {
+			n, err = dfsReader.Position()
+		}
 		if n != seek {
 			t.Fatalf("DFS seek did not skip correct number of bytes. Expected: %d, Got: %d", seek, n)
 		}
@@ -596,7 +588,7 @@ func withMount(t testing.TB, srcDir string, fn func(mntPath string, hdfsAccessor
 	retryPolicy := NewDefaultRetryPolicy(WallClock{})
 	retryPolicy.MaxAttempts = 1 // for quick failure
 	logger.InitLogger("ERROR", false, "")
-	hdfsAccessor, _ := NewHdfsAccessor("localhost:8020", WallClock{}, TLSConfig{TLS: false, RootCABundle: RootCABundle, ClientCertificate: ClientCertificate, ClientKey: ClientKey})
+	hdfsAccessor := newTestHdfsAccessor(t)
 	err := hdfsAccessor.EnsureConnected()
 	if err != nil {
 		t.Fatalf(fmt.Sprintf("Error/NewHdfsAccessor: %v ", err), nil)
@@ -625,6 +617,34 @@ func withMount(t testing.TB, srcDir string, fn func(mntPath string, hdfsAccessor
 	fn(mnt.Dir, hdfsAccessor)
 }
 
+var testMemHdfsAccessor *MemHdfsAccessor
+var testMemHdfsAccessorOnce sync.Once
+
+// Returns accessor for the name node set in HOPSFS_MOUNT_TEST_NAMENODE (localhost:8020 by default).
+// Set it to mem:// to run the tests against the in-memory backend. The in-memory namespace
+// is shared by all the mounts in the test process
+func newTestHdfsAccessor(t testing.TB) HdfsAccessor {
+	t.Helper()
+	nameNodeAddress := os.Getenv("HOPSFS_MOUNT_TEST_NAMENODE")
+	if nameNodeAddress == "" {
+		nameNodeAddress = "localhost:8020"
+	}
+
+	if IsMemNameNodeAddress(nameNodeAddress) {
+		testMemHdfsAccessorOnce.Do(func() {
+			var err error
+			testMemHdfsAccessor, err = NewMemHdfsAccessor(WallClock{})
+			if err != nil {
+				t.Fatalf("Error/NewMemHdfsAccessor: %v ", err)
+			}
+		})
+		return testMemHdfsAccessor
+	}
+
+	hdfsAccessor, _ := NewHdfsAccessor(nameNodeAddress, WallClock{}, TLSConfig{TLS: false, RootCABundle: RootCABundle, ClientCertificate: ClientCertificate, ClientKey: ClientKey})
+	return hdfsAccessor
+}
+
 func mkdir(t testing.TB, dir string) {
 	t.Helper()
 	err := os.Mkdir(dir, 0700)
@@ -4,9 +4,13 @@
 package hopsfsmount
 
 import (
+	"os"
+	"syscall"
 	"testing"
+	"time"
 
 	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
@@ -43,3 +47,190 @@ func TestStatfs(t *testing.T) {
 	assert.Equal(t, uint64(10), fsInfo.Blocks)
 	assert.Equal(t, uint64(1), fsInfo.Bfree)
 }
+
+func TestStatfsWithQuotas(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	mockClock.now = time.Unix(1000, 0)
+	assert.Nil(t, mem.MkdirAll("/Projects/demo/Resources", os.ModeDir|0755))
+	memWriteFile(t, mem, "/Projects/demo/Resources/data", string(make([]byte, 4096)))
+	accessor := &summaryCountingAccessor{MemHdfsAccessor: mem}
+	fs, _ := NewFileSystem([]HdfsAccessor{accessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.SrcDir = "/Projects/demo/Resources"
+
+	// without quotas, the capacity of the cluster is reported
+	resp := &fuse.StatfsResponse{}
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(memCapacity/1024), resp.Blocks)
+	assert.Equal(t, uint64(0), resp.Files)
+	assert.Equal(t, 3, accessor.summaries)
+
+	// the absence of quotas is remembered longer than their usage
+	assert.Nil(t, mem.SetQuota("/Projects/demo", 10, 1024*1024))
+	assert.Nil(t, mem.SetQuota("/Projects", 100, 1024*1024*1024))
+	mockClock.now = mockClock.now.Add(quotaCacheDuration)
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(memCapacity/1024), resp.Blocks)
+	assert.Equal(t, 3, accessor.summaries)
+
+	// the quotas of the nearest ancestor are reported once the directory is searched again
+	mockClock.now = mockClock.now.Add(quotaDirCacheDuration)
+	resp = &fuse.StatfsResponse{}
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	summary, err := mem.GetContentSummary("/Projects/demo")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1024), resp.Blocks)
+	assert.Equal(t, uint64(1024*1024-summary.SpaceConsumed)/1024, resp.Bfree)
+	assert.Equal(t, resp.Bfree, resp.Bavail)
+	assert.Equal(t, uint64(10), resp.Files)
+	assert.Equal(t, uint64(10-3), resp.Ffree) // 2 directories and 1 file
+	assert.Equal(t, 5, accessor.summaries)
+
+	// then only the usage of that directory is refreshed
+	memWriteFile(t, mem, "/Projects/demo/Resources/more", "more")
+	mockClock.now = mockClock.now.Add(quotaCacheDuration)
+	resp = &fuse.StatfsResponse{}
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(10-4), resp.Ffree)
+	assert.Equal(t, 6, accessor.summaries)
+
+	// the quotas of the source directory take precedence, the free space is never negative
+	assert.Nil(t, mem.SetQuota("/Projects/demo/Resources", 0, 1024))
+	mockClock.now = mockClock.now.Add(quotaDirCacheDuration)
+	resp = &fuse.StatfsResponse{}
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(1), resp.Blocks)
+	assert.Equal(t, uint64(0), resp.Bfree)
+	assert.Equal(t, uint64(0), resp.Files)
+
+	// the ancestors are searched again as soon as the quotas are cleared
+	assert.Nil(t, mem.SetQuota("/Projects/demo/Resources", 0, 0))
+	mockClock.now = mockClock.now.Add(quotaCacheDuration)
+	resp = &fuse.StatfsResponse{}
+	assert.Nil(t, fs.Statfs(nil, &fuse.StatfsRequest{}, resp))
+	assert.Equal(t, uint64(1024), resp.Blocks)
+}
+
+// Counts the content summaries computed by the name node
+type summaryCountingAccessor struct {
+	*MemHdfsAccessor
+	summaries int
+}
+
+func (a *summaryCountingAccessor) GetContentSummary(path string) (ContentSummary, error) {
+	a.summaries++
+	return a.MemHdfsAccessor.GetContentSummary(path)
+}
+
+// Error returned by the HopsFS client for a failed RPC
+type testRemoteError struct {
+	exception string
+}
+
+func (e testRemoteError) Error() string     { return e.exception }
+func (e testRemoteError) Method() string    { return "addBlock" }
+func (e testRemoteError) Desc() string      { return "ERROR_APPLICATION" }
+func (e testRemoteError) Exception() string { return e.exception }
+func (e testRemoteError) Message() string   { return e.exception + ": The DiskSpace quota is exceeded" }
+
+func TestQuotaExceededError(t *testing.T) {
+	err := &os.PathError{Op: "create", Path: "/dir/file", Err: testRemoteError{"org.apache.hadoop.hdfs.protocol.DSQuotaExceededException"}}
+	assert.Equal(t, syscall.EDQUOT, unwrapAndTranslateError(err))
+	err = &os.PathError{Op: "mkdirs", Path: "/dir/sub", Err: testRemoteError{"org.apache.hadoop.hdfs.protocol.NSQuotaExceededException"}}
+	assert.Equal(t, syscall.EDQUOT, unwrapAndTranslateError(err))
+	err = &os.PathError{Op: "create", Path: "/dir/file", Err: testRemoteError{"java.io.IOException"}}
+	assert.Equal(t, syscall.EIO, unwrapAndTranslateError(err))
+}
+
+// Records the invalidation notifications sent to the kernel
+type recordingInvalidator struct {
+	calls chan invalidation
+}
+
+type invalidation struct {
+	node fs.Node
+	name string // name of the invalidated entry, empty for nodes
+}
+
+func (ri *recordingInvalidator) InvalidateNodeData(node fs.Node) error {
+	ri.calls <- invalidation{node: node}
+	return nil
+}
+
+func (ri *recordingInvalidator) InvalidateEntry(parent fs.Node, name string) error {
+	ri.calls <- invalidation{node: parent, name: name}
+	return nil
+}
+
+func (ri *recordingInvalidator) next(t *testing.T) invalidation {
+	select {
+	case call := <-ri.calls:
+		return call
+	case <-time.After(5 * time.Second):
+		t.Fatal("Kernel cache not invalidated")
+		return invalidation{}
+	}
+}
+
+func memAppendFile(t *testing.T, mem *MemHdfsAccessor, p string, data string) {
+	w, err := mem.Append(p)
+	assert.Nil(t, err)
+	_, err = w.Write([]byte(data))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+}
+
+func TestKernelCacheInvalidation(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "a")
+	fileSystem, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	invalidator := &recordingInvalidator{calls: make(chan invalidation, 10)}
+	fileSystem.Invalidator = invalidator
+	root, _ := fileSystem.Root()
+	node, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	dir := node.(*DirINode)
+	a, err := dir.Lookup(nil, "a")
+	assert.Nil(t, err)
+
+	// unchanged files are not invalidated
+	var attr fuse.Attr
+	mockClock.NotifyTimeElapsed(CacheAttrsTimeDuration + time.Second)
+	assert.Nil(t, a.Attr(nil, &attr))
+	assert.Equal(t, 0, len(invalidator.calls))
+
+	// a file updated by another client is invalidated when stat sees the change
+	memAppendFile(t, mem, "/dir/a", "bcdefg")
+	mockClock.NotifyTimeElapsed(CacheAttrsTimeDuration + time.Second)
+	assert.Nil(t, a.Attr(nil, &attr))
+	assert.Equal(t, uint64(7), attr.Size)
+	assert.Equal(t, invalidation{node: a}, invalidator.next(t))
+
+	// and when a directory listing sees the change
+	memAppendFile(t, mem, "/dir/a", "h")
+	_, err = readDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, invalidation{node: a}, invalidator.next(t))
+
+	// a file replaced by another one invalidates the entry as well
+	memWriteFile(t, mem, "/dir/a", "new")
+	mockClock.NotifyTimeElapsed(CacheDirListingTimeDuration + time.Second)
+	_, err = readDir(dir)
+	assert.Nil(t, err)
+	calls := []invalidation{invalidator.next(t), invalidator.next(t)}
+	assert.ElementsMatch(t, []invalidation{{node: a}, {node: dir, name: "a"}}, calls)
+
+	// files written through this mount are not invalidated while open
+	b, err := dir.Lookup(nil, "a")
+	assert.Nil(t, err)
+	h, err := b.(*FileINode).Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	memAppendFile(t, mem, "/dir/a", "i")
+	mockClock.NotifyTimeElapsed(CacheAttrsTimeDuration + time.Second)
+	_, err = dir.statInodeInHopsFS(GetattrFile, "a", &Attrs{})
+	assert.Nil(t, err)
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+	assert.Equal(t, 0, len(invalidator.calls))
+}
@@ -4,9 +4,11 @@
 package hopsfsmount
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -23,23 +25,37 @@ import (
 // Concurrency: thread safe: handles unlimited number of concurrent requests
 var hadoopUserName string
 var hadoopUserID uint32 = 0
+var hadoopUserMutex sync.Mutex // clients of the pool may connect concurrently
 
 type HdfsAccessor interface {
 	OpenRead(path string) (ReadSeekCloser, error) // Opens HDFS file for reading
 	CreateFile(path string,
 		mode os.FileMode, overwrite bool) (HdfsWriter, error) // Opens HDFS file for writing
-	ReadDir(path string) ([]Attrs, error)        // Enumerates HDFS directory
-	Stat(path string) (Attrs, error)             // Retrieves file/directory attributes
-	StatFs() (FsInfo, error)                     // Retrieves HDFS usage
-	Mkdir(path string, mode os.FileMode) error   // Creates a directory
-	Remove(path string) error                    // Removes a file or directory
-	Rename(oldPath string, newPath string) error // Renames a file or directory
+	Append(path string) (HdfsWriter, error)       // Opens HDFS file for appending
+	ReadDir(path string) ([]Attrs, error)         // Enumerates HDFS directory
+	OpenDir(path string) (DirLister, error)       // Opens HDFS directory for enumerating it page by page
+	Stat(path string) (Attrs, error)              // Retrieves file/directory attributes
+	StatFs() (FsInfo, error)                      // Retrieves HDFS usage
+	Mkdir(path string, mode os.FileMode) error    // Creates a directory
+	MkdirAll(path string, mode os.FileMode) error // Creates a directory along with its missing parents
+	Remove(path string) error                     // Removes a file or directory
+	Rename(oldPath string, newPath string) error  // Renames a file or directory
 	Rename2(oldPath string, newPath string,
 		options hdfs.RenameOptions) error // Renames a file or directory
-	EnsureConnected() error                       // Ensures HDFS accessor is connected to the HDFS name node
-	Chown(path string, owner, group string) error // Changes the owner and group of the file
-	Chmod(path string, mode os.FileMode) error    // Changes the mode of the file
-	Close() error                                 // Close current meta connection if needed
+	EnsureConnected() error                                // Ensures HDFS accessor is connected to the HDFS name node
+	Chown(path string, owner, group string) error          // Changes the owner and group of the file
+	Chmod(path string, mode os.FileMode) error             // Changes the mode of the file
+	SetTimes(path string, atime, mtime time.Time) error    // Changes the access and modification times of the file
+	CreateSymlink(target string, link string) error        // Creates a symbolic link
+	ReadLink(path string) (string, error)                  // Returns the target of a symbolic link
+	GetXAttr(path string, name string) ([]byte, error)     // Returns the value of an extended attribute
+	ListXAttrs(path string) ([]string, error)              // Returns the names of the extended attributes
+	SetXAttr(path string, name string, value []byte) error // Creates or replaces an extended attribute
+	RemoveXAttr(path string, name string) error            // Removes an extended attribute
+	GetMetadata(path string) (Metadata, error)             // Retrieves the HopsFS metadata of a file/directory
+	GetContentSummary(path string) (ContentSummary, error) // Retrieves the summary of a subtree
+	SetReplication(path string, replication uint16) error  // Changes the replication factor of a file
+	Close() error                                          // Close current meta connection if needed
 }
 
 type TLSConfig struct {
@@ -51,11 +67,10 @@ type TLSConfig struct {
 }
 
 type HdfsAccessorImpl struct {
-	Clock               Clock        // interface to get wall clock time
-	NameNodeAddresses   []string     // array of Address:port string for the name nodes
-	MetadataClient      *hdfs.Client // HDFS client used for metadata operations
-	MetadataClientMutex sync.Mutex   // Serializing all metadata operations for simplicity (for now), TODO: allow N concurrent operations
-	TLSConfig           TLSConfig    // enable/disable using tls
+	Clock             Clock             // interface to get wall clock time
+	NameNodeAddresses []string          // array of Address:port string for the name nodes
+	TLSConfig         TLSConfig         // enable/disable using tls
+	clients           chan *hdfs.Client // pool of HDFS clients used for metadata operations, nil entries are not connected yet
 }
 
 var _ HdfsAccessor = (*HdfsAccessorImpl)(nil) // ensure hdfsAccessorImpl implements HdfsAccessor
@@ -64,30 +79,59 @@ var _ HdfsAccessor = (*HdfsAccessorImpl)(nil) // ensure hdfsAccessorImpl impleme
 func NewHdfsAccessor(nameNodeAddresses string, clock Clock, tlsConfig TLSConfig) (HdfsAccessor, error) {
 	nns := strings.Split(nameNodeAddresses, ",")
 
+	poolSize := MetadataConnections
+	if poolSize < 1 {
+		poolSize = 1
+	}
+
 	hdfsAccessorImpl := &HdfsAccessorImpl{
 		NameNodeAddresses: nns,
 		Clock:             clock,
 		TLSConfig:         tlsConfig,
+		clients:           make(chan *hdfs.Client, poolSize),
+	}
+	// clients are connected lazily, on first use
+	for i := 0; i < poolSize; i++ {
+		hdfsAccessorImpl.clients <- nil
 	}
 	return hdfsAccessorImpl, nil
 }
 
-// Ensures that metadata client is connected
+// Ensures that at least one metadata client is connected. A client connected earlier may have been
+// broken since, the name node is asked for its defaults to verify the connection
 func (dfs *HdfsAccessorImpl) EnsureConnected() error {
-	if dfs.MetadataClient != nil {
-		return nil
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
 	}
-	return dfs.connectMetadataClient()
+	_, err = client.ServerDefaults()
+	dfs.releaseClient(client, err)
+	return unwrapAndTranslateError(err)
 }
 
-// Establishes connection to the name node (assigns MetadataClient field)
-func (dfs *HdfsAccessorImpl) connectMetadataClient() error {
-	client, err := dfs.connectToNameNode()
-	if err != nil {
-		return unwrapAndTranslateError(err)
+// Takes a client from the pool, connecting it if needed.
+// Blocks while all the clients of the pool are in use
+func (dfs *HdfsAccessorImpl) acquireClient() (*hdfs.Client, error) {
+	client := <-dfs.clients
+	if client == nil {
+		var err error
+		client, err = dfs.connectToNameNode()
+		if err != nil {
+			dfs.clients <- nil
+			return nil, unwrapAndTranslateError(err)
+		}
 	}
-	dfs.MetadataClient = client
-	return nil
+	return client, nil
+}
+
+// Returns the client to the pool. If the operation has failed with a retriable error then
+// the client is closed, and a new connection is established the next time this slot is used
+func (dfs *HdfsAccessorImpl) releaseClient(client *hdfs.Client, err error) {
+	if !IsSuccessOrNonRetriableError(err) {
+		client.Close()
+		client = nil
+	}
+	dfs.clients <- client
 }
 
 // Establishes connection to a name node in the context of some other operation
@@ -105,29 +149,24 @@ func (dfs *HdfsAccessorImpl) connectToNameNode() (*hdfs.Client, error) {
 // Performs an attempt to connect to the HDFS name
 func (dfs *HdfsAccessorImpl) connectToNameNodeImpl() (*hdfs.Client, error) {
 
-	if ForceOverrideUsername != "" {
-		hadoopUserName = ForceOverrideUsername
-	} else {
-		hadoopUserName = os.Getenv("HADOOP_USER_NAME")
-		if hadoopUserName == "" {
-			currentSystemUser, err := ugcache.CurrentUserName()
-			if err != nil {
-				return nil, err
-			}
-			hadoopUserName = currentSystemUser
-		}
+	userName, err := resolveHadoopUserName()
+	if err != nil {
+		return nil, err
 	}
+	userID := ugcache.LookupUId(userName)
+	hadoopUserMutex.Lock()
+	hadoopUserName = userName
+	hadoopUserID = userID
+	hadoopUserMutex.Unlock()
 
-	hadoopUserID = ugcache.LookupUId(hadoopUserName)
-
-	logger.Info(fmt.Sprintf("Connecting as user: %s UID: %d", hadoopUserName, hadoopUserID), nil)
+	logger.Info(fmt.Sprintf("Connecting as user: %s UID: %d", userName, userID), nil)
 
 	// Performing an attempt to connect to the name node
 	// Colinmar's hdfs implementation has supported the multiple name node connection
 	hdfsOptions := hdfs.ClientOptions{
 		Addresses: dfs.NameNodeAddresses,
 		TLS:       dfs.TLSConfig.TLS,
-		User:      hadoopUserName,
+		User:      userName,
 	}
 
 	if dfs.TLSConfig.TLS {
@@ -157,18 +196,26 @@ func (dfs *HdfsAccessorImpl) connectToNameNodeImpl() (*hdfs.Client, error) {
 	}
 }
 
+// Returns the user on whose behalf HopsFS is accessed
+func resolveHadoopUserName() (string, error) {
+	if ForceOverrideUsername != "" {
+		return ForceOverrideUsername, nil
+	}
+	userName := os.Getenv("HADOOP_USER_NAME")
+	if userName == "" {
+		return ugcache.CurrentUserName()
+	}
+	return userName, nil
+}
+
 // Opens HDFS file for reading
 func (dfs *HdfsAccessorImpl) OpenRead(path string) (ReadSeekCloser, error) {
-	// Blocking read. This is to reduce the connections pressue on hadoop-name-node
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
-
-	if dfs.MetadataClient == nil {
-		if err := dfs.connectMetadataClient(); err != nil {
-			return nil, unwrapAndTranslateError(err)
-		}
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return nil, err
 	}
-	reader, err := dfs.MetadataClient.Open(path)
+	reader, err := client.Open(path)
+	dfs.releaseClient(client, err)
 	if err != nil {
 		return nil, unwrapAndTranslateError(err)
 	}
@@ -177,21 +224,19 @@ func (dfs *HdfsAccessorImpl) OpenRead(path string) (ReadSeekCloser, error) {
 
 // Creates new HDFS file
 func (dfs *HdfsAccessorImpl) CreateFile(path string, mode os.FileMode, overwrite bool) (HdfsWriter, error) {
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
-
-	if dfs.MetadataClient == nil {
-		if err := dfs.connectMetadataClient(); err != nil {
-			return nil, unwrapAndTranslateError(err)
-		}
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return nil, err
 	}
 
-	serverDefaults, err := dfs.MetadataClient.ServerDefaults()
+	serverDefaults, err := client.ServerDefaults()
 	if err != nil {
+		dfs.releaseClient(client, err)
 		return nil, unwrapAndTranslateError(err)
 	}
 
-	writer, err := dfs.MetadataClient.CreateFile(path, serverDefaults.Replication, serverDefaults.BlockSize, mode, overwrite, false)
+	writer, err := client.CreateFile(path, serverDefaults.Replication, serverDefaults.BlockSize, mode, overwrite, false)
+	dfs.releaseClient(client, err)
 	if err != nil {
 		return nil, unwrapAndTranslateError(err)
 	}
@@ -199,25 +244,29 @@ func (dfs *HdfsAccessorImpl) CreateFile(path string, mode os.FileMode, overwrite
 	return NewHdfsWriter(writer), nil
 }
 
+// Opens an existing HDFS file for appending
+func (dfs *HdfsAccessorImpl) Append(path string) (HdfsWriter, error) {
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return nil, err
+	}
+	writer, err := client.Append(path)
+	dfs.releaseClient(client, err)
+	if err != nil {
+		return nil, unwrapAndTranslateError(err)
+	}
+	return NewHdfsWriter(writer), nil
+}
+
 // Enumerates HDFS directory
 func (dfs *HdfsAccessorImpl) ReadDir(path string) ([]Attrs, error) {
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
-
-	if dfs.MetadataClient == nil {
-		if err := dfs.connectMetadataClient(); err != nil {
-			return nil, unwrapAndTranslateError(err)
-		}
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return nil, err
 	}
-	files, err := dfs.MetadataClient.ReadDir(path)
+	files, err := client.ReadDir(path)
+	dfs.releaseClient(client, err)
 	if err != nil {
-		if IsSuccessOrNonRetriableError(err) {
-			// benign error (e.g. path not found)
-			return nil, unwrapAndTranslateError(err)
-		}
-		// We've got error from this client, setting to nil, so we try another one next time
-		dfs.MetadataClient.Close()
-		dfs.MetadataClient = nil
 		return nil, unwrapAndTranslateError(err)
 	}
 	allAttrs := make([]Attrs, len(files))
@@ -227,26 +276,33 @@ func (dfs *HdfsAccessorImpl) ReadDir(path string) ([]Attrs, error) {
 	return allAttrs, nil
 }
 
+// Opens HDFS directory for enumerating it page by page
+func (dfs *HdfsAccessorImpl) OpenDir(path string) (DirLister, error) {
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := client.Open(path)
+	dfs.releaseClient(client, err)
+	if err != nil {
+		return nil, unwrapAndTranslateError(err)
+	}
+	if !reader.Stat().IsDir() {
+		reader.Close()
+		return nil, syscall.ENOTDIR
+	}
+	return NewHdfsDirLister(reader, dfs.attrsFromFileInfo), nil
+}
+
 // Retrieves file/directory attributes
 func (dfs *HdfsAccessorImpl) Stat(path string) (Attrs, error) {
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
-
-	if dfs.MetadataClient == nil {
-		if err := dfs.connectMetadataClient(); err != nil {
-			return Attrs{}, unwrapAndTranslateError(err)
-		}
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return Attrs{}, err
 	}
-
-	fileInfo, err := dfs.MetadataClient.Stat(path)
+	fileInfo, err := client.Stat(path)
+	dfs.releaseClient(client, err)
 	if err != nil {
-		if IsSuccessOrNonRetriableError(err) {
-			// benign error (e.g. path not found)
-			return Attrs{}, unwrapAndTranslateError(err)
-		}
-		// We've got error from this client, setting to nil, so we try another one next time
-		dfs.MetadataClient.Close()
-		dfs.MetadataClient = nil
 		return Attrs{}, unwrapAndTranslateError(err)
 	}
 	return dfs.attrsFromFileInfo(fileInfo), nil
@@ -254,34 +310,60 @@ func (dfs *HdfsAccessorImpl) Stat(path string) (Attrs, error) {
 
 // Retrieves HDFS usages
 func (dfs *HdfsAccessorImpl) StatFs() (FsInfo, error) {
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
-
-	if dfs.MetadataClient == nil {
-		if err := dfs.connectMetadataClient(); err != nil {
-			return FsInfo{}, unwrapAndTranslateError(err)
-		}
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return FsInfo{}, err
 	}
-
-	fsInfo, err := dfs.MetadataClient.StatFs()
+	fsInfo, err := client.StatFs()
+	dfs.releaseClient(client, err)
 	if err != nil {
-		if IsSuccessOrNonRetriableError(err) {
-			return FsInfo{}, unwrapAndTranslateError(err)
-		}
-		dfs.MetadataClient.Close()
-		dfs.MetadataClient = nil
 		return FsInfo{}, unwrapAndTranslateError(err)
 	}
 	return dfs.AttrsFromFsInfo(fsInfo), nil
 }
 
+// Creates a symbolic link. The HopsFS client does not implement the createSymlink call,
+// links can only be created as marker files by SymlinkEmulatingHdfsAccessor, with -emulateSymlinks
+func (dfs *HdfsAccessorImpl) CreateSymlink(target string, link string) error {
+	logger.Warn("Symlinks are not supported by the HopsFS client", logger.Fields{Operation: Symlink, Path: link})
+	return syscall.ENOTSUP
+}
+
+// Returns the target of a symbolic link
+func (dfs *HdfsAccessorImpl) ReadLink(path string) (string, error) {
+	attrs, err := dfs.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if attrs.Mode&os.ModeSymlink == 0 {
+		return "", syscall.EINVAL
+	}
+	return attrs.Target, nil
+}
+
+// Markers of the files and directories which have an ACL, and of the snapshottable directories, in the HDFS file status
+const (
+	hdfsAclBit              = 1 << 12
+	hdfsSnapshotEnabledFlag = 0x08
+)
+
 // Converts os.FileInfo + underlying proto-buf data into Attrs structure
 func (dfs *HdfsAccessorImpl) attrsFromFileInfo(fileInfo os.FileInfo) Attrs {
 	// protoBufDatr := fileInfo.Sys().(*hadoop_hdfs.HdfsFileStatusProto)
 	fi := fileInfo.(*hdfs.FileInfo)
 	mode := os.FileMode(fi.Permission())
+	size := fi.Length()
+	var target string
+	status := fi.Sys().(*hdfs.FileStatus)
+	// older name nodes flag ACLs with a bit of the permission, it is not part of the mode
+	mode &^= hdfsAclBit
 	if fileInfo.IsDir() {
 		mode |= os.ModeDir
+	} else if link := status.GetSymlink(); len(link) > 0 {
+		// symlinks found in listings, the length of a link is the length of its target like in POSIX
+		mode = mode.Perm() | os.ModeSymlink
+		target = string(link)
+		size = uint64(len(link))
 	}
 
 	modificationTime := time.Unix(int64(fi.ModificationTime())/1000, 0)
@@ -301,17 +383,20 @@ func (dfs *HdfsAccessorImpl) attrsFromFileInfo(fileInfo os.FileInfo) Attrs {
 	}
 
 	return Attrs{
-		Inode:        fi.FileId(),
-		Name:         fileInfo.Name(),
-		Mode:         mode,
-		Size:         fi.Length(),
-		Uid:          uid,
-		Gid:          gid,
-		DFSUserName:  fi.Owner(),
-		DFSGroupName: fi.OwnerGroup(),
-		Mtime:        modificationTime,
-		Ctime:        modificationTime,
-		Expires:      dfs.Clock.Now().Add(CacheAttrsTimeDuration),
+		Inode:         fi.FileId(),
+		Name:          fileInfo.Name(),
+		Mode:          mode,
+		Size:          size,
+		Uid:           uid,
+		Gid:           gid,
+		DFSUserName:   fi.Owner(),
+		DFSGroupName:  fi.OwnerGroup(),
+		Mtime:         modificationTime,
+		Atime:         fi.AccessTime(),
+		Ctime:         modificationTime,
+		Expires:       dfs.Clock.Now().Add(CacheAttrsTimeDuration),
+		Target:        target,
+		Snapshottable: fileInfo.IsDir() && status.GetFlags()&hdfsSnapshotEnabledFlag != 0,
 	}
 }
 
@@ -391,20 +476,46 @@ func unwrapAndTranslateError(err error) error {
 		return e
 	}
 
+	// the namenode rejects the blocks and the files beyond the quotas, e.g. when a file is flushed
+	var remoteErr hdfs.Error
+	if errors.As(err, &remoteErr) && isQuotaExceededException(remoteErr) {
+		return syscall.EDQUOT
+	}
+
 	logger.Warn(fmt.Sprintf("Unrecognized Error: %T %v. Returning: %v ", err, err, syscall.EIO), nil)
 	return syscall.EIO
 }
 
+// Common suffix of the exceptions of HopsFS for the space and the namespace quotas
+const quotaExceededException = "QuotaExceededException"
+
+// Returns true for the DSQuotaExceededException and NSQuotaExceededException of HopsFS,
+// the exception may be only found in the message if the error was relayed by a datanode
+func isQuotaExceededException(err hdfs.Error) bool {
+	return strings.Contains(err.Exception(), quotaExceededException) || strings.Contains(err.Message(), quotaExceededException)
+}
+
+// Returns true for the errors caused by the request itself, which fail again if the request is retried.
+// Besides the errors of the name node, the unsupported operations, e.g. symlinks with a client which
+// can not create them, and the invalid or oversized arguments, e.g. of xattrs, fail without an RPC
 func isNonRetriableError(err error) bool {
 	if err == io.EOF ||
 		err == fuse.EEXIST ||
 		err == syscall.ENOENT ||
 		err == syscall.EACCES ||
+		err == syscall.EPERM ||
 		err == syscall.ENOTEMPTY ||
+		err == syscall.ENOTDIR ||
+		err == syscall.EISDIR ||
 		err == syscall.EEXIST ||
 		err == syscall.EROFS ||
 		err == syscall.EDQUOT ||
 		err == syscall.ENOLINK ||
+		err == syscall.ENODATA ||
+		err == syscall.ENOTSUP ||
+		err == syscall.EINVAL ||
+		err == syscall.E2BIG ||
+		err == syscall.ERANGE ||
 		err == os.ErrNotExist ||
 		err == os.ErrPermission ||
 		err == os.ErrExist ||
@@ -415,107 +526,251 @@ func isNonRetriableError(err error) bool {
 	}
 }
 
+// Returns true if the error indicates a broken connection to the name node rather than a failed
+// request. The errors of the client which are not recognized, e.g. network errors, are translated to EIO
+func isConnectionError(err error) bool {
+	switch unwrapAndTranslateError(err) {
+	case syscall.EIO, syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.ETIMEDOUT,
+		syscall.EPIPE, syscall.ENOTCONN, syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+		return true
+	}
+	return false
+}
+
 // Creates a directory
 func (dfs *HdfsAccessorImpl) Mkdir(path string, mode os.FileMode) error {
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
+	}
+	err = client.Mkdir(path, mode)
+	dfs.releaseClient(client, err)
+	return unwrapAndTranslateError(err)
+}
 
-	if dfs.MetadataClient == nil {
-		if err := dfs.connectMetadataClient(); err != nil {
-			return unwrapAndTranslateError(err)
-		}
+// Creates a directory along with its missing parents, nothing is done if it already exists
+func (dfs *HdfsAccessorImpl) MkdirAll(path string, mode os.FileMode) error {
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
 	}
-	err := dfs.MetadataClient.Mkdir(path, mode)
+	err = client.MkdirAll(path, mode)
+	dfs.releaseClient(client, err)
 	return unwrapAndTranslateError(err)
 }
 
 // Removes file or directory
 func (dfs *HdfsAccessorImpl) Remove(path string) error {
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
-
-	if dfs.MetadataClient == nil {
-		if err := dfs.connectMetadataClient(); err != nil {
-			return unwrapAndTranslateError(err)
-		}
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
 	}
-	err := dfs.MetadataClient.Remove(path)
+	err = client.Remove(path)
+	dfs.releaseClient(client, err)
 	return unwrapAndTranslateError(err)
 }
 
 // Renames file or directory
 func (dfs *HdfsAccessorImpl) Rename(oldPath string, newPath string) error {
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
-
-	if dfs.MetadataClient == nil {
-		if err := dfs.connectMetadataClient(); err != nil {
-			return unwrapAndTranslateError(err)
-		}
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
 	}
-	err := dfs.MetadataClient.Rename(oldPath, newPath)
+	err = client.Rename(oldPath, newPath)
+	dfs.releaseClient(client, err)
 	return unwrapAndTranslateError(err)
 }
 
 // Renames file or directory with options
 func (dfs *HdfsAccessorImpl) Rename2(oldPath string, newPath string, options hdfs.RenameOptions) error {
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
-
-	if dfs.MetadataClient == nil {
-		if err := dfs.connectMetadataClient(); err != nil {
-			return unwrapAndTranslateError(err)
-		}
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
 	}
-	err := dfs.MetadataClient.Rename2(oldPath, newPath, options)
+	err = client.Rename2(oldPath, newPath, options)
+	dfs.releaseClient(client, err)
 	return unwrapAndTranslateError(err)
 }
 
 // Changes the mode of the file
 func (dfs *HdfsAccessorImpl) Chmod(path string, mode os.FileMode) error {
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
+	}
+	err = client.Chmod(path, mode)
+	dfs.releaseClient(client, err)
+	return unwrapAndTranslateError(err)
+}
 
-	if dfs.MetadataClient == nil {
-		if err := dfs.connectMetadataClient(); err != nil {
-			return unwrapAndTranslateError(err)
-		}
+// Changes the access and modification times of the file. HopsFS keeps them with a precision of seconds
+func (dfs *HdfsAccessorImpl) SetTimes(path string, atime, mtime time.Time) error {
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
 	}
-	err := dfs.MetadataClient.Chmod(path, mode)
+	err = client.Chtimes(path, atime, mtime)
+	dfs.releaseClient(client, err)
 	return unwrapAndTranslateError(err)
 }
 
 // Changes the owner and group of the file
 func (dfs *HdfsAccessorImpl) Chown(path string, user, group string) error {
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
+	}
+	err = client.Chown(path, user, group)
+	dfs.releaseClient(client, err)
+	return unwrapAndTranslateError(err)
+}
+
+// Message of the error returned by the HopsFS client for missing extended attributes
+const hdfsXAttrNotFound = "one or more keys not found"
+
+// Returns the value of an extended attribute, ENODATA if it does not exist
+func (dfs *HdfsAccessorImpl) GetXAttr(path string, name string) ([]byte, error) {
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return nil, err
+	}
+	xattrs, err := client.GetXAttrs(path, name)
+	dfs.releaseClient(client, err)
+	if err != nil {
+		return nil, translateXAttrError(err)
+	}
+	value, ok := xattrs[name]
+	if !ok {
+		return nil, syscall.ENODATA
+	}
+	return []byte(value), nil
+}
+
+// Returns the names of the extended attributes visible to the user
+func (dfs *HdfsAccessorImpl) ListXAttrs(path string) ([]string, error) {
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return nil, err
+	}
+	xattrs, err := client.ListXAttrs(path)
+	dfs.releaseClient(client, err)
+	if err != nil {
+		return nil, translateXAttrError(err)
+	}
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Creates or replaces an extended attribute
+func (dfs *HdfsAccessorImpl) SetXAttr(path string, name string, value []byte) error {
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
+	}
+	err = client.SetXAttr(path, name, string(value))
+	dfs.releaseClient(client, err)
+	return translateXAttrError(err)
+}
 
-	if dfs.MetadataClient == nil {
-		if err := dfs.connectMetadataClient(); err != nil {
-			return unwrapAndTranslateError(err)
+// Removes an extended attribute, ENODATA if it does not exist
+func (dfs *HdfsAccessorImpl) RemoveXAttr(path string, name string) error {
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
+	}
+	err = client.RemoveXAttr(path, name)
+	dfs.releaseClient(client, err)
+	return translateXAttrError(err)
+}
+
+// Translates the errors of the xattr calls. Missing attributes are reported as ENODATA
+// and invalid names as EINVAL
+func translateXAttrError(err error) error {
+	if pathError, ok := err.(*os.PathError); ok && pathError.Err != nil {
+		msg := pathError.Err.Error()
+		if msg == hdfsXAttrNotFound {
+			return syscall.ENODATA
+		}
+		if strings.HasPrefix(msg, "invalid key") {
+			return syscall.EINVAL
 		}
 	}
-	err := dfs.MetadataClient.Chown(path, user, group)
 	return unwrapAndTranslateError(err)
 }
 
-// Close current connection if needed
-func (dfs *HdfsAccessorImpl) Close() error {
-	dfs.lockHadoopClient()
-	defer dfs.unlockHadoopClient()
+// Retrieves the HopsFS metadata of a file or directory
+func (dfs *HdfsAccessorImpl) GetMetadata(path string) (Metadata, error) {
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return Metadata{}, err
+	}
+	fileInfo, err := client.Stat(path)
+	dfs.releaseClient(client, err)
+	if err != nil {
+		return Metadata{}, unwrapAndTranslateError(err)
+	}
+	status := fileInfo.Sys().(*hdfs.FileStatus)
+	return Metadata{
+		Replication:   uint16(status.GetBlockReplication()),
+		BlockSize:     status.GetBlocksize(),
+		StoragePolicy: status.GetStoragePolicy()}, nil
+}
 
-	if dfs.MetadataClient != nil {
-		err := dfs.MetadataClient.Close()
-		dfs.MetadataClient = nil
-		return unwrapAndTranslateError(err)
+// Retrieves the summary of the subtree rooted at a file or directory
+func (dfs *HdfsAccessorImpl) GetContentSummary(path string) (ContentSummary, error) {
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return ContentSummary{}, err
 	}
-	return nil
+	summary, err := client.GetContentSummary(path)
+	dfs.releaseClient(client, err)
+	if err != nil {
+		return ContentSummary{}, unwrapAndTranslateError(err)
+	}
+	return ContentSummary{
+		Length:         summary.Size(),
+		FileCount:      summary.FileCount(),
+		DirectoryCount: summary.DirectoryCount(),
+		SpaceConsumed:  summary.SizeAfterReplication(),
+		NameQuota:      summary.NameQuota(),
+		SpaceQuota:     summary.SpaceQuota()}, nil
 }
 
-func (dfs *HdfsAccessorImpl) lockHadoopClient() {
-	dfs.MetadataClientMutex.Lock()
+// Changes the replication factor of a file. The name node refuses to do it for directories
+func (dfs *HdfsAccessorImpl) SetReplication(path string, replication uint16) error {
+	client, err := dfs.acquireClient()
+	if err != nil {
+		return err
+	}
+	ok, err := client.SetReplication(path, int16(replication))
+	dfs.releaseClient(client, err)
+	if err != nil {
+		return unwrapAndTranslateError(err)
+	}
+	if !ok {
+		return syscall.EISDIR
+	}
+	return nil
 }
 
-func (dfs *HdfsAccessorImpl) unlockHadoopClient() {
-	dfs.MetadataClientMutex.Unlock()
+// Closes all the connections of the pool. Waits for the in-flight operations to finish
+func (dfs *HdfsAccessorImpl) Close() error {
+	var firstErr error
+	poolSize := cap(dfs.clients)
+	for i := 0; i < poolSize; i++ {
+		client := <-dfs.clients
+		if client != nil {
+			if err := client.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	for i := 0; i < poolSize; i++ {
+		dfs.clients <- nil
+	}
+	return unwrapAndTranslateError(firstErr)
 }
@@ -0,0 +1,365 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// Weight of the latest sample in the moving average of the latency (1/latencySmoothing)
+const latencySmoothing = 8
+
+// Pool of HdfsAccessors (connectors). Requests are routed to the least loaded healthy
+// connector. A connector that fails with a retriable error is taken out of rotation
+// and probed in the background until it is able to connect again.
+// Concurrency: thread safe
+type HdfsAccessorPool struct {
+	Clock         Clock         // interface to get wall clock time
+	ProbeInterval time.Duration // delay between attempts to reconnect a broken connector
+	connectors    []*pooledHdfsAccessor
+	next          int           // index to start the search from, to spread the requests between idle connectors
+	closed        chan struct{} // closed when the pool is closed, the probes stop
+	closeOnce     sync.Once
+	mutex         sync.Mutex
+}
+
+// HdfsAccessor that reports its load and health back to the pool
+type pooledHdfsAccessor struct {
+	Impl     HdfsAccessor
+	pool     *HdfsAccessorPool
+	id       int
+	inFlight int           // number of requests currently executed by this connector
+	latency  time.Duration // moving average of the request latency
+	healthy  bool          // false if the connector is out of rotation
+}
+
+var _ HdfsAccessor = (*pooledHdfsAccessor)(nil) // ensure pooledHdfsAccessor implements HdfsAccessor
+
+// Creates a pool of the given connectors. All the connectors are considered healthy initially
+func NewHdfsAccessorPool(hdfsAccessors []HdfsAccessor, clock Clock) *HdfsAccessorPool {
+	pool := &HdfsAccessorPool{
+		Clock:         clock,
+		ProbeInterval: ConnectorProbeInterval,
+		closed:        make(chan struct{}),
+	}
+	for i, hdfsAccessor := range hdfsAccessors {
+		pool.connectors = append(pool.connectors, &pooledHdfsAccessor{
+			Impl:    hdfsAccessor,
+			pool:    pool,
+			id:      i,
+			healthy: true,
+		})
+	}
+	return pool
+}
+
+// Returns the connector with the least requests in flight, ties are broken by the lowest latency.
+// Broken connectors are used only if there is no healthy one
+func (pool *HdfsAccessorPool) Get() HdfsAccessor {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if len(pool.connectors) == 0 {
+		return nil
+	}
+
+	var best *pooledHdfsAccessor
+	for i := 0; i < len(pool.connectors); i++ {
+		c := pool.connectors[(pool.next+i)%len(pool.connectors)]
+		if best == nil || c.isBetterThan(best) {
+			best = c
+		}
+	}
+	pool.next = (pool.next + 1) % len(pool.connectors)
+	return best
+}
+
+// Stops the probes of the broken connectors. The connectors themselves are not closed
+func (pool *HdfsAccessorPool) Close() {
+	pool.closeOnce.Do(func() {
+		close(pool.closed)
+	})
+}
+
+// Returns the number of connectors in rotation
+func (pool *HdfsAccessorPool) HealthyCount() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	count := 0
+	for _, c := range pool.connectors {
+		if c.healthy {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *pooledHdfsAccessor) isBetterThan(other *pooledHdfsAccessor) bool {
+	if c.healthy != other.healthy {
+		return c.healthy
+	}
+	if c.inFlight != other.inFlight {
+		return c.inFlight < other.inFlight
+	}
+	return c.latency < other.latency
+}
+
+// Registers the start of a request
+func (c *pooledHdfsAccessor) begin() time.Time {
+	c.pool.mutex.Lock()
+	defer c.pool.mutex.Unlock()
+	c.inFlight++
+	return c.pool.Clock.Now()
+}
+
+// Registers the end of a request. Takes the connector out of rotation if the request
+// failed with an error that indicates a problem with the connection, see isConnectionError
+func (c *pooledHdfsAccessor) end(start time.Time, err error) {
+	elapsed := c.pool.Clock.Now().Sub(start)
+
+	c.pool.mutex.Lock()
+	defer c.pool.mutex.Unlock()
+	c.inFlight--
+	c.latency += (elapsed - c.latency) / latencySmoothing
+
+	if err == nil || !isConnectionError(err) || !c.healthy {
+		return
+	}
+	c.healthy = false
+	logger.Warn("Connector is unhealthy, taking it out of rotation", logger.Fields{Connector: c.id, Error: err})
+	go c.probe()
+}
+
+// Periodically tries to reconnect the broken connector and brings it back into rotation on success.
+// Gives up once the pool is closed
+func (c *pooledHdfsAccessor) probe() {
+	for {
+		select {
+		case <-c.pool.closed:
+			logger.Debug("Pool closed, connector is no longer probed", logger.Fields{Connector: c.id})
+			return
+		case <-c.pool.Clock.After(c.pool.ProbeInterval):
+		}
+		err := c.Impl.EnsureConnected()
+		if err == nil {
+			break
+		}
+		logger.Debug("Connector is still unhealthy", logger.Fields{Connector: c.id, Error: err})
+	}
+
+	c.pool.mutex.Lock()
+	defer c.pool.mutex.Unlock()
+	c.healthy = true
+	logger.Info("Connector is healthy again, bringing it back into rotation", logger.Fields{Connector: c.id})
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node
+func (c *pooledHdfsAccessor) EnsureConnected() error {
+	start := c.begin()
+	err := c.Impl.EnsureConnected()
+	c.end(start, err)
+	return err
+}
+
+// Opens HDFS file for reading
+func (c *pooledHdfsAccessor) OpenRead(path string) (ReadSeekCloser, error) {
+	start := c.begin()
+	result, err := c.Impl.OpenRead(path)
+	c.end(start, err)
+	return result, err
+}
+
+// Opens HDFS file for writing
+func (c *pooledHdfsAccessor) CreateFile(path string, mode os.FileMode, overwrite bool) (HdfsWriter, error) {
+	start := c.begin()
+	result, err := c.Impl.CreateFile(path, mode, overwrite)
+	c.end(start, err)
+	return result, err
+}
+
+// Opens HDFS file for appending
+func (c *pooledHdfsAccessor) Append(path string) (HdfsWriter, error) {
+	start := c.begin()
+	result, err := c.Impl.Append(path)
+	c.end(start, err)
+	return result, err
+}
+
+// Enumerates HDFS directory
+func (c *pooledHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	start := c.begin()
+	result, err := c.Impl.ReadDir(path)
+	c.end(start, err)
+	return result, err
+}
+
+// Opens HDFS directory for enumerating it page by page
+func (c *pooledHdfsAccessor) OpenDir(path string) (DirLister, error) {
+	start := c.begin()
+	result, err := c.Impl.OpenDir(path)
+	c.end(start, err)
+	return result, err
+}
+
+// Retrieves file/directory attributes
+func (c *pooledHdfsAccessor) Stat(path string) (Attrs, error) {
+	start := c.begin()
+	result, err := c.Impl.Stat(path)
+	c.end(start, err)
+	return result, err
+}
+
+// Retrieves HDFS usage
+func (c *pooledHdfsAccessor) StatFs() (FsInfo, error) {
+	start := c.begin()
+	result, err := c.Impl.StatFs()
+	c.end(start, err)
+	return result, err
+}
+
+// Creates a directory
+func (c *pooledHdfsAccessor) Mkdir(path string, mode os.FileMode) error {
+	start := c.begin()
+	err := c.Impl.Mkdir(path, mode)
+	c.end(start, err)
+	return err
+}
+
+// Creates a directory along with its missing parents
+func (c *pooledHdfsAccessor) MkdirAll(path string, mode os.FileMode) error {
+	start := c.begin()
+	err := c.Impl.MkdirAll(path, mode)
+	c.end(start, err)
+	return err
+}
+
+// Removes a file or directory
+func (c *pooledHdfsAccessor) Remove(path string) error {
+	start := c.begin()
+	err := c.Impl.Remove(path)
+	c.end(start, err)
+	return err
+}
+
+// Renames a file or directory
+func (c *pooledHdfsAccessor) Rename(oldPath string, newPath string) error {
+	start := c.begin()
+	err := c.Impl.Rename(oldPath, newPath)
+	c.end(start, err)
+	return err
+}
+
+// Renames a file or directory with options
+func (c *pooledHdfsAccessor) Rename2(oldPath string, newPath string, options hdfs.RenameOptions) error {
+	start := c.begin()
+	err := c.Impl.Rename2(oldPath, newPath, options)
+	c.end(start, err)
+	return err
+}
+
+// Changes the mode of the file
+func (c *pooledHdfsAccessor) Chmod(path string, mode os.FileMode) error {
+	start := c.begin()
+	err := c.Impl.Chmod(path, mode)
+	c.end(start, err)
+	return err
+}
+
+// Changes the access and modification times of the file
+func (c *pooledHdfsAccessor) SetTimes(path string, atime, mtime time.Time) error {
+	start := c.begin()
+	err := c.Impl.SetTimes(path, atime, mtime)
+	c.end(start, err)
+	return err
+}
+
+// Changes the owner and group of the file
+func (c *pooledHdfsAccessor) Chown(path string, user, group string) error {
+	start := c.begin()
+	err := c.Impl.Chown(path, user, group)
+	c.end(start, err)
+	return err
+}
+
+// Creates a symbolic link
+func (c *pooledHdfsAccessor) CreateSymlink(target string, link string) error {
+	start := c.begin()
+	err := c.Impl.CreateSymlink(target, link)
+	c.end(start, err)
+	return err
+}
+
+// Returns the target of a symbolic link
+func (c *pooledHdfsAccessor) ReadLink(path string) (string, error) {
+	start := c.begin()
+	result, err := c.Impl.ReadLink(path)
+	c.end(start, err)
+	return result, err
+}
+
+// Returns the value of an extended attribute
+func (c *pooledHdfsAccessor) GetXAttr(path string, name string) ([]byte, error) {
+	start := c.begin()
+	result, err := c.Impl.GetXAttr(path, name)
+	c.end(start, err)
+	return result, err
+}
+
+// Returns the names of the extended attributes
+func (c *pooledHdfsAccessor) ListXAttrs(path string) ([]string, error) {
+	start := c.begin()
+	result, err := c.Impl.ListXAttrs(path)
+	c.end(start, err)
+	return result, err
+}
+
+// Creates or replaces an extended attribute
+func (c *pooledHdfsAccessor) SetXAttr(path string, name string, value []byte) error {
+	start := c.begin()
+	err := c.Impl.SetXAttr(path, name, value)
+	c.end(start, err)
+	return err
+}
+
+// Removes an extended attribute
+func (c *pooledHdfsAccessor) RemoveXAttr(path string, name string) error {
+	start := c.begin()
+	err := c.Impl.RemoveXAttr(path, name)
+	c.end(start, err)
+	return err
+}
+
+// Retrieves the HopsFS metadata of a file or directory
+func (c *pooledHdfsAccessor) GetMetadata(path string) (Metadata, error) {
+	start := c.begin()
+	result, err := c.Impl.GetMetadata(path)
+	c.end(start, err)
+	return result, err
+}
+
+// Retrieves the summary of a subtree
+func (c *pooledHdfsAccessor) GetContentSummary(path string) (ContentSummary, error) {
+	start := c.begin()
+	result, err := c.Impl.GetContentSummary(path)
+	c.end(start, err)
+	return result, err
+}
+
+// Changes the replication factor of a file
+func (c *pooledHdfsAccessor) SetReplication(path string, replication uint16) error {
+	start := c.begin()
+	err := c.Impl.SetReplication(path, replication)
+	c.end(start, err)
+	return err
+}
+
+// Close current meta connection if needed. Does not affect the health of the connector
+func (c *pooledHdfsAccessor) Close() error {
+	return c.Impl.Close()
+}
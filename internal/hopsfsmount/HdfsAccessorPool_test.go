@@ -0,0 +1,115 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHdfsAccessorPoolLeastLoaded(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor1 := NewMockHdfsAccessor(mockCtrl)
+	hdfsAccessor2 := NewMockHdfsAccessor(mockCtrl)
+	pool := NewHdfsAccessorPool([]HdfsAccessor{hdfsAccessor1, hdfsAccessor2}, &MockClock{})
+
+	// a request in flight on the first connector
+	start := pool.connectors[0].begin()
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, pool.connectors[1], pool.Get())
+	}
+	pool.connectors[0].end(start, nil)
+
+	// both idle, the one with the lower latency wins
+	pool.connectors[0].latency = 10 * time.Millisecond
+	pool.connectors[1].latency = 20 * time.Millisecond
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, pool.connectors[0], pool.Get())
+	}
+}
+
+func TestHdfsAccessorPoolLatency(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	pool := NewHdfsAccessorPool([]HdfsAccessor{hdfsAccessor}, mockClock)
+
+	hdfsAccessor.EXPECT().Stat("/foo").DoAndReturn(func(path string) (Attrs, error) {
+		mockClock.NotifyTimeElapsed(80 * time.Millisecond)
+		return Attrs{}, nil
+	})
+	_, err := pool.Get().Stat("/foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Millisecond, pool.connectors[0].latency)
+	assert.Equal(t, 0, pool.connectors[0].inFlight)
+}
+
+func TestHdfsAccessorPoolUnhealthy(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor1 := NewMockHdfsAccessor(mockCtrl)
+	hdfsAccessor2 := NewMockHdfsAccessor(mockCtrl)
+	pool := NewHdfsAccessorPool([]HdfsAccessor{hdfsAccessor1, hdfsAccessor2}, WallClock{})
+	pool.ProbeInterval = time.Millisecond
+
+	// benign errors do not affect the health of the connector
+	hdfsAccessor1.EXPECT().Stat("/missing").Return(Attrs{}, syscall.ENOENT)
+	_, err := pool.connectors[0].Stat("/missing")
+	assert.Equal(t, syscall.ENOENT, err)
+	hdfsAccessor1.EXPECT().ReadLink("/file").Return("", syscall.EINVAL)
+	_, err = pool.connectors[0].ReadLink("/file")
+	assert.Equal(t, syscall.EINVAL, err)
+	hdfsAccessor1.EXPECT().CreateSymlink("target", "/link").Return(syscall.ENOTSUP)
+	assert.Equal(t, syscall.ENOTSUP, pool.connectors[0].CreateSymlink("target", "/link"))
+	hdfsAccessor1.EXPECT().GetXAttr("/file", "user.big").Return(nil, syscall.ERANGE)
+	_, err = pool.connectors[0].GetXAttr("/file", "user.big")
+	assert.Equal(t, syscall.ERANGE, err)
+	assert.Equal(t, 2, pool.HealthyCount())
+
+	reconnected := make(chan struct{})
+	hdfsAccessor1.EXPECT().Stat("/foo").Return(Attrs{}, syscall.EIO)
+	gomock.InOrder(
+		hdfsAccessor1.EXPECT().EnsureConnected().Return(syscall.EIO),
+		hdfsAccessor1.EXPECT().EnsureConnected().DoAndReturn(func() error {
+			<-reconnected
+			return nil
+		}),
+	)
+	_, err = pool.connectors[0].Stat("/foo")
+	assert.Equal(t, syscall.EIO, err)
+	assert.Equal(t, 1, pool.HealthyCount())
+
+	// the broken connector is out of rotation
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, pool.connectors[1], pool.Get())
+	}
+
+	close(reconnected)
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.HealthyCount() != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, 2, pool.HealthyCount())
+}
+
+func TestHdfsAccessorPoolClose(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	hdfsAccessor1 := NewMockHdfsAccessor(mockCtrl)
+	hdfsAccessor2 := NewMockHdfsAccessor(mockCtrl)
+	pool := NewHdfsAccessorPool([]HdfsAccessor{hdfsAccessor1, hdfsAccessor2}, WallClock{})
+	pool.ProbeInterval = time.Hour
+
+	hdfsAccessor1.EXPECT().Stat("/foo").Return(Attrs{}, syscall.EIO)
+	_, err := pool.connectors[0].Stat("/foo")
+	assert.Equal(t, syscall.EIO, err)
+	assert.Equal(t, 1, pool.HealthyCount())
+
+	// the probe gives up without reconnecting, EnsureConnected is not expected
+	pool.Close()
+	pool.Close()
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 1, pool.HealthyCount())
+}
@@ -0,0 +1,33 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataClientPoolSize(t *testing.T) {
+	defer func(old int) { MetadataConnections = old }(MetadataConnections)
+
+	for _, c := range []struct{ configured, expected int }{{3, 3}, {1, 1}, {0, 1}} {
+		MetadataConnections = c.configured
+		// the clients connect lazily, no name node is contacted by this test
+		accessor, err := NewHdfsAccessor("unused.invalid:8020", WallClock{}, TLSConfig{TLS: false})
+		assert.Nil(t, err)
+		dfs := accessor.(*HdfsAccessorImpl)
+		assert.Equal(t, c.expected, cap(dfs.clients))
+		// clients are not connected until they are used
+		assert.Equal(t, c.expected, len(dfs.clients))
+		for i := 0; i < c.expected; i++ {
+			client := <-dfs.clients
+			assert.Nil(t, client)
+			dfs.clients <- client
+		}
+
+		// closing an unused pool keeps all the slots available
+		assert.Nil(t, dfs.Close())
+		assert.Equal(t, c.expected, len(dfs.clients))
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"io"
+	"os"
+
+	"github.com/colinmarc/hdfs/v2"
+)
+
+// Enumerates a directory page by page, so that huge directories are never held in memory as a whole.
+// Entries are returned sorted by name, as returned by the name node
+// Concurrency: not thread safe: at most on request at a time
+type DirLister interface {
+	Next(n int) ([]Attrs, error) // Returns up to n entries following the ones returned so far, io.EOF at the end
+	Close() error                // Closes the listing
+}
+
+// Lists an HDFS directory with getListing requests, each of them continuing after the last returned entry
+type HdfsDirLister struct {
+	BackendReader     *hdfs.FileReader
+	attrsFromFileInfo func(os.FileInfo) Attrs
+}
+
+var _ DirLister = (*HdfsDirLister)(nil) // ensure HdfsDirLister implements DirLister
+
+// Creates new instance of HdfsDirLister
+func NewHdfsDirLister(backendReader *hdfs.FileReader, attrsFromFileInfo func(os.FileInfo) Attrs) DirLister {
+	return &HdfsDirLister{BackendReader: backendReader, attrsFromFileInfo: attrsFromFileInfo}
+}
+
+// Returns the next page of the listing
+func (l *HdfsDirLister) Next(n int) ([]Attrs, error) {
+	files, err := l.BackendReader.Readdir(n)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, unwrapAndTranslateError(err)
+	}
+	allAttrs := make([]Attrs, len(files))
+	for i, fileInfo := range files {
+		allAttrs[i] = l.attrsFromFileInfo(fileInfo)
+	}
+	return allAttrs, nil
+}
+
+// Closes the listing
+func (l *HdfsDirLister) Close() error {
+	return unwrapAndTranslateError(l.BackendReader.Close())
+}
+
+// Adds automatic retry capability to DirLister with respect to RetryPolicy. A failed listing is
+// opened again and continues after the last returned entry
+type FaultTolerantDirLister struct {
+	Impl        DirLister
+	Accessor    HdfsAccessor // used to open the listing again
+	Path        string
+	RetryPolicy *RetryPolicy
+	last        string // name of the last returned entry
+}
+
+var _ DirLister = (*FaultTolerantDirLister)(nil) // ensure FaultTolerantDirLister implements DirLister
+
+// Returns the next page of the listing
+func (l *FaultTolerantDirLister) Next(n int) ([]Attrs, error) {
+	op := l.RetryPolicy.StartOperation()
+	for {
+		result, err := l.next(n)
+		if err == nil || err == io.EOF {
+			if len(result) > 0 {
+				l.last = result[len(result)-1].Name
+			}
+			return result, err
+		}
+		if IsSuccessOrNonRetriableError(err) || !op.ShouldRetry("[%s] ReadDir: %s", l.Path, err) {
+			return nil, err
+		}
+		// Clean up the bad connection, to let underline connection to get automatic refresh
+		if l.Impl != nil {
+			l.Impl.Close()
+			l.Impl = nil
+		}
+		l.Accessor.Close()
+	}
+}
+
+func (l *FaultTolerantDirLister) next(n int) ([]Attrs, error) {
+	if l.Impl == nil {
+		impl, err := l.Accessor.OpenDir(l.Path)
+		if err != nil {
+			return nil, err
+		}
+		l.Impl = impl
+	}
+	for {
+		result, err := l.Impl.Next(n)
+		if err != nil {
+			return nil, err
+		}
+		// a reopened listing starts from the beginning, skipping the entries returned already
+		skip := 0
+		for skip < len(result) && result[skip].Name <= l.last {
+			skip++
+		}
+		if skip < len(result) {
+			return result[skip:], nil
+		}
+	}
+}
+
+// Closes the listing
+func (l *FaultTolerantDirLister) Close() error {
+	if l.Impl == nil {
+		return nil
+	}
+	return l.Impl.Close()
+}
@@ -0,0 +1,161 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Returned by the pool once it is closed. The file proxy that owns the pool has been replaced
+var errReaderPoolClosed = errors.New("reader pool is closed")
+
+// Pool of independent readers of a file, so that positional reads at different offsets run in
+// parallel. A read is routed to the idle reader whose position is closest to the requested offset.
+// A new reader is opened only when all the readers are busy, up to MaxReaders.
+// Concurrency: thread safe
+type HdfsReaderPool struct {
+	MaxReaders int
+	open       func() (ReadSeekCloser, error) // opens a new reader of the file
+	idle       []*pooledReader
+	size       int // number of readers, idle or in use
+	closed     bool
+	mutex      sync.Mutex
+	released   *sync.Cond // signaled when a reader is returned to the pool
+}
+
+// Reader of the pool and its position
+type pooledReader struct {
+	reader ReadSeekCloser
+	pos    int64
+}
+
+// Creates a pool of readers of a file, starting with an already opened reader
+func NewHdfsReaderPool(reader ReadSeekCloser, maxReaders int, open func() (ReadSeekCloser, error)) *HdfsReaderPool {
+	if maxReaders < 1 {
+		maxReaders = 1
+	}
+	pool := &HdfsReaderPool{MaxReaders: maxReaders, open: open}
+	pool.released = sync.NewCond(&pool.mutex)
+	if reader != nil {
+		pool.idle = append(pool.idle, &pooledReader{reader: reader})
+		pool.size = 1
+	}
+	return pool
+}
+
+// Reads len(b) bytes at the given offset, unless the end of the file is reached
+func (pool *HdfsReaderPool) ReadAt(b []byte, off int64) (int, error) {
+	r, err := pool.acquire(off)
+	if err != nil {
+		return 0, err
+	}
+
+	if r.pos != off {
+		if err := r.reader.Seek(off); err != nil {
+			pool.release(r, err)
+			return 0, err
+		}
+		r.pos = off
+	}
+
+	n := 0
+	for n < len(b) {
+		m, e := r.reader.Read(b[n:])
+		n += m
+		r.pos += int64(m)
+		if e != nil {
+			err = e
+			break
+		}
+	}
+	pool.release(r, err)
+	return n, err
+}
+
+// Returns the number of readers, idle or in use
+func (pool *HdfsReaderPool) Size() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.size
+}
+
+// Closes the idle readers. The readers in use are closed when they are released
+func (pool *HdfsReaderPool) Close() error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	pool.closed = true
+	var err error
+	for _, r := range pool.idle {
+		if e := r.reader.Close(); e != nil {
+			err = e
+		}
+		pool.size--
+	}
+	pool.idle = nil
+	pool.released.Broadcast()
+	return err
+}
+
+// Takes the idle reader closest to the offset. Opens a new reader if all of them are in use,
+// or waits for one if the pool is full
+func (pool *HdfsReaderPool) acquire(off int64) (*pooledReader, error) {
+	pool.mutex.Lock()
+	for {
+		if pool.closed {
+			pool.mutex.Unlock()
+			return nil, errReaderPoolClosed
+		}
+		if len(pool.idle) > 0 {
+			best := 0
+			for i, r := range pool.idle {
+				if distance(r.pos, off) < distance(pool.idle[best].pos, off) {
+					best = i
+				}
+			}
+			r := pool.idle[best]
+			pool.idle = append(pool.idle[:best], pool.idle[best+1:]...)
+			pool.mutex.Unlock()
+			return r, nil
+		}
+		if pool.size < pool.MaxReaders {
+			break
+		}
+		pool.released.Wait()
+	}
+	pool.size++
+	pool.mutex.Unlock()
+
+	reader, err := pool.open()
+	if err != nil {
+		pool.mutex.Lock()
+		pool.size--
+		pool.released.Signal()
+		pool.mutex.Unlock()
+		return nil, err
+	}
+	return &pooledReader{reader: reader}, nil
+}
+
+// Returns the reader to the pool. A reader that has failed is closed, as its state is unknown
+func (pool *HdfsReaderPool) release(r *pooledReader, err error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if pool.closed || (err != nil && err != io.EOF) {
+		r.reader.Close()
+		pool.size--
+	} else {
+		pool.idle = append(pool.idle, r)
+	}
+	pool.released.Signal()
+}
+
+func distance(a int64, b int64) int64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
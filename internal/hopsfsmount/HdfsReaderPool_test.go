@@ -0,0 +1,120 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHdfsReaderPool(maxReaders int, stats *ReaderStats) (*HdfsReaderPool, *int) {
+	opened := 0
+	var mutex sync.Mutex
+	open := func() (ReadSeekCloser, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		opened++
+		return &MockReadSeekCloserWithPseudoRandomContent{FileSize: 1024 * 1024, ReaderStats: stats}, nil
+	}
+	reader, _ := open()
+	return NewHdfsReaderPool(reader, maxReaders, open), &opened
+}
+
+func TestHdfsReaderPoolRouting(t *testing.T) {
+	stats := &ReaderStats{}
+	pool, opened := newTestHdfsReaderPool(4, stats)
+
+	// a single reader serves the reads as long as they do not overlap
+	buf := make([]byte, 1000)
+	_, err := pool.ReadAt(buf, 0)
+	assert.Nil(t, err)
+	_, err = pool.ReadAt(buf, 500000)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, *opened)
+	assert.Equal(t, 1, pool.Size())
+
+	// concurrent reads use more readers
+	r1, err := pool.acquire(0)
+	assert.Nil(t, err)
+	r2, err := pool.acquire(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, *opened)
+	assert.Nil(t, r1.reader.Seek(1000))
+	assert.Nil(t, r2.reader.Seek(200000))
+	r1.pos, r2.pos = 1000, 200000
+	pool.release(r1, nil)
+	pool.release(r2, nil)
+
+	// the read is routed to the reader positioned at the offset, no seek is needed
+	seeks := stats.SeekCount
+	n, err := pool.ReadAt(buf, 200000)
+	assert.Nil(t, err)
+	assert.Equal(t, len(buf), n)
+	assert.Equal(t, seeks, stats.SeekCount)
+	for i, b := range buf {
+		assert.Equal(t, generateByteAtOffset(200000+int64(i)), b)
+	}
+
+	// a read at the end of the file returns io.EOF and keeps the reader
+	_, err = pool.ReadAt(buf, 1024*1024)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, pool.Size())
+
+	assert.Nil(t, pool.Close())
+	assert.Equal(t, 0, pool.Size())
+	_, err = pool.ReadAt(buf, 0)
+	assert.Equal(t, errReaderPoolClosed, err)
+}
+
+func TestHdfsReaderPoolLimit(t *testing.T) {
+	pool, opened := newTestHdfsReaderPool(2, nil)
+
+	r1, _ := pool.acquire(0)
+	r2, _ := pool.acquire(0)
+	acquired := make(chan *pooledReader)
+	go func() {
+		r, _ := pool.acquire(0)
+		acquired <- r
+	}()
+
+	// the pool is full, the third read waits for a reader to be released
+	select {
+	case <-acquired:
+		t.Fatal("Reader acquired from a full pool")
+	case <-time.After(50 * time.Millisecond):
+	}
+	pool.release(r1, nil)
+	r3 := <-acquired
+	assert.Equal(t, r1, r3)
+	assert.Equal(t, 2, *opened)
+	pool.release(r2, nil)
+	pool.release(r3, nil)
+}
+
+func TestHdfsReaderPoolParallelReads(t *testing.T) {
+	pool, _ := newTestHdfsReaderPool(4, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			buf := make([]byte, 4096)
+			n, err := pool.ReadAt(buf, off)
+			assert.Nil(t, err)
+			assert.Equal(t, len(buf), n)
+			for j, b := range buf {
+				if b != generateByteAtOffset(off+int64(j)) {
+					t.Errorf("Unexpected byte at offset %d", off+int64(j))
+					return
+				}
+			}
+		}(int64(i) * 50000)
+	}
+	wg.Wait()
+	assert.True(t, pool.Size() <= 4)
+	assert.Nil(t, pool.Close())
+}
@@ -44,8 +44,7 @@ func (w *hdfsWriterImpl) Write(buffer []byte) (int, error) {
 
 // Flushes all the data
 func (w *hdfsWriterImpl) Flush() error {
-	logger.Error("Flush is not implemented", nil)
-	return syscall.ENOSYS
+	return unwrapAndTranslateError(w.BackendWriter.Flush())
 }
 
 // Closes the stream
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"syscall"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/colinmarc/hdfs/v2"
 	"golang.org/x/net/context"
 	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
 )
@@ -45,8 +47,9 @@ func (fh *FileHandle) Truncate(size int64) error {
 	fh.lockHandle()
 	defer fh.unlockHandle()
 
-	// as an optimization the file is initially opened in readonly mode
-	fh.File.upgradeHandleForWriting(fh, Truncate)
+	// as an optimization the file is initially opened in readonly mode. The content that is
+	// truncated away is not fetched, e.g. when a file is opened with O_TRUNC
+	fh.File.upgradeHandleForWriting(fh, Truncate, size == 0)
 
 	sizeChanged, err := fh.File.fileProxy.Truncate(size)
 	if err != nil {
@@ -69,13 +72,10 @@ func (fh *FileHandle) Attr(ctx context.Context, a *fuse.Attr) error {
 
 // Responds to FUSE Read request
 func (fh *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	fh.lockHandle()
-	defer fh.unlockHandle()
-
 	buf := resp.Data[0:req.Size]
-	nr, err := fh.File.fileProxy.ReadAt(buf, req.Offset)
+	nr, err := fh.readAt(buf, req.Offset)
 	resp.Data = buf[0:nr]
-	fh.tatalBytesRead += int64(nr)
+	atomic.AddInt64(&fh.tatalBytesRead, int64(nr))
 
 	if err != nil {
 		if err == io.EOF {
@@ -99,7 +99,7 @@ func (fh *FileHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *f
 	defer fh.unlockHandle()
 
 	// as an optimization the file is initially opened in readonly mode
-	fh.File.upgradeHandleForWriting(fh, Write)
+	fh.File.upgradeHandleForWriting(fh, Write, false)
 
 	nw, err := fh.File.fileProxy.WriteAt(req.Data, req.Offset)
 	resp.Size = nw
@@ -113,14 +113,44 @@ func (fh *FileHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *f
 	}
 }
 
-func (fh *FileHandle) copyToDFS(operation string) error {
+// Reads through the file proxy. Reads of a remote file do not hold the handle lock,
+// so that concurrent reads of the handle run in parallel
+func (fh *FileHandle) readAt(buf []byte, off int64) (int, error) {
+	for {
+		fh.lockHandle()
+		remoteROFileProxy, ok := fh.File.fileProxy.(*RemoteROFileProxy)
+		if !ok {
+			defer fh.unlockHandle()
+			return fh.File.fileProxy.ReadAt(buf, off)
+		}
+		fh.unlockHandle()
+
+		nr, err := remoteROFileProxy.ReadAtForHandle(fh.fhID, buf, off)
+		if err != errReaderPoolClosed {
+			return nr, err
+		}
+		// the file has been opened for writing meanwhile, read from the new proxy
+	}
+}
+
+func (fh *FileHandle) copyToDFS(operation string) (err error) {
 	if fh.totalBytesWritten == 0 { // Nothing to do
 		return nil
 	}
 	defer fh.File.InvalidateMetadataCache()
+	defer func() {
+		if err == nil {
+			fh.File.restoreTimes(operation)
+		}
+	}()
 
 	logger.Debug("Uploading to DFS", fh.logInfo(logger.Fields{Operation: operation, Bytes: fh.totalBytesWritten}))
 
+	// the data is already in DFS, it only needs to be committed
+	if streamingProxy, ok := fh.File.fileProxy.(*StreamingWriteFileProxy); ok {
+		return streamingProxy.Commit(operation)
+	}
+
 	op := fh.File.FileSystem.RetryPolicy.StartOperation()
 	for {
 		err := fh.FlushAttempt(operation)
@@ -135,26 +165,57 @@ func (fh *FileHandle) copyToDFS(operation string) error {
 
 func (fh *FileHandle) FlushAttempt(operation string) error {
 	hdfsAccessor := fh.File.FileSystem.getDFSConnector()
-	//delete the file and then rewrite.
+
+	lrwfp, isStaged := fh.File.fileProxy.(*LocalRWFileProxy)
+	if isStaged {
+		appended, err := fh.appendAttempt(hdfsAccessor, lrwfp, operation)
+		if appended || err != nil {
+			return err
+		}
+	}
+
+	//upload to a hidden sibling and then swap it in with a single rename, so that the file
+	//is never missing or partially written in DFS.
 	//note we can not rely on the overwrite functionality of CreateFile API.
-	//For example if the file has permission set to 444 then we can not overwrite it
-	err := hdfsAccessor.Remove(fh.File.AbsolutePath())
-	if err != nil {
-		// may be this is a retry and the file has already been deleted
-		// log error and continue
-		logger.Warn("Unable to delete the file during flush.", fh.logInfo(logger.Fields{Operation: operation, Error: err}))
+	//For example if the file has permission set to 444 then we can not overwrite it,
+	//however renaming over it only requires write permission on the parent directory
+	absPath := fh.File.AbsolutePath()
+	mode, owner, group := fh.File.Attrs.Mode, fh.File.Attrs.DFSUserName, fh.File.Attrs.DFSGroupName
+	existing := false
+	if attrs, err := hdfsAccessor.Stat(absPath); err == nil {
+		mode, owner, group = attrs.Mode, attrs.DFSUserName, attrs.DFSGroupName
+		existing = true
 	}
 
-	w, err := hdfsAccessor.CreateFile(fh.File.AbsolutePath(), fh.File.Attrs.Mode, true)
+	tmpPath := TempSiblingPath(absPath)
+	// the sibling is removed on the next mount if this process dies before it is renamed
+	journalPath, err := fh.File.FileSystem.writeUploadRecord(tmpPath)
+	if err != nil {
+		logger.Error("Unable to write the upload journal", fh.logInfo(logger.Fields{Operation: operation, TmpFile: tmpPath, Error: err}))
+		return syscall.EIO
+	}
+	w, err := hdfsAccessor.CreateFile(tmpPath, mode, true)
 	if err != nil {
-		logger.Error("Error creating file in DFS", fh.logInfo(logger.Fields{Operation: operation, Error: err}))
+		logger.Error("Error creating file in DFS", fh.logInfo(logger.Fields{Operation: operation, TmpFile: tmpPath, Error: err}))
+		removeUploadRecord(journalPath)
 		return err
 	}
+	replaced := false
+	defer func() {
+		if !replaced {
+			if err := hdfsAccessor.Remove(tmpPath); err != nil {
+				logger.Warn("Unable to delete the temporary file", fh.logInfo(logger.Fields{Operation: operation, TmpFile: tmpPath, Error: err}))
+				return
+			}
+		}
+		removeUploadRecord(journalPath)
+	}()
 
 	//open the file for reading and upload to DFS
 	err = fh.File.fileProxy.SeekToStart()
 	if err != nil {
 		logger.Error("Unable to seek to the begenning of the temp file", fh.logInfo(logger.Fields{Operation: operation, Error: err}))
+		w.Close()
 		return err
 	}
 
@@ -164,6 +225,7 @@ func (fh *FileHandle) FlushAttempt(operation string) error {
 		nr, err := fh.File.fileProxy.Read(b)
 		if err != nil && err != io.EOF {
 			logger.Error("Failed to read from staging file", fh.logInfo(logger.Fields{Operation: operation, Error: err}))
+			w.Close()
 			return err
 		}
 
@@ -197,12 +259,106 @@ func (fh *FileHandle) FlushAttempt(operation string) error {
 		logger.Error("Failed to close file in DFS", fh.logInfo(logger.Fields{Operation: operation, Error: err}))
 		return err
 	}
+
+	// the name node applies its umask on create, and the file is owned by the mount user
+	if err := hdfsAccessor.Chmod(tmpPath, mode.Perm()); err != nil {
+		logger.Warn("Unable to restore the permissions of the file", fh.logInfo(logger.Fields{Operation: operation, Mode: mode, Error: err}))
+	}
+	if owner != "" || group != "" {
+		if err := hdfsAccessor.Chown(tmpPath, owner, group); err != nil {
+			logger.Warn("Unable to restore the ownership of the file", fh.logInfo(logger.Fields{Operation: operation, User: owner, Group: group, Error: err}))
+		}
+	}
+	if existing {
+		fh.copyXAttrs(hdfsAccessor, absPath, tmpPath, operation)
+	}
+
+	err = hdfsAccessor.Rename2(tmpPath, absPath, hdfs.RENAME_OPTION_NONE)
+	if err != nil {
+		logger.Error("Failed to replace the file in DFS", fh.logInfo(logger.Fields{Operation: operation, TmpFile: tmpPath, Error: err}))
+		return err
+	}
+	replaced = true
 	logger.Info("Uploaded to DFS", fh.logInfo(logger.Fields{Operation: operation, Bytes: written}))
 
+	if isStaged {
+		lrwfp.setUploaded(int64(written), fh.uploadedAttrs(hdfsAccessor, operation))
+	}
 	fh.File.Attrs.Size = written
 	return nil
 }
 
+// Copies the extended attributes of the file to the file which replaces it. Like the permissions,
+// they are restored on a best effort basis, the upload does not fail if they can not be
+func (fh *FileHandle) copyXAttrs(hdfsAccessor HdfsAccessor, from string, to string, operation string) {
+	names, err := hdfsAccessor.ListXAttrs(from)
+	if err != nil {
+		logger.Warn("Unable to list the extended attributes of the file", fh.logInfo(logger.Fields{Operation: operation, Error: err}))
+	}
+	for _, name := range names {
+		value, err := hdfsAccessor.GetXAttr(from, name)
+		if err == nil {
+			err = hdfsAccessor.SetXAttr(to, name, value)
+		}
+		if err != nil {
+			logger.Warn("Unable to restore an extended attribute of the file", fh.logInfo(logger.Fields{Operation: operation, XAttr: name, Error: err}))
+		}
+	}
+}
+
+// Uploads only the data appended to the staging file since the file was staged or last uploaded.
+// Returns false if the whole file has to be rewritten instead, because the application has
+// modified the existing content, or the file has been changed in DFS by someone else
+func (fh *FileHandle) appendAttempt(hdfsAccessor HdfsAccessor, lrwfp *LocalRWFileProxy, operation string) (bool, error) {
+	offset, size, ok := lrwfp.appendRange()
+	if !ok || offset == 0 {
+		// appending to an empty file is no cheaper than rewriting it
+		return false, nil
+	}
+
+	// the file may have been replaced, or rewritten with the same size, since it was staged
+	attrs, err := hdfsAccessor.Stat(fh.File.AbsolutePath())
+	if err != nil || !lrwfp.isRemoteUnchanged(attrs) {
+		logger.Info("File has changed in DFS, rewriting it", fh.logInfo(logger.Fields{Operation: operation, FileSize: attrs.Size, Offset: offset, Error: err}))
+		return false, nil
+	}
+
+	if size > offset {
+		w, err := hdfsAccessor.Append(fh.File.AbsolutePath())
+		if err != nil {
+			logger.Info("Unable to open file for append, rewriting it", fh.logInfo(logger.Fields{Operation: operation, Error: err}))
+			return false, nil
+		}
+
+		_, err = io.Copy(w, io.NewSectionReader(lrwfp, offset, size-offset))
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			// some of the data may have been appended, so the next attempt has to rewrite the file
+			lrwfp.requireRewrite()
+			logger.Error("Failed to append to DFS", fh.logInfo(logger.Fields{Operation: operation, Offset: offset, Error: err}))
+			return true, err
+		}
+	}
+	logger.Info("Appended to DFS", fh.logInfo(logger.Fields{Operation: operation, Offset: offset, Bytes: size - offset}))
+
+	lrwfp.setUploaded(size, fh.uploadedAttrs(hdfsAccessor, operation))
+	fh.File.Attrs.Size = uint64(size)
+	return true, nil
+}
+
+// Returns the attributes of the file that has just been uploaded, against which the next append is
+// validated. Returns zero attributes if they can not be read
+func (fh *FileHandle) uploadedAttrs(hdfsAccessor HdfsAccessor, operation string) Attrs {
+	attrs, err := hdfsAccessor.Stat(fh.File.AbsolutePath())
+	if err != nil {
+		logger.Warn("Unable to stat the uploaded file", fh.logInfo(logger.Fields{Operation: operation, Error: err}))
+		return Attrs{}
+	}
+	return attrs
+}
+
 // Responds to the FUSE Flush request
 func (fh *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
 	fh.lockHandle()
@@ -233,11 +389,15 @@ func (fh *FileHandle) Release(_ context.Context, _ *fuse.ReleaseRequest) error {
 	fh.lockHandle()
 	defer fh.unlockHandle()
 
+	if remoteROFileProxy, ok := fh.File.fileProxy.(*RemoteROFileProxy); ok {
+		remoteROFileProxy.ReleaseHandle(fh.fhID)
+	}
+
 	//close the file handle if it is the last handle
 	fh.File.InvalidateMetadataCache()
 	fh.File.RemoveHandle(fh)
 
-	logger.Info("Closed file handle ", fh.logInfo(logger.Fields{Operation: Close, Flags: fh.fileFlags, TotalBytesRead: fh.tatalBytesRead, TotalBytesWritten: fh.totalBytesWritten}))
+	logger.Info("Closed file handle ", fh.logInfo(logger.Fields{Operation: Close, Flags: fh.fileFlags, TotalBytesRead: atomic.LoadInt64(&fh.tatalBytesRead), TotalBytesWritten: fh.totalBytesWritten}))
 	return nil
 }
 
@@ -0,0 +1,213 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"sync"
+	"time"
+
+	"bazil.org/fuse/fs"
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// Ids of the inodes whose HopsFS file id is not known yet, e.g. new directories, start here
+const localINodeIDBase = 1 << 62
+
+// Table of the file and directory inodes, keyed by HopsFS file id. The children of a directory
+// are names mapped to ids of this table. An inode stays in the table while the kernel references it,
+// i.e. until the kernel forgets all of its lookups, or while the file is open. The inodes created
+// for listed entries which the kernel never looks up are dropped once they are not used for a while.
+// Concurrency: thread safe
+type INodeTable struct {
+	Clock       Clock
+	entries     map[uint64]*inodeTableEntry
+	ids         map[fs.Node]uint64
+	nextLocalID uint64
+	pruneAt     int // number of entries at which the unreferenced inodes are dropped
+	mutex       sync.Mutex
+}
+
+type inodeTableEntry struct {
+	node     fs.Node
+	lookups  uint64    // number of lookups by the kernel which have not been forgotten
+	lastUsed time.Time // last time the inode was added or found in the table
+}
+
+// Minimum number of entries at which the unreferenced inodes are dropped
+const inodeTableMinPruneAt = 1024
+
+// Time for which an unreferenced inode is kept after its last use
+const inodeTableIdleTime = time.Minute
+
+// Creates an empty inode table
+func NewINodeTable(clock Clock) *INodeTable {
+	return &INodeTable{
+		Clock:       clock,
+		entries:     make(map[uint64]*inodeTableEntry),
+		ids:         make(map[fs.Node]uint64),
+		nextLocalID: localINodeIDBase,
+		pruneAt:     inodeTableMinPruneAt,
+	}
+}
+
+// Returns the inode with the given id, nil if it is not in the table
+func (table *INodeTable) Get(id uint64) fs.Node {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	if e, ok := table.entries[id]; ok {
+		e.lastUsed = table.Clock.Now()
+		return e.node
+	}
+	return nil
+}
+
+// Returns the inode with the given id like Get, without counting it as used
+func (table *INodeTable) Peek(id uint64) fs.Node {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	if e, ok := table.entries[id]; ok {
+		return e.node
+	}
+	return nil
+}
+
+// Returns the id of the inode, false if it is not in the table
+func (table *INodeTable) ID(node fs.Node) (uint64, bool) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	id, ok := table.ids[node]
+	return id, ok
+}
+
+// Adds the inode to the table under its file id, or under a local id if the file id is not known
+// yet. Returns the id. Returns the unreferenced inodes dropped to bound the size of the table,
+// which the caller must detach from their parents
+func (table *INodeTable) Add(node fs.Node, fileID uint64) (uint64, []fs.Node) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	if id, ok := table.ids[node]; ok {
+		return id, nil
+	}
+	id := fileID
+	if id == 0 {
+		id = table.nextLocalID
+		table.nextLocalID++
+	}
+	if old, ok := table.entries[id]; ok {
+		// the id is reused by another inode, the old one is only reachable by the kernel now
+		delete(table.ids, old.node)
+	}
+	table.entries[id] = &inodeTableEntry{node: node, lastUsed: table.Clock.Now()}
+	table.ids[node] = id
+
+	var dropped []fs.Node
+	if len(table.entries) >= table.pruneAt {
+		dropped = table.prune()
+		table.pruneAt = 2 * len(table.entries)
+		if table.pruneAt < inodeTableMinPruneAt {
+			table.pruneAt = inodeTableMinPruneAt
+		}
+	}
+	return id, dropped
+}
+
+// Moves the inode to its file id, once it is known or after it has changed
+func (table *INodeTable) Rekey(node fs.Node, fileID uint64) uint64 {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	id, ok := table.ids[node]
+	if !ok || id == fileID {
+		return id
+	}
+	e := table.entries[id]
+	delete(table.entries, id)
+	if old, ok := table.entries[fileID]; ok {
+		delete(table.ids, old.node)
+	}
+	table.entries[fileID] = e
+	table.ids[node] = fileID
+	logger.Debug("Inode id changed", logger.Fields{Operation: Lookup, ID: fileID})
+	return fileID
+}
+
+// Records a lookup of the inode by the kernel
+func (table *INodeTable) Lookup(node fs.Node) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	if id, ok := table.ids[node]; ok {
+		table.entries[id].lookups++
+	}
+}
+
+// Records that the kernel forgot all the lookups of the inode. Returns true if the inode was dropped
+// from the table, then the caller must detach it from its parent
+func (table *INodeTable) Forget(node fs.Node) bool {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	id, ok := table.ids[node]
+	if !ok {
+		return false
+	}
+	e := table.entries[id]
+	e.lookups = 0
+	if isINodeOpen(node) {
+		return false
+	}
+	delete(table.entries, id)
+	delete(table.ids, node)
+	return true
+}
+
+// Drops the inode from the table if the kernel does not reference it. Returns true if it was dropped
+func (table *INodeTable) Release(node fs.Node) bool {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	id, ok := table.ids[node]
+	if !ok || table.entries[id].lookups > 0 || isINodeOpen(node) {
+		return false
+	}
+	delete(table.entries, id)
+	delete(table.ids, node)
+	return true
+}
+
+// Returns the number of inodes in the table
+func (table *INodeTable) Len() int {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	return len(table.entries)
+}
+
+// Drops the inodes which the kernel does not reference and which have not been used for a while
+func (table *INodeTable) prune() []fs.Node {
+	idleSince := table.Clock.Now().Add(-inodeTableIdleTime)
+	var dropped []fs.Node
+	for id, e := range table.entries {
+		if e.lookups > 0 || e.lastUsed.After(idleSince) || isINodeOpen(e.node) {
+			continue
+		}
+		delete(table.entries, id)
+		delete(table.ids, e.node)
+		dropped = append(dropped, e.node)
+	}
+	if len(dropped) > 0 {
+		logger.Debug("Dropped unreferenced inodes", logger.Fields{Operation: Forget, Entries: len(dropped)})
+	}
+	return dropped
+}
+
+// Returns true if the inode is a file with open handles
+func isINodeOpen(node fs.Node) bool {
+	if file, ok := node.(*FileINode); ok {
+		return file.countActiveHandles() > 0
+	}
+	return false
+}
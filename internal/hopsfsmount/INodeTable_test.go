@@ -0,0 +1,117 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestINodeTableForget(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "a")
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	dir := node.(*DirINode)
+	a1, err := dir.Lookup(nil, "a")
+	assert.Nil(t, err)
+	attrs, _ := mem.Stat("/dir/a")
+	assert.Equal(t, a1, fs.INodes.Get(attrs.Inode))
+	assert.Equal(t, 2, fs.INodes.Len())
+
+	// the inode is dropped once the kernel forgets it
+	a1.(*FileINode).Forget()
+	assert.Nil(t, fs.INodes.Get(attrs.Inode))
+	assert.Equal(t, 0, len(dir.children))
+	a2, err := dir.Lookup(nil, "a")
+	assert.Nil(t, err)
+	assert.True(t, a1 != a2)
+
+	// a path deleted and created again gets a new inode
+	assert.Nil(t, mem.Remove("/dir/a"))
+	memWriteFile(t, mem, "/dir/a", "b")
+	mockClock.NotifyTimeElapsed(CacheAttrsTimeDuration + time.Second)
+	var attr fuse.Attr
+	assert.Nil(t, a2.Attr(nil, &attr))
+	a3, err := dir.Lookup(nil, "a")
+	assert.Nil(t, err)
+	assert.True(t, a2 != a3)
+	attrs, _ = mem.Stat("/dir/a")
+	assert.Equal(t, a3, fs.INodes.Get(attrs.Inode))
+
+	// the inodes of new dirs are moved to their file id once it is known
+	e, err := dir.Mkdir(nil, &fuse.MkdirRequest{Name: "e", Mode: os.ModeDir | 0755})
+	assert.Nil(t, err)
+	id, _ := fs.INodes.ID(e)
+	assert.True(t, id >= localINodeIDBase)
+	assert.Nil(t, e.Attr(nil, &attr))
+	attrs, _ = mem.Stat("/dir/e")
+	id, _ = fs.INodes.ID(e)
+	assert.Equal(t, attrs.Inode, id)
+}
+
+func TestINodeTableOpenFileReplaced(t *testing.T) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	memWriteFile(t, mem, "/file", "hello world")
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "file")
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+
+	// rewriting the file replaces it in DFS with a new file id, the open inode stays the same
+	before, _ := mem.Stat("/file")
+	assert.Nil(t, fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("J"), Offset: 0}, &fuse.WriteResponse{}))
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	after, _ := mem.Stat("/file")
+	assert.NotEqual(t, before.Inode, after.Inode)
+	_, err = root.(*DirINode).statInodeInHopsFS(GetattrFile, "file", &Attrs{})
+	assert.Nil(t, err)
+	assert.Equal(t, file, fs.INodes.Get(after.Inode))
+	node, err = root.(*DirINode).Lookup(nil, "file")
+	assert.Nil(t, err)
+	assert.Equal(t, file, node)
+
+	// an open file is not dropped when the kernel forgets it
+	file.Forget()
+	assert.Equal(t, file, fs.INodes.Get(after.Inode))
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+func TestINodeTablePrune(t *testing.T) {
+	mockClock := &MockClock{}
+	table := NewINodeTable(mockClock)
+	var referenced []*FileINode
+	for i := 1; i < inodeTableMinPruneAt; i++ {
+		node := &FileINode{Attrs: Attrs{Inode: uint64(i)}}
+		_, dropped := table.Add(node, uint64(i))
+		assert.Nil(t, dropped)
+		if i%2 == 0 {
+			table.Lookup(node)
+			referenced = append(referenced, node)
+		}
+	}
+
+	// the inodes which the kernel does not reference are dropped once they are idle
+	mockClock.NotifyTimeElapsed(inodeTableIdleTime + time.Second)
+	_, dropped := table.Add(&FileINode{}, inodeTableMinPruneAt)
+	assert.Equal(t, inodeTableMinPruneAt/2, len(dropped))
+	assert.Equal(t, len(referenced)+1, table.Len())
+	for _, node := range referenced {
+		assert.Equal(t, node, table.Get(node.Attrs.Inode))
+	}
+}
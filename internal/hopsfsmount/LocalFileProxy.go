@@ -4,19 +4,58 @@
 package hopsfsmount
 
 import (
+	"io"
 	"math"
 	"os"
+	"syscall"
+	"time"
 
 	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
 )
 
+// Size of the ranges in which the content of the remote file is fetched to the staging file
+const stagingBlockSize = 1024 * 1024
+
+// Staging file for a file opened for writing. The staging file is sparse: the content of
+// the file in DFS is fetched block by block, only when a block is read or partially overwritten.
+// The blocks that are never touched are read straight from DFS when the file is uploaded
 type LocalRWFileProxy struct {
-	localFile *os.File // handle to the temp file in staging dir
-	file      *FileINode
+	localFile   *os.File // handle to the temp file in staging dir
+	file        *FileINode
+	remoteSize  int64     // size of the file in DFS when it was staged or last uploaded
+	remoteInode uint64    // id of the file in DFS when it was staged or last uploaded, 0 if not known
+	remoteMtime time.Time // modification time of the file in DFS when it was staged or last uploaded
+	rewritten   bool      // true if the content up to remoteSize has changed, so appending the tail is not enough
+
+	remoteLimit  int64          // content of the remote file beyond this offset has been truncated away
+	fetched      map[int64]bool // blocks below remoteLimit that are present in the staging file
+	remoteReader ReadSeekCloser // reader of the remote file, opened on first use
+	readerValid  bool           // true once the remote reader is known to read the staged version of the file
+	readPos      int64          // position of the sequential reader used for uploading
 }
 
 var _ FileProxy = (*LocalRWFileProxy)(nil)
 
+// Creates a proxy for a staging file of a file with the given attributes in DFS. None of the
+// remote content is assumed to be present in the staging file. A staging file shorter than
+// the remote file stands for a file whose tail has been truncated away
+func newLocalRWFileProxy(localFile *os.File, file *FileINode, remote Attrs) *LocalRWFileProxy {
+	remoteSize := int64(remote.Size)
+	p := &LocalRWFileProxy{localFile: localFile, file: file, remoteSize: remoteSize,
+		remoteInode: remote.Inode, remoteMtime: remote.Mtime, fetched: make(map[int64]bool)}
+	fileInfo, err := localFile.Stat()
+	if err != nil {
+		p.rewritten = true
+		return p
+	}
+	p.remoteLimit = remoteSize
+	if fileInfo.Size() < remoteSize {
+		p.remoteLimit = fileInfo.Size()
+		p.rewritten = true
+	}
+	return p
+}
+
 func (p *LocalRWFileProxy) Truncate(size int64) (int64, error) {
 	p.file.lockFileHandles()
 	defer p.file.unlockFileHandles()
@@ -26,10 +65,18 @@ func (p *LocalRWFileProxy) Truncate(size int64) (int64, error) {
 		return 0, err
 	}
 
+	// the remote content beyond the new size must not reappear if the file grows again
+	if size < p.remoteLimit {
+		p.remoteLimit = size
+	}
+
 	err = p.localFile.Truncate(size)
 	if err != nil {
 		return 0, err
 	}
+	if size < p.remoteSize {
+		p.rewritten = true
+	}
 
 	statAfter, err := p.localFile.Stat()
 	if err != nil {
@@ -42,12 +89,30 @@ func (p *LocalRWFileProxy) Truncate(size int64) (int64, error) {
 func (p *LocalRWFileProxy) WriteAt(b []byte, off int64) (n int, err error) {
 	p.file.lockFileHandles()
 	defer p.file.unlockFileHandles()
+	if off < p.remoteSize {
+		p.rewritten = true
+	}
+
+	// blocks that are overwritten entirely do not have to be fetched
+	end := off + int64(len(b))
+	for block := off / stagingBlockSize; block*stagingBlockSize < end; block++ {
+		blockStart := block * stagingBlockSize
+		if blockStart >= off && blockStart+stagingBlockSize <= end {
+			p.fetched[block] = true
+		}
+	}
+	if err := p.fetchRange(off, end, Write); err != nil {
+		return 0, err
+	}
 	return p.localFile.WriteAt(b, off)
 }
 
 func (p *LocalRWFileProxy) ReadAt(b []byte, off int64) (n int, err error) {
 	p.file.lockFileHandles()
 	defer p.file.unlockFileHandles()
+	if err := p.fetchRange(off, off+int64(len(b)), Read); err != nil {
+		return 0, err
+	}
 	n, err = p.localFile.ReadAt(b, off)
 	logger.Debug("LocalFileProxy ReadAt", p.file.logInfo(logger.Fields{Operation: Read, Bytes: n, Error: err, Offset: off}))
 	return
@@ -56,18 +121,42 @@ func (p *LocalRWFileProxy) ReadAt(b []byte, off int64) (n int, err error) {
 func (p *LocalRWFileProxy) SeekToStart() (err error) {
 	p.file.lockFileHandles()
 	defer p.file.unlockFileHandles()
-	_, err = p.localFile.Seek(0, 0)
-	return
+	p.readPos = 0
+	return nil
 }
 
+// Reads the file sequentially. Blocks that have not been fetched are read from DFS
+// without storing them in the staging file
 func (p *LocalRWFileProxy) Read(b []byte) (n int, err error) {
 	p.file.lockFileHandles()
 	defer p.file.unlockFileHandles()
-	return p.localFile.Read(b)
+
+	pos := p.readPos
+	block := pos / stagingBlockSize
+	if pos < p.remoteLimit && !p.fetched[block] {
+		chunk := int64(len(b))
+		if blockEnd := (block + 1) * stagingBlockSize; pos+chunk > blockEnd {
+			chunk = blockEnd - pos
+		}
+		if pos+chunk > p.remoteLimit {
+			chunk = p.remoteLimit - pos
+		}
+		n, err = p.readRemote(b[:chunk], pos)
+	} else {
+		// the next block may not have been fetched
+		chunk := int64(len(b))
+		if blockEnd := (block + 1) * stagingBlockSize; blockEnd < p.remoteLimit && pos+chunk > blockEnd {
+			chunk = blockEnd - pos
+		}
+		n, err = p.localFile.ReadAt(b[:chunk], pos)
+	}
+	p.readPos += int64(n)
+	return n, err
 }
 
 func (p *LocalRWFileProxy) Close() error {
 	//NOTE: Locking is done in File.go
+	p.closeRemoteReader()
 	return p.localFile.Close()
 }
 
@@ -77,3 +166,141 @@ func (p *LocalRWFileProxy) Sync() error {
 	defer p.file.unlockFileHandles()
 	return p.localFile.Sync()
 }
+
+// Returns true if the file in DFS is still the one that was staged or last uploaded
+func (p *LocalRWFileProxy) isRemoteUnchanged(remote Attrs) bool {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	return p.remoteInode != 0 && p.matchesRemote(remote)
+}
+
+// Returns true if the attributes are those of the file that was staged or last uploaded.
+// Only the size is compared if the id of the file in DFS is not known
+func (p *LocalRWFileProxy) matchesRemote(remote Attrs) bool {
+	if p.remoteInode == 0 {
+		return int64(remote.Size) == p.remoteSize
+	}
+	return remote.Inode == p.remoteInode && int64(remote.Size) == p.remoteSize && remote.Mtime.Equal(p.remoteMtime)
+}
+
+// Returns the range of the staging file that has to be appended to the file in DFS.
+// ok is false if the content that is already in DFS has been modified
+func (p *LocalRWFileProxy) appendRange() (offset int64, size int64, ok bool) {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+
+	if p.rewritten {
+		return 0, 0, false
+	}
+	fileInfo, err := p.localFile.Stat()
+	if err != nil || fileInfo.Size() < p.remoteSize {
+		return 0, 0, false
+	}
+	return p.remoteSize, fileInfo.Size(), true
+}
+
+// Records that the first size bytes of the staging file are in DFS, in the file with the given
+// attributes. The attributes are zero if they could not be read, the next upload rewrites the file
+func (p *LocalRWFileProxy) setUploaded(size int64, remote Attrs) {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	p.remoteSize = size
+	p.remoteInode = remote.Inode
+	p.remoteMtime = remote.Mtime
+	p.rewritten = false
+	// the file in DFS has been replaced, the blocks that are not fetched yet have the same
+	// content in the new file. The next reader is checked against the new attributes
+	p.closeRemoteReader()
+}
+
+// Forces the next upload to rewrite the whole file
+func (p *LocalRWFileProxy) requireRewrite() {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	p.rewritten = true
+}
+
+// Fetches the blocks overlapping [start, end) that are not in the staging file yet
+func (p *LocalRWFileProxy) fetchRange(start int64, end int64, operation string) error {
+	if end > p.remoteLimit {
+		end = p.remoteLimit
+	}
+	for block := start / stagingBlockSize; block*stagingBlockSize < end; block++ {
+		if p.fetched[block] {
+			continue
+		}
+		blockStart := block * stagingBlockSize
+		blockEnd := blockStart + stagingBlockSize
+		if blockEnd > p.remoteLimit {
+			blockEnd = p.remoteLimit
+		}
+		buf := make([]byte, blockEnd-blockStart)
+		if _, err := p.readRemote(buf, blockStart); err != nil {
+			logger.Error("Failed to fetch content to staging file", p.file.logInfo(logger.Fields{Operation: operation, Offset: blockStart, Error: err}))
+			return err
+		}
+		if _, err := p.localFile.WriteAt(buf, blockStart); err != nil {
+			logger.Error("Failed to write to staging file", p.file.logInfo(logger.Fields{Operation: operation, Offset: blockStart, Error: err}))
+			return err
+		}
+		p.fetched[block] = true
+		logger.Trace("Fetched block to staging file", p.file.logInfo(logger.Fields{Operation: operation, Offset: blockStart, Bytes: len(buf)}))
+	}
+	return nil
+}
+
+// Reads exactly len(b) bytes of the remote file at the given offset
+func (p *LocalRWFileProxy) readRemote(b []byte, off int64) (int, error) {
+	if p.remoteReader == nil {
+		reader, err := p.file.FileSystem.getDFSConnector().OpenRead(p.file.AbsolutePath())
+		if err != nil {
+			logger.Error("Failed to open file in DFS", p.file.logInfo(logger.Fields{Operation: Read, Error: err}))
+			return 0, err
+		}
+		p.remoteReader = reader
+	}
+	if err := p.remoteReader.Seek(off); err != nil {
+		p.closeRemoteReader()
+		return 0, err
+	}
+	n, err := io.ReadFull(p.remoteReader, b)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// the file has been truncated in DFS by someone else
+		logger.Error("File in DFS is shorter than expected", p.file.logInfo(logger.Fields{Operation: Read, Offset: off, Bytes: n}))
+		err = syscall.EIO
+	}
+	if err == nil && !p.readerValid {
+		err = p.validateRemoteReader()
+	}
+	if err != nil {
+		p.closeRemoteReader()
+		return 0, err
+	}
+	return n, nil
+}
+
+// Checks that the file in DFS has not been replaced or modified by someone else since it was
+// staged or last uploaded. The check is done after the first read, as the reader looks up the
+// blocks of the file by path when it is first read, and keeps reading the same blocks afterwards
+func (p *LocalRWFileProxy) validateRemoteReader() error {
+	remote, err := p.file.FileSystem.getDFSConnector().Stat(p.file.AbsolutePath())
+	if err != nil {
+		logger.Error("Failed to stat file in DFS", p.file.logInfo(logger.Fields{Operation: Read, Error: err}))
+		return err
+	}
+	if !p.matchesRemote(remote) {
+		// the blocks that are not fetched yet are lost, they can not be read from the new file
+		logger.Error("File in DFS has been changed by someone else", p.file.logInfo(logger.Fields{Operation: Read}))
+		return syscall.EIO
+	}
+	p.readerValid = true
+	return nil
+}
+
+func (p *LocalRWFileProxy) closeRemoteReader() {
+	if p.remoteReader != nil {
+		p.remoteReader.Close()
+		p.remoteReader = nil
+	}
+	p.readerValid = false
+}
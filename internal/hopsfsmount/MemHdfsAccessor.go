@@ -0,0 +1,1097 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/ugcache"
+)
+
+// Name node address prefix which selects the in-memory backend, e.g. "mem://"
+const MemNameNodeScheme = "mem://"
+
+const (
+	memRootInodeID = 16385                     // id of the root directory, same as in HopsFS
+	memCapacity    = 1024 * 1024 * 1024 * 1024 // capacity reported by StatFs (1 TB)
+	memSuperUser   = "hdfs"                    // name node super user
+	memSuperGroup  = "supergroup"              // members of this group are super users too
+	memReplication = 3                         // replication factor of new files, as configured by default in HopsFS
+	memBlockSize   = 128 * 1024 * 1024         // block size of the files, as configured by default in HopsFS
+)
+
+// Permission classes checked by the in-memory name node
+const (
+	memRead    os.FileMode = 04
+	memWrite   os.FileMode = 02
+	memExecute os.FileMode = 01
+)
+
+// In-memory implementation of HdfsAccessor. The namespace and the file contents are kept in memory,
+// following HopsFS semantics for permissions, ownership and errors. Allows to mount and test
+// the file system without a name node. Data is lost when the process exits
+// Concurrency: thread safe: all operations are serialized on a single mutex
+type MemHdfsAccessor struct {
+	Clock       Clock  // interface to get wall clock time
+	UserName    string // user on whose behalf all the operations are performed
+	Capacity    uint64 // capacity reported by StatFs
+	root        *memINode
+	nextInodeID uint64
+	userGroups  map[string][]string // cached group membership of the users
+	mutex       sync.Mutex
+}
+
+var _ HdfsAccessor = (*MemHdfsAccessor)(nil) // ensure MemHdfsAccessor implements HdfsAccessor
+
+// Single file or directory in the in-memory namespace
+type memINode struct {
+	id          uint64
+	name        string
+	mode        os.FileMode // permission bits, and os.ModeDir for directories or os.ModeSymlink for links
+	owner       string
+	group       string
+	mtime       uint64 // milliseconds since epoch, as reported by HopsFS
+	atime       uint64
+	data        []byte               // contents of a file, or target of a link
+	children    map[string]*memINode // nil for files
+	xattrs      map[string][]byte    // extended attributes, keyed by name with the namespace prefix
+	replication uint16               // 0 for directories and links
+	nameQuota   int                  // quotas of a directory, 0 if not set
+	spaceQuota  int64
+	snapshots   *memINode // .snapshot directory of a snapshottable directory, holding the snapshots. nil otherwise
+}
+
+// Namespaces of the extended attributes supported by HopsFS
+var memXAttrNamespaces = []string{"user.", "trusted.", "system.", "security.", "raw."}
+
+// Returns true if the name node address selects the in-memory backend
+func IsMemNameNodeAddress(nameNodeAddress string) bool {
+	return strings.HasPrefix(nameNodeAddress, MemNameNodeScheme)
+}
+
+// Creates an instance of MemHdfsAccessor with an empty namespace.
+// The root directory is owned by the user on whose behalf the file system is accessed
+func NewMemHdfsAccessor(clock Clock) (*MemHdfsAccessor, error) {
+	userName, err := resolveHadoopUserName()
+	if err != nil {
+		return nil, err
+	}
+
+	mem := &MemHdfsAccessor{
+		Clock:       clock,
+		UserName:    userName,
+		Capacity:    memCapacity,
+		nextInodeID: memRootInodeID,
+		userGroups:  make(map[string][]string),
+	}
+
+	group := memSuperGroup
+	if groups := mem.groupsOf(userName); len(groups) > 0 {
+		group = groups[0]
+	}
+	mem.root = mem.newINode("/", 0755|os.ModeDir, group)
+	return mem, nil
+}
+
+// Ensures HDFS accessor is connected to the HDFS name node. No-op for in-memory backend
+func (mem *MemHdfsAccessor) EnsureConnected() error {
+	return nil
+}
+
+// Opens file for reading
+func (mem *MemHdfsAccessor) OpenRead(p string) (ReadSeekCloser, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("open", p)
+	if err != nil {
+		return nil, unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "open", Path: p, Err: os.ErrNotExist})
+	}
+	if node.isDir() {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "open", Path: p, Err: syscall.EISDIR})
+	}
+	if !mem.hasAccess(node, memRead) {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "open", Path: p, Err: os.ErrPermission})
+	}
+	node.atime = mem.now()
+	return &memReader{mem: mem, node: node}, nil
+}
+
+// Creates new file. The file is empty and visible in the namespace once this call returns
+func (mem *MemHdfsAccessor) CreateFile(p string, mode os.FileMode, overwrite bool) (HdfsWriter, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	parent, node, err := mem.resolve("create", p)
+	if err != nil {
+		return nil, unwrapAndTranslateError(err)
+	}
+	if parent == nil {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "create", Path: p, Err: os.ErrExist})
+	}
+	if node != nil {
+		if node.isDir() || !overwrite {
+			return nil, unwrapAndTranslateError(&os.PathError{Op: "create", Path: p, Err: os.ErrExist})
+		}
+		if !mem.hasAccess(node, memWrite) {
+			return nil, unwrapAndTranslateError(&os.PathError{Op: "create", Path: p, Err: os.ErrPermission})
+		}
+	}
+	if !mem.hasAccess(parent, memWrite) {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "create", Path: p, Err: os.ErrPermission})
+	}
+
+	// overwriting a file creates a new inode, just like in HopsFS. The sticky bit is kept on files too
+	node = mem.newINode(path.Base(p), mode&(os.ModePerm|hdfsStickyBit), parent.group)
+	parent.children[node.name] = node
+	parent.mtime = node.mtime
+	return &memWriter{mem: mem, node: node}, nil
+}
+
+// Opens an existing file for appending
+func (mem *MemHdfsAccessor) Append(p string) (HdfsWriter, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("append", p)
+	if err != nil {
+		return nil, unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "append", Path: p, Err: os.ErrNotExist})
+	}
+	if node.isDir() {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "append", Path: p, Err: syscall.EISDIR})
+	}
+	if !mem.hasAccess(node, memWrite) {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "append", Path: p, Err: os.ErrPermission})
+	}
+	return &memWriter{mem: mem, node: node}, nil
+}
+
+// Enumerates directory. Entries are sorted by name, as returned by the name node
+func (mem *MemHdfsAccessor) ReadDir(p string) ([]Attrs, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("readdir", p)
+	if err != nil {
+		return nil, unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "readdir", Path: p, Err: os.ErrNotExist})
+	}
+	if !node.isDir() {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "readdir", Path: p, Err: syscall.ENOTDIR})
+	}
+	if !mem.hasAccess(node, memRead|memExecute) {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "readdir", Path: p, Err: os.ErrPermission})
+	}
+
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	allAttrs := make([]Attrs, len(names))
+	for i, name := range names {
+		allAttrs[i] = mem.attrsFromINode(node.children[name], name)
+	}
+	return allAttrs, nil
+}
+
+// Opens directory for enumerating it page by page
+func (mem *MemHdfsAccessor) OpenDir(p string) (DirLister, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("readdir", p)
+	if err != nil {
+		return nil, unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "readdir", Path: p, Err: os.ErrNotExist})
+	}
+	if !node.isDir() {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "readdir", Path: p, Err: syscall.ENOTDIR})
+	}
+	if !mem.hasAccess(node, memRead|memExecute) {
+		return nil, unwrapAndTranslateError(&os.PathError{Op: "readdir", Path: p, Err: os.ErrPermission})
+	}
+	return &memDirLister{mem: mem, node: node}, nil
+}
+
+// Retrieves file/directory attributes
+func (mem *MemHdfsAccessor) Stat(p string) (Attrs, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("stat", p)
+	if err != nil {
+		return Attrs{}, unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return Attrs{}, unwrapAndTranslateError(&os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist})
+	}
+	return mem.attrsFromINode(node, path.Base(p)), nil
+}
+
+// Retrieves usage of the in-memory namespace
+func (mem *MemHdfsAccessor) StatFs() (FsInfo, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	used := mem.root.usedBytes()
+	remaining := uint64(0)
+	if used < mem.Capacity {
+		remaining = mem.Capacity - used
+	}
+	return FsInfo{capacity: mem.Capacity, used: used, remaining: remaining}, nil
+}
+
+// Creates a directory. The parent directory must exist
+func (mem *MemHdfsAccessor) Mkdir(p string, mode os.FileMode) error {
+	mem.lock()
+	defer mem.unlock()
+	return unwrapAndTranslateError(mem.mkdir(p, mode))
+}
+
+// Creates a directory along with any missing parents. Existing directories are left untouched
+func (mem *MemHdfsAccessor) MkdirAll(p string, mode os.FileMode) error {
+	mem.lock()
+	defer mem.unlock()
+
+	if !path.IsAbs(p) {
+		return unwrapAndTranslateError(&os.PathError{Op: "mkdir", Path: p, Err: os.ErrInvalid})
+	}
+	current := "/"
+	for _, name := range strings.Split(path.Clean(p), "/") {
+		if name == "" {
+			continue
+		}
+		current = path.Join(current, name)
+		_, node, err := mem.resolve("mkdir", current)
+		if err != nil {
+			return unwrapAndTranslateError(err)
+		}
+		if node != nil && node.isDir() {
+			continue
+		}
+		if err := mem.mkdir(current, mode); err != nil {
+			return unwrapAndTranslateError(err)
+		}
+	}
+	return nil
+}
+
+func (mem *MemHdfsAccessor) mkdir(p string, mode os.FileMode) error {
+	parent, node, err := mem.resolve("mkdir", p)
+	if err != nil {
+		return err
+	}
+	if parent == nil || node != nil {
+		return &os.PathError{Op: "mkdir", Path: p, Err: os.ErrExist}
+	}
+	if !mem.hasAccess(parent, memWrite) {
+		return &os.PathError{Op: "mkdir", Path: p, Err: os.ErrPermission}
+	}
+
+	node = mem.newINode(path.Base(p), mode.Perm()|os.ModeDir, parent.group)
+	parent.children[node.name] = node
+	parent.mtime = node.mtime
+	return nil
+}
+
+// Removes a file or an empty directory
+func (mem *MemHdfsAccessor) Remove(p string) error {
+	mem.lock()
+	defer mem.unlock()
+
+	parent, node, err := mem.resolve("remove", p)
+	if err != nil {
+		return unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return unwrapAndTranslateError(&os.PathError{Op: "remove", Path: p, Err: os.ErrNotExist})
+	}
+	if parent == nil {
+		// the root directory can not be deleted
+		return unwrapAndTranslateError(&os.PathError{Op: "remove", Path: p, Err: os.ErrPermission})
+	}
+	if !mem.hasAccess(parent, memWrite) {
+		return unwrapAndTranslateError(&os.PathError{Op: "remove", Path: p, Err: os.ErrPermission})
+	}
+	if node.isDir() && len(node.children) > 0 {
+		return unwrapAndTranslateError(&os.PathError{Op: "remove", Path: p, Err: syscall.ENOTEMPTY})
+	}
+
+	delete(parent.children, node.name)
+	parent.mtime = mem.now()
+	return nil
+}
+
+// Renames file or directory. The destination is replaced if it exists
+func (mem *MemHdfsAccessor) Rename(oldPath string, newPath string) error {
+	mem.lock()
+	defer mem.unlock()
+	return unwrapAndTranslateError(mem.rename(oldPath, newPath, true))
+}
+
+// Renames file or directory with options
+func (mem *MemHdfsAccessor) Rename2(oldPath string, newPath string, options hdfs.RenameOptions) error {
+	mem.lock()
+	defer mem.unlock()
+	overwrite := options&hdfs.RenameOptions(hdfs.RENAME_NOREPLACE) == 0
+	return unwrapAndTranslateError(mem.rename(oldPath, newPath, overwrite))
+}
+
+func (mem *MemHdfsAccessor) rename(oldPath string, newPath string, overwrite bool) error {
+	srcParent, srcNode, err := mem.resolve("rename", oldPath)
+	if err != nil {
+		return err
+	}
+	if srcNode == nil {
+		return &os.PathError{Op: "rename", Path: oldPath, Err: os.ErrNotExist}
+	}
+	if srcParent == nil {
+		return &os.PathError{Op: "rename", Path: oldPath, Err: os.ErrInvalid}
+	}
+	if strings.HasPrefix(path.Clean(newPath)+"/", path.Clean(oldPath)+"/") &&
+		path.Clean(newPath) != path.Clean(oldPath) {
+		// can not move a directory into its own subtree
+		return &os.PathError{Op: "rename", Path: newPath, Err: os.ErrInvalid}
+	}
+
+	dstParent, dstNode, err := mem.resolve("rename", newPath)
+	if err != nil {
+		return err
+	}
+	if dstParent == nil {
+		return &os.PathError{Op: "rename", Path: newPath, Err: os.ErrExist}
+	}
+	if !dstParent.isDir() {
+		return &os.PathError{Op: "rename", Path: newPath, Err: syscall.ENOTDIR}
+	}
+	if !mem.hasAccess(srcParent, memWrite) || !mem.hasAccess(dstParent, memWrite) {
+		return &os.PathError{Op: "rename", Path: oldPath, Err: os.ErrPermission}
+	}
+
+	if dstNode != nil {
+		if dstNode == srcNode || !overwrite {
+			return &os.PathError{Op: "rename", Path: newPath, Err: os.ErrExist}
+		}
+		if dstNode.isDir() {
+			if !srcNode.isDir() {
+				return &os.PathError{Op: "rename", Path: newPath, Err: syscall.EISDIR}
+			}
+			if len(dstNode.children) > 0 {
+				return &os.PathError{Op: "rename", Path: newPath, Err: syscall.ENOTEMPTY}
+			}
+		} else if srcNode.isDir() {
+			return &os.PathError{Op: "rename", Path: newPath, Err: syscall.ENOTDIR}
+		}
+		delete(dstParent.children, dstNode.name)
+	}
+
+	delete(srcParent.children, srcNode.name)
+	srcNode.name = path.Base(newPath)
+	dstParent.children[srcNode.name] = srcNode
+
+	now := mem.now()
+	srcParent.mtime = now
+	dstParent.mtime = now
+	return nil
+}
+
+// Changes the mode of the file. Only the owner or a super user can do this
+func (mem *MemHdfsAccessor) Chmod(p string, mode os.FileMode) error {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("chmod", p)
+	if err != nil {
+		return unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return unwrapAndTranslateError(&os.PathError{Op: "chmod", Path: p, Err: os.ErrNotExist})
+	}
+	if node.owner != mem.UserName && !mem.isSuperUser() {
+		return unwrapAndTranslateError(&os.PathError{Op: "chmod", Path: p, Err: os.ErrPermission})
+	}
+	node.mode = (node.mode &^ (os.ModePerm | hdfsStickyBit)) | mode&(os.ModePerm|hdfsStickyBit)
+	return nil
+}
+
+// Changes the access and modification times of the file. WRITE permission on the file is required
+func (mem *MemHdfsAccessor) SetTimes(p string, atime, mtime time.Time) error {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("setTimes", p)
+	if err != nil {
+		return unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return unwrapAndTranslateError(&os.PathError{Op: "setTimes", Path: p, Err: os.ErrNotExist})
+	}
+	if !mem.hasAccess(node, memWrite) {
+		return unwrapAndTranslateError(&os.PathError{Op: "setTimes", Path: p, Err: os.ErrPermission})
+	}
+	// stored with the precision of the HopsFS client
+	node.atime = uint64(atime.Unix()) * 1000
+	node.mtime = uint64(mtime.Unix()) * 1000
+	return nil
+}
+
+// Changes the owner and group of the file. Empty user or group is left unchanged.
+// Only a super user can change the owner. The owner can change the group to
+// any group the owner belongs to
+func (mem *MemHdfsAccessor) Chown(p string, owner, group string) error {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("chown", p)
+	if err != nil {
+		return unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return unwrapAndTranslateError(&os.PathError{Op: "chown", Path: p, Err: os.ErrNotExist})
+	}
+	if !mem.isSuperUser() {
+		if node.owner != mem.UserName ||
+			(owner != "" && owner != mem.UserName) ||
+			(group != "" && !mem.isMemberOf(group)) {
+			return unwrapAndTranslateError(&os.PathError{Op: "chown", Path: p, Err: os.ErrPermission})
+		}
+	}
+	if owner != "" {
+		node.owner = owner
+	}
+	if group != "" {
+		node.group = group
+	}
+	return nil
+}
+
+// Creates a symbolic link. Links are not followed when paths are resolved, the kernel resolves them
+func (mem *MemHdfsAccessor) CreateSymlink(target string, link string) error {
+	mem.lock()
+	defer mem.unlock()
+
+	parent, node, err := mem.resolve("symlink", link)
+	if err != nil {
+		return unwrapAndTranslateError(err)
+	}
+	if parent == nil || node != nil {
+		return unwrapAndTranslateError(&os.PathError{Op: "symlink", Path: link, Err: os.ErrExist})
+	}
+	if !mem.hasAccess(parent, memWrite) {
+		return unwrapAndTranslateError(&os.PathError{Op: "symlink", Path: link, Err: os.ErrPermission})
+	}
+	node = mem.newINode(path.Base(link), os.ModeSymlink|os.ModePerm, parent.group)
+	node.data = []byte(target)
+	parent.children[node.name] = node
+	parent.mtime = node.mtime
+	return nil
+}
+
+// Returns the target of a symbolic link
+func (mem *MemHdfsAccessor) ReadLink(p string) (string, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("readlink", p)
+	if err != nil {
+		return "", unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return "", unwrapAndTranslateError(&os.PathError{Op: "readlink", Path: p, Err: os.ErrNotExist})
+	}
+	if node.mode&os.ModeSymlink == 0 {
+		return "", syscall.EINVAL
+	}
+	return string(node.data), nil
+}
+
+// Returns the value of an extended attribute, ENODATA if it does not exist.
+// READ permission on the file is required, like in HopsFS
+func (mem *MemHdfsAccessor) GetXAttr(p string, name string) ([]byte, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	node, err := mem.resolveXAttr("getxattr", p, name, memRead)
+	if err != nil {
+		return nil, unwrapAndTranslateError(err)
+	}
+	value, ok := node.xattrs[name]
+	if !ok {
+		return nil, syscall.ENODATA
+	}
+	return append([]byte(nil), value...), nil
+}
+
+// Returns the names of the extended attributes, sorted
+func (mem *MemHdfsAccessor) ListXAttrs(p string) ([]string, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	node, err := mem.resolveXAttr("listxattr", p, "", memRead)
+	if err != nil {
+		return nil, unwrapAndTranslateError(err)
+	}
+	names := make([]string, 0, len(node.xattrs))
+	for name := range node.xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Creates or replaces an extended attribute. WRITE permission on the file is required
+func (mem *MemHdfsAccessor) SetXAttr(p string, name string, value []byte) error {
+	mem.lock()
+	defer mem.unlock()
+
+	node, err := mem.resolveXAttr("setxattr", p, name, memWrite)
+	if err != nil {
+		return unwrapAndTranslateError(err)
+	}
+	if node.xattrs == nil {
+		node.xattrs = make(map[string][]byte)
+	}
+	node.xattrs[name] = append([]byte(nil), value...)
+	return nil
+}
+
+// Removes an extended attribute, ENODATA if it does not exist. WRITE permission on the file is required
+func (mem *MemHdfsAccessor) RemoveXAttr(p string, name string) error {
+	mem.lock()
+	defer mem.unlock()
+
+	node, err := mem.resolveXAttr("removexattr", p, name, memWrite)
+	if err != nil {
+		return unwrapAndTranslateError(err)
+	}
+	if _, ok := node.xattrs[name]; !ok {
+		return syscall.ENODATA
+	}
+	delete(node.xattrs, name)
+	return nil
+}
+
+// Resolves the file of an xattr call and checks the name, unless it is empty, and the access
+func (mem *MemHdfsAccessor) resolveXAttr(op, p string, name string, access os.FileMode) (*memINode, error) {
+	if name != "" {
+		valid := false
+		for _, ns := range memXAttrNamespaces {
+			valid = valid || (strings.HasPrefix(name, ns) && len(name) > len(ns))
+		}
+		if !valid {
+			return nil, &os.PathError{Op: op, Path: p, Err: os.ErrInvalid}
+		}
+	}
+	_, node, err := mem.resolve(op, p)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, &os.PathError{Op: op, Path: p, Err: os.ErrNotExist}
+	}
+	if !mem.hasAccess(node, access) {
+		return nil, &os.PathError{Op: op, Path: p, Err: os.ErrPermission}
+	}
+	return node, nil
+}
+
+// Retrieves the HopsFS metadata of a file or directory. No storage policy is set in the in-memory namespace
+func (mem *MemHdfsAccessor) GetMetadata(p string) (Metadata, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("stat", p)
+	if err != nil {
+		return Metadata{}, unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return Metadata{}, unwrapAndTranslateError(&os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist})
+	}
+	if !node.mode.IsRegular() {
+		return Metadata{}, nil
+	}
+	return Metadata{Replication: node.replication, BlockSize: memBlockSize}, nil
+}
+
+// Retrieves the summary of a subtree, with the quotas set by SetQuota
+func (mem *MemHdfsAccessor) GetContentSummary(p string) (ContentSummary, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("getContentSummary", p)
+	if err != nil {
+		return ContentSummary{}, unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return ContentSummary{}, unwrapAndTranslateError(&os.PathError{Op: "getContentSummary", Path: p, Err: os.ErrNotExist})
+	}
+	summary := ContentSummary{NameQuota: -1, SpaceQuota: -1}
+	if node.nameQuota > 0 {
+		summary.NameQuota = node.nameQuota
+	}
+	if node.spaceQuota > 0 {
+		summary.SpaceQuota = node.spaceQuota
+	}
+	node.summarize(&summary)
+	return summary, nil
+}
+
+// Sets the quotas of a directory, 0 clears a quota. The quotas are only reported, they are not
+// enforced. Not part of HdfsAccessor, quotas are set by the administrators of HopsFS
+func (mem *MemHdfsAccessor) SetQuota(p string, nameQuota int, spaceQuota int64) error {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("setQuota", p)
+	if err != nil {
+		return unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return unwrapAndTranslateError(&os.PathError{Op: "setQuota", Path: p, Err: os.ErrNotExist})
+	}
+	if !node.isDir() {
+		return syscall.ENOTDIR
+	}
+	node.nameQuota = nameQuota
+	node.spaceQuota = spaceQuota
+	return nil
+}
+
+// Allows snapshots of a directory to be taken. Not part of HdfsAccessor, snapshots are managed
+// by the administrators of HopsFS
+func (mem *MemHdfsAccessor) AllowSnapshots(p string) error {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("allowSnapshot", p)
+	if err != nil {
+		return unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return unwrapAndTranslateError(&os.PathError{Op: "allowSnapshot", Path: p, Err: os.ErrNotExist})
+	}
+	if !node.isDir() {
+		return syscall.ENOTDIR
+	}
+	if node.snapshots == nil {
+		node.snapshots = mem.newINode(snapshotDirName, node.mode.Perm()|os.ModeDir, node.group)
+	}
+	return nil
+}
+
+// Takes a snapshot of a snapshottable directory, which is found at <dir>/.snapshot/<name>.
+// Returns the path of the snapshot. Not part of HdfsAccessor, see AllowSnapshots
+func (mem *MemHdfsAccessor) CreateSnapshot(p string, name string) (string, error) {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("createSnapshot", p)
+	if err != nil {
+		return "", unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return "", unwrapAndTranslateError(&os.PathError{Op: "createSnapshot", Path: p, Err: os.ErrNotExist})
+	}
+	if node.snapshots == nil {
+		return "", syscall.EINVAL
+	}
+	if _, ok := node.snapshots.children[name]; ok {
+		return "", syscall.EEXIST
+	}
+	snapshot := node.copyTree()
+	snapshot.name = name
+	snapshot.mtime = mem.now()
+	node.snapshots.children[name] = snapshot
+	node.snapshots.mtime = snapshot.mtime
+	return path.Join(p, snapshotDirName, name), nil
+}
+
+// Changes the replication factor of a file. WRITE permission on the file is required
+func (mem *MemHdfsAccessor) SetReplication(p string, replication uint16) error {
+	mem.lock()
+	defer mem.unlock()
+
+	_, node, err := mem.resolve("setReplication", p)
+	if err != nil {
+		return unwrapAndTranslateError(err)
+	}
+	if node == nil {
+		return unwrapAndTranslateError(&os.PathError{Op: "setReplication", Path: p, Err: os.ErrNotExist})
+	}
+	if node.isDir() {
+		return syscall.EISDIR
+	}
+	if !mem.hasAccess(node, memWrite) {
+		return unwrapAndTranslateError(&os.PathError{Op: "setReplication", Path: p, Err: os.ErrPermission})
+	}
+	node.replication = replication
+	return nil
+}
+
+// Close current meta connection if needed. No-op for in-memory backend, the namespace is kept
+func (mem *MemHdfsAccessor) Close() error {
+	return nil
+}
+
+// Resolves an absolute path. Returns the parent directory (nil for the root directory)
+// and the inode, which is nil if the last path component does not exist.
+// EXECUTE permission is checked on every traversed directory
+func (mem *MemHdfsAccessor) resolve(op, p string) (*memINode, *memINode, error) {
+	if !path.IsAbs(p) {
+		return nil, nil, &os.PathError{Op: op, Path: p, Err: os.ErrInvalid}
+	}
+	p = path.Clean(p)
+	if p == "/" {
+		return nil, mem.root, nil
+	}
+
+	names := strings.Split(p[1:], "/")
+	dir := mem.root
+	for i, name := range names {
+		if !dir.isDir() {
+			return nil, nil, &os.PathError{Op: op, Path: p, Err: syscall.ENOTDIR}
+		}
+		if !mem.hasAccess(dir, memExecute) {
+			return nil, nil, &os.PathError{Op: op, Path: p, Err: os.ErrPermission}
+		}
+		child := dir.children[name]
+		if name == snapshotDirName && dir.snapshots != nil {
+			child = dir.snapshots
+		}
+		if i == len(names)-1 {
+			return dir, child, nil
+		}
+		if child == nil {
+			return nil, nil, &os.PathError{Op: op, Path: p, Err: os.ErrNotExist}
+		}
+		dir = child
+	}
+	return nil, nil, &os.PathError{Op: op, Path: p, Err: os.ErrInvalid}
+}
+
+// Checks permission bits of the owner, group or others class, whichever applies to the user
+func (mem *MemHdfsAccessor) hasAccess(node *memINode, access os.FileMode) bool {
+	if mem.isSuperUser() {
+		return true
+	}
+	perm := node.mode.Perm()
+	if node.owner == mem.UserName {
+		return (perm>>6)&access == access
+	}
+	if mem.isMemberOf(node.group) {
+		return (perm>>3)&access == access
+	}
+	return perm&access == access
+}
+
+func (mem *MemHdfsAccessor) isSuperUser() bool {
+	return mem.UserName == memSuperUser || mem.isMemberOf(memSuperGroup)
+}
+
+func (mem *MemHdfsAccessor) isMemberOf(group string) bool {
+	for _, g := range mem.groupsOf(mem.UserName) {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the groups of the user as resolved on this host. The primary group comes first
+func (mem *MemHdfsAccessor) groupsOf(userName string) []string {
+	if groups, ok := mem.userGroups[userName]; ok {
+		return groups
+	}
+
+	var groups []string
+	if u, err := user.Lookup(userName); err == nil {
+		gids, _ := u.GroupIds()
+		seen := make(map[string]bool)
+		for _, gid := range append([]string{u.Gid}, gids...) {
+			if g, err := user.LookupGroupId(gid); err == nil && !seen[g.Name] {
+				seen[g.Name] = true
+				groups = append(groups, g.Name)
+			}
+		}
+	}
+	mem.userGroups[userName] = groups
+	return groups
+}
+
+func (mem *MemHdfsAccessor) newINode(name string, mode os.FileMode, group string) *memINode {
+	now := mem.now()
+	node := &memINode{
+		id:    mem.nextInodeID,
+		name:  name,
+		mode:  mode,
+		owner: mem.UserName,
+		group: group,
+		mtime: now,
+		atime: now,
+	}
+	if mode.IsDir() {
+		node.children = make(map[string]*memINode)
+	} else if mode.IsRegular() {
+		node.replication = memReplication
+	}
+	mem.nextInodeID++
+	return node
+}
+
+func (mem *MemHdfsAccessor) attrsFromINode(node *memINode, name string) Attrs {
+	size := uint64(0)
+	if !node.isDir() {
+		size = uint64(len(node.data))
+	}
+	var target string
+	if node.mode&os.ModeSymlink != 0 {
+		target = string(node.data)
+	}
+	return Attrs{
+		Inode:         node.id,
+		Name:          name,
+		Mode:          node.mode,
+		Size:          size,
+		Uid:           ugcache.LookupUId(node.owner),
+		Gid:           ugcache.LookupGid(node.group),
+		DFSUserName:   node.owner,
+		DFSGroupName:  node.group,
+		Mtime:         HadoopTimestampToTime(node.mtime),
+		Atime:         HadoopTimestampToTime(node.atime),
+		Ctime:         HadoopTimestampToTime(node.mtime),
+		Expires:       mem.Clock.Now().Add(CacheAttrsTimeDuration),
+		Target:        target,
+		Snapshottable: node.snapshots != nil,
+	}
+}
+
+// Returns current time in milliseconds, as stored by HopsFS
+func (mem *MemHdfsAccessor) now() uint64 {
+	return uint64(mem.Clock.Now().UnixNano() / 1000000)
+}
+
+func (mem *MemHdfsAccessor) lock() {
+	mem.mutex.Lock()
+}
+
+func (mem *MemHdfsAccessor) unlock() {
+	mem.mutex.Unlock()
+}
+
+func (node *memINode) isDir() bool {
+	return node.children != nil
+}
+
+func (node *memINode) usedBytes() uint64 {
+	used := uint64(len(node.data))
+	for _, child := range node.children {
+		used += child.usedBytes()
+	}
+	return used
+}
+
+// Returns a deep copy of the subtree rooted at the node, as kept by a snapshot. The copies keep the
+// ids of the originals, the snapshots of the subdirectories are not copied
+func (node *memINode) copyTree() *memINode {
+	copied := *node
+	copied.data = append([]byte(nil), node.data...)
+	copied.snapshots = nil
+	if node.xattrs != nil {
+		copied.xattrs = make(map[string][]byte, len(node.xattrs))
+		for name, value := range node.xattrs {
+			copied.xattrs[name] = append([]byte(nil), value...)
+		}
+	}
+	if node.children != nil {
+		copied.children = make(map[string]*memINode, len(node.children))
+		for name, child := range node.children {
+			copied.children[name] = child.copyTree()
+		}
+	}
+	return &copied
+}
+
+// Adds the node and its subtree to the summary
+func (node *memINode) summarize(summary *ContentSummary) {
+	if !node.isDir() {
+		// links are counted as files, like in HopsFS
+		summary.FileCount++
+		if node.mode.IsRegular() {
+			summary.Length += int64(len(node.data))
+			summary.SpaceConsumed += int64(len(node.data)) * int64(node.replication)
+		}
+		return
+	}
+	summary.DirectoryCount++
+	for _, child := range node.children {
+		child.summarize(summary)
+	}
+}
+
+// Reads the content of a file in the in-memory namespace
+// Concurrency: not thread safe: at most on request at a time
+type memReader struct {
+	mem    *MemHdfsAccessor
+	node   *memINode
+	pos    int64
+	closed bool
+}
+
+var _ ReadSeekCloser = (*memReader)(nil) // ensure memReader implements ReadSeekCloser
+
+// Read a chunk of data
+func (r *memReader) Read(buffer []byte) (int, error) {
+	r.mem.lock()
+	defer r.mem.unlock()
+
+	if r.closed {
+		return 0, unwrapAndTranslateError(os.ErrClosed)
+	}
+	if r.pos >= int64(len(r.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(buffer, r.node.data[r.pos:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+// Seeks to a given position
+func (r *memReader) Seek(pos int64) error {
+	r.mem.lock()
+	defer r.mem.unlock()
+
+	if pos < 0 || pos > int64(len(r.node.data)) {
+		return syscall.EINVAL
+	}
+	r.pos = pos
+	return nil
+}
+
+// Returns current position
+func (r *memReader) Position() (int64, error) {
+	return r.pos, nil
+}
+
+// Closes the stream
+func (r *memReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+// Enumerates a directory of the in-memory namespace. Like getListing, each page continues
+// after the last returned name, so the listing sees the changes made meanwhile
+// Concurrency: not thread safe: at most on request at a time
+type memDirLister struct {
+	mem    *MemHdfsAccessor
+	node   *memINode
+	last   string
+	closed bool
+}
+
+var _ DirLister = (*memDirLister)(nil) // ensure memDirLister implements DirLister
+
+// Returns the next page of the listing
+func (l *memDirLister) Next(n int) ([]Attrs, error) {
+	l.mem.lock()
+	defer l.mem.unlock()
+
+	if l.closed {
+		return nil, unwrapAndTranslateError(os.ErrClosed)
+	}
+	names := make([]string, 0, len(l.node.children))
+	for name := range l.node.children {
+		if name > l.last {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, io.EOF
+	}
+	sort.Strings(names)
+	if n > 0 && len(names) > n {
+		names = names[:n]
+	}
+
+	allAttrs := make([]Attrs, len(names))
+	for i, name := range names {
+		allAttrs[i] = l.mem.attrsFromINode(l.node.children[name], name)
+	}
+	l.last = names[len(names)-1]
+	return allAttrs, nil
+}
+
+// Closes the listing
+func (l *memDirLister) Close() error {
+	l.closed = true
+	return nil
+}
+
+// Appends data to a file in the in-memory namespace
+// Concurrency: not thread safe: at most on request at a time
+type memWriter struct {
+	mem    *MemHdfsAccessor
+	node   *memINode
+	closed bool
+}
+
+var _ HdfsWriter = (*memWriter)(nil) // ensure memWriter implements HdfsWriter
+
+// Seeks to a given position
+func (w *memWriter) Seek(pos int64) error {
+	return syscall.ENOSYS
+}
+
+// Writes chunk of data. Written data is visible to the readers right away
+func (w *memWriter) Write(buffer []byte) (int, error) {
+	w.mem.lock()
+	defer w.mem.unlock()
+
+	if w.closed {
+		return 0, unwrapAndTranslateError(os.ErrClosed)
+	}
+	w.node.data = append(w.node.data, buffer...)
+	return len(buffer), nil
+}
+
+// Flushes all the data
+func (w *memWriter) Flush() error {
+	return nil
+}
+
+// Truncate the file at a given position
+func (w *memWriter) Truncate() error {
+	return syscall.ENOSYS
+}
+
+// Closes the stream
+func (w *memWriter) Close() error {
+	w.mem.lock()
+	defer w.mem.unlock()
+
+	if !w.closed {
+		w.closed = true
+		w.node.mtime = w.mem.now()
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/colinmarc/hdfs/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMemHdfsAccessor(t *testing.T) (*MemHdfsAccessor, *MockClock) {
+	mockClock := &MockClock{}
+	mem, err := NewMemHdfsAccessor(mockClock)
+	assert.Nil(t, err)
+	return mem, mockClock
+}
+
+func memWriteFile(t *testing.T, mem *MemHdfsAccessor, p string, data string) {
+	w, err := mem.CreateFile(p, 0644, true)
+	assert.Nil(t, err)
+	_, err = w.Write([]byte(data))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+}
+
+func memReadFile(t *testing.T, mem *MemHdfsAccessor, p string) string {
+	r, err := mem.OpenRead(p)
+	assert.Nil(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	return string(data)
+}
+
+func TestMemHdfsAccessorReadWrite(t *testing.T) {
+	mem, _ := newTestMemHdfsAccessor(t)
+
+	assert.Nil(t, mem.Mkdir("/dir", 0755))
+	memWriteFile(t, mem, "/dir/file", "hello world")
+
+	attrs, err := mem.Stat("/dir/file")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(11), attrs.Size)
+	assert.Equal(t, "file", attrs.Name)
+	assert.Equal(t, "hello world", memReadFile(t, mem, "/dir/file"))
+
+	r, err := mem.OpenRead("/dir/file")
+	assert.Nil(t, err)
+	assert.Nil(t, r.Seek(6))
+	buf := make([]byte, 5)
+	n, err := r.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(buf[:n]))
+	assert.Nil(t, r.Close())
+
+	entries, err := mem.ReadDir("/dir")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "file", entries[0].Name)
+
+	fsInfo, err := mem.StatFs()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(11), fsInfo.used)
+}
+
+func TestMemHdfsAccessorErrors(t *testing.T) {
+	mem, _ := newTestMemHdfsAccessor(t)
+
+	_, err := mem.Stat("/missing")
+	assert.Equal(t, syscall.ENOENT, err)
+	_, err = mem.OpenRead("/missing")
+	assert.Equal(t, syscall.ENOENT, err)
+	_, err = mem.CreateFile("/missing/file", 0644, false)
+	assert.Equal(t, syscall.ENOENT, err)
+
+	assert.Nil(t, mem.Mkdir("/dir", 0755))
+	assert.Equal(t, syscall.EEXIST, mem.Mkdir("/dir", 0755))
+	memWriteFile(t, mem, "/dir/file", "data")
+	_, err = mem.CreateFile("/dir/file", 0644, false)
+	assert.Equal(t, syscall.EEXIST, err)
+	assert.Equal(t, syscall.ENOTEMPTY, mem.Remove("/dir"))
+	_, err = mem.OpenRead("/dir")
+	assert.Equal(t, syscall.EISDIR, err)
+
+	assert.Nil(t, mem.Remove("/dir/file"))
+	assert.Nil(t, mem.Remove("/dir"))
+	assert.Equal(t, syscall.ENOENT, mem.Remove("/dir"))
+}
+
+func TestMemHdfsAccessorRename(t *testing.T) {
+	mem, _ := newTestMemHdfsAccessor(t)
+
+	memWriteFile(t, mem, "/src", "src")
+	memWriteFile(t, mem, "/dst", "dst")
+	srcAttrs, err := mem.Stat("/src")
+	assert.Nil(t, err)
+
+	// no replace
+	err = mem.Rename2("/src", "/dst", hdfs.RenameOptions(hdfs.RENAME_NOREPLACE))
+	assert.Equal(t, syscall.EEXIST, err)
+	assert.Equal(t, "dst", memReadFile(t, mem, "/dst"))
+
+	// overwrite, the file keeps its inode
+	assert.Nil(t, mem.Rename("/src", "/dst"))
+	_, err = mem.Stat("/src")
+	assert.Equal(t, syscall.ENOENT, err)
+	dstAttrs, err := mem.Stat("/dst")
+	assert.Nil(t, err)
+	assert.Equal(t, srcAttrs.Inode, dstAttrs.Inode)
+	assert.Equal(t, "src", memReadFile(t, mem, "/dst"))
+
+	// overwriting on create allocates a new inode
+	memWriteFile(t, mem, "/dst", "new")
+	newAttrs, err := mem.Stat("/dst")
+	assert.Nil(t, err)
+	assert.NotEqual(t, dstAttrs.Inode, newAttrs.Inode)
+
+	// directories
+	assert.Nil(t, mem.Mkdir("/a", 0755))
+	assert.Nil(t, mem.Mkdir("/a/b", 0755))
+	assert.Nil(t, mem.Mkdir("/c", 0755))
+	memWriteFile(t, mem, "/c/file", "data")
+	assert.Equal(t, syscall.EINVAL, mem.Rename("/a", "/a/b/a"))
+	assert.Equal(t, syscall.ENOTEMPTY, mem.Rename("/a", "/c"))
+	assert.Equal(t, syscall.ENOTDIR, mem.Rename("/a", "/c/file"))
+	assert.Nil(t, mem.Rename("/a", "/c/a"))
+	_, err = mem.Stat("/c/a/b")
+	assert.Nil(t, err)
+}
+
+func TestMemHdfsAccessorPermissions(t *testing.T) {
+	mem, _ := newTestMemHdfsAccessor(t)
+	if mem.isSuperUser() {
+		t.Skip("permissions are not checked for the super user")
+	}
+
+	assert.Nil(t, mem.Mkdir("/dir", 0755))
+	assert.Nil(t, mem.Chmod("/dir", 0500))
+	_, err := mem.CreateFile("/dir/file", 0644, false)
+	assert.Equal(t, syscall.EPERM, err)
+	assert.Equal(t, syscall.EPERM, mem.Mkdir("/dir/sub", 0755))
+
+	assert.Nil(t, mem.Chmod("/dir", 0755))
+	memWriteFile(t, mem, "/dir/file", "data")
+	assert.Nil(t, mem.Chmod("/dir/file", 0200))
+	_, err = mem.OpenRead("/dir/file")
+	assert.Equal(t, syscall.EPERM, err)
+
+	assert.Equal(t, syscall.EPERM, mem.Chown("/dir/file", "someoneelse", ""))
+	assert.Nil(t, mem.Chown("/dir/file", mem.UserName, ""))
+}
+
+func TestMemHdfsAccessorModificationTime(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	mockClock.NotifyTimeElapsed(time.Hour)
+
+	assert.Nil(t, mem.Mkdir("/dir", 0755))
+	before, err := mem.Stat("/dir")
+	assert.Nil(t, err)
+
+	mockClock.NotifyTimeElapsed(time.Minute)
+	memWriteFile(t, mem, "/dir/file", "data")
+	after, err := mem.Stat("/dir")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, after.Mtime.Sub(before.Mtime))
+
+	fileAttrs, err := mem.Stat("/dir/file")
+	assert.Nil(t, err)
+	assert.Equal(t, after.Mtime, fileAttrs.Mtime)
+}
+
+func TestMemHdfsAccessorErrorsAreNotRetried(t *testing.T) {
+	mem, _ := newTestMemHdfsAccessor(t)
+	assert.Nil(t, mem.Mkdir("/dir", 0755))
+	memWriteFile(t, mem, "/file", "a")
+
+	// the errors caused by the request itself are returned to the application right away
+	_, err := mem.ReadDir("/file")
+	assert.Equal(t, syscall.ENOTDIR, err)
+	assert.True(t, IsSuccessOrNonRetriableError(err))
+	_, err = mem.OpenRead("/dir")
+	assert.Equal(t, syscall.EISDIR, err)
+	assert.True(t, IsSuccessOrNonRetriableError(err))
+	assert.True(t, IsSuccessOrNonRetriableError(syscall.EPERM))
+
+	// the other errors may be caused by the connection, the operation is retried
+	assert.False(t, IsSuccessOrNonRetriableError(syscall.EIO))
+}
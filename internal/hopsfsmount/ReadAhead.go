@@ -0,0 +1,201 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"io"
+	"sync"
+	"syscall"
+
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// Sequential read state of a file handle. Once the handle reads sequentially, the next windows
+// of the file are prefetched in the background with a dedicated reader, up to ReadAheadBufferSize
+// bytes. The windows grow from ReadAheadMinWindow to ReadAheadMaxWindow while the access stays
+// sequential, and they are dropped on a random seek.
+// Concurrency: not thread safe, the caller holds the mutex
+type readAhead struct {
+	mutex    sync.Mutex
+	file     *FileINode
+	path     string         // absolute path of the file, the prefetcher must not touch the inode
+	reader   ReadSeekCloser // reader used by the prefetcher, opened on first use
+	nextOff  int64          // offset right after the last read. Read at this offset is sequential
+	window   int64          // size of the next window to prefetch
+	windows  []*readAheadWindow
+	buffered int64            // bytes held by the windows
+	last     *readAheadWindow // last scheduled window, the prefetcher reads one window at a time
+	cancel   chan struct{}    // closed when the windows are dropped, so that the ones in flight are skipped
+}
+
+// Range of the file fetched in the background
+type readAheadWindow struct {
+	off  int64
+	size int64
+	data []byte        // valid once done is closed
+	err  error         // io.EOF if the window ends at the end of the file
+	done chan struct{} // closed when the window is fetched
+}
+
+func newReadAhead(file *FileINode) *readAhead {
+	return &readAhead{file: file, path: file.AbsolutePath(), window: int64(ReadAheadMinWindow), cancel: make(chan struct{})}
+}
+
+// Reads from the prefetched windows if the read is sequential, otherwise drops the windows and
+// reads synchronously with readAt
+func (ra *readAhead) ReadAt(b []byte, off int64, readAt func([]byte, int64) (int, error)) (int, error) {
+	if off != ra.nextOff {
+		if len(ra.windows) > 0 {
+			logger.Debug("Random read, dropping read-ahead buffer", ra.file.logInfo(logger.Fields{Operation: Read, Offset: off, ReqOffset: ra.nextOff, Bytes: ra.buffered}))
+		}
+		ra.drop()
+		n, err := readAt(b, off)
+		ra.nextOff = off + int64(n)
+		return n, err
+	}
+
+	n, err := ra.readBuffered(b, off)
+	if err != nil && err != io.EOF {
+		// the failed window may be transient, the foreground reader retries it
+		ra.drop()
+		err = nil
+	}
+	if n < len(b) && err == nil {
+		m, e := readAt(b[n:], off+int64(n))
+		n += m
+		err = e
+	}
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	ra.nextOff = off + int64(n)
+	ra.schedule()
+	return n, err
+}
+
+// Copies the data of the windows that starts at off. Consumed windows are released
+func (ra *readAhead) readBuffered(b []byte, off int64) (int, error) {
+	n := 0
+	for n < len(b) && len(ra.windows) > 0 {
+		w := ra.windows[0]
+		cur := off + int64(n)
+		if cur < w.off {
+			break
+		}
+		<-w.done
+		end := w.off + int64(len(w.data))
+		if cur < end {
+			n += copy(b[n:], w.data[cur-w.off:])
+			cur = off + int64(n)
+		}
+		if cur < end {
+			break // the rest of the window is for the next read
+		}
+		if w.err != nil {
+			return n, w.err
+		}
+		ra.release()
+	}
+	return n, nil
+}
+
+// Prefetches the next windows until the buffer is full or the end of the file is reached
+func (ra *readAhead) schedule() {
+	for ra.buffered+ra.window <= int64(ReadAheadBufferSize) {
+		off := ra.nextOff
+		if len(ra.windows) > 0 {
+			last := ra.windows[len(ra.windows)-1]
+			if last.reachedEnd() {
+				return
+			}
+			off = last.off + last.size
+		}
+		if ra.reader == nil {
+			reader, err := ra.file.FileSystem.getDFSConnector().OpenRead(ra.path)
+			if err != nil {
+				logger.Warn("Failed to open file for read-ahead", ra.file.logInfo(logger.Fields{Operation: Read, Error: err}))
+				return
+			}
+			ra.reader = reader
+		}
+
+		w := &readAheadWindow{off: off, size: ra.window, data: make([]byte, ra.window), done: make(chan struct{})}
+		go fetchWindow(w, ra.reader, ra.last, ra.cancel)
+		ra.windows = append(ra.windows, w)
+		ra.buffered += ra.window
+		ra.last = w
+		logger.Trace("Scheduled read-ahead", ra.file.logInfo(logger.Fields{Operation: Read, Offset: off, Bytes: ra.window}))
+
+		if ra.window < int64(ReadAheadMaxWindow) {
+			ra.window *= 2
+			if ra.window > int64(ReadAheadMaxWindow) {
+				ra.window = int64(ReadAheadMaxWindow)
+			}
+		}
+	}
+}
+
+// Returns true if the window is fetched and there is nothing to prefetch after it
+func (w *readAheadWindow) reachedEnd() bool {
+	select {
+	case <-w.done:
+		return w.err != nil
+	default:
+		return false
+	}
+}
+
+// Fetches the window once the previous window is fetched, so that the reader is used by one window
+// at a time. Dropped windows are not fetched
+func fetchWindow(w *readAheadWindow, reader ReadSeekCloser, previous *readAheadWindow, cancel chan struct{}) {
+	defer close(w.done)
+	if previous != nil {
+		<-previous.done
+	}
+	select {
+	case <-cancel:
+		w.data, w.err = nil, syscall.ECANCELED
+		return
+	default:
+	}
+
+	if w.err = reader.Seek(w.off); w.err != nil {
+		w.data = nil
+		return
+	}
+	n, err := io.ReadFull(reader, w.data)
+	w.data = w.data[:n]
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	w.err = err
+}
+
+func (ra *readAhead) release() {
+	ra.buffered -= ra.windows[0].size
+	ra.windows = ra.windows[1:]
+}
+
+// Drops the prefetched windows. The windows in flight complete in the background
+func (ra *readAhead) drop() {
+	if len(ra.windows) > 0 {
+		close(ra.cancel)
+		ra.cancel = make(chan struct{})
+	}
+	ra.windows = nil
+	ra.buffered = 0
+	ra.window = int64(ReadAheadMinWindow)
+}
+
+// Waits for the window in flight and closes the reader of the prefetcher
+func (ra *readAhead) Close() {
+	ra.drop()
+	if ra.last != nil {
+		<-ra.last.done
+		ra.last = nil
+	}
+	if ra.reader != nil {
+		ra.reader.Close()
+		ra.reader = nil
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadAhead(t *testing.T) {
+	minWindow, maxWindow, bufferSize := ReadAheadMinWindow, ReadAheadMaxWindow, ReadAheadBufferSize
+	defer func() {
+		ReadAheadMinWindow, ReadAheadMaxWindow, ReadAheadBufferSize = minWindow, maxWindow, bufferSize
+	}()
+	ReadAheadMinWindow, ReadAheadMaxWindow, ReadAheadBufferSize = 4096, 16384, 65536
+
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileSize := int64(100000)
+	var readers []*MockReadSeekCloserWithPseudoRandomContent
+	hdfsAccessor.EXPECT().Stat("/big").Return(Attrs{Name: "big", Mode: 0644, Size: uint64(fileSize)}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().OpenRead("/big").DoAndReturn(func(path string) (ReadSeekCloser, error) {
+		reader := &MockReadSeekCloserWithPseudoRandomContent{FileSize: fileSize}
+		readers = append(readers, reader)
+		return reader, nil
+	}).AnyTimes()
+
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "big")
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+
+	read := func(off int64, size int) []byte {
+		resp := &fuse.ReadResponse{Data: make([]byte, size)}
+		assert.Nil(t, fileHandle.Read(nil, &fuse.ReadRequest{Offset: off, Size: size}, resp))
+		for i, b := range resp.Data {
+			if b != generateByteAtOffset(off+int64(i)) {
+				t.Fatalf("Unexpected byte at offset %d", off+int64(i))
+			}
+		}
+		return resp.Data
+	}
+
+	// sequential reads start prefetching with a dedicated reader
+	off := int64(0)
+	for i := 0; i < 20; i++ {
+		off += int64(len(read(off, 1000)))
+	}
+	assert.Equal(t, 2, len(readers))
+	ra := file.fileProxy.(*RemoteROFileProxy).readAheads[fileHandle.fhID]
+	assert.True(t, len(ra.windows) > 0)
+	assert.True(t, ra.buffered <= int64(ReadAheadBufferSize))
+	assert.Equal(t, int64(ReadAheadMaxWindow), ra.window)
+
+	// the whole file is read through the buffer
+	for off < fileSize {
+		off += int64(len(read(off, 1000)))
+	}
+	assert.Equal(t, fileSize, off)
+	assert.Equal(t, 0, len(read(off, 1000)))
+
+	// a random read drops the buffer
+	read(5000, 1000)
+	assert.Equal(t, 0, len(ra.windows))
+	assert.Equal(t, int64(ReadAheadMinWindow), ra.window)
+
+	assert.Nil(t, fileHandle.Release(nil, nil))
+	for _, reader := range readers {
+		assert.True(t, reader.IsClosed)
+	}
+}
@@ -5,17 +5,43 @@ package hopsfsmount
 import (
 	"io"
 	"syscall"
+	"time"
 
 	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
 )
 
+// Reads a file straight from DFS. Positional reads do not hold the file handles lock and are
+// served by a pool of readers, so that concurrent reads at different offsets run in parallel
 type RemoteROFileProxy struct {
-	hdfsReader ReadSeekCloser
+	readers    *HdfsReaderPool
 	file       *FileINode
+	readPos    int64                 // position of the sequential reader used by Read
+	readAheads map[uint64]*readAhead // sequential read state of the file handles, by handle id
+	blockCache *BlockCache           // nil if the blocks of the file are not cached
+	fileID     uint64                // id, size and modification time of the file version that is cached
+	fileSize   int64
+	fileMtime  time.Time
 }
 
 var _ FileProxy = (*RemoteROFileProxy)(nil)
 
+// Creates a proxy that reads the file with the given reader, and opens more readers on demand
+func newRemoteROFileProxy(reader ReadSeekCloser, file *FileINode) *RemoteROFileProxy {
+	absPath := file.AbsolutePath()
+	open := func() (ReadSeekCloser, error) {
+		return file.FileSystem.getDFSConnector().OpenRead(absPath)
+	}
+	p := &RemoteROFileProxy{readers: NewHdfsReaderPool(reader, MaxReadersPerFile, open), file: file}
+	if file.FileSystem.BlockCache != nil && file.Attrs.Inode != 0 {
+		p.blockCache = file.FileSystem.BlockCache
+		p.fileID = file.Attrs.Inode
+		p.fileSize = int64(file.Attrs.Size)
+		p.fileMtime = file.Attrs.Mtime
+		p.blockCache.Validate(p.fileID, file.Attrs.Mtime, file.Attrs.Size)
+	}
+	return p
+}
+
 func (p *RemoteROFileProxy) Truncate(size int64) (int64, error) {
 	p.file.lockFileHandles()
 	defer p.file.unlockFileHandles()
@@ -31,9 +57,49 @@ func (p *RemoteROFileProxy) WriteAt(b []byte, off int64) (n int, err error) {
 }
 
 func (p *RemoteROFileProxy) ReadAt(b []byte, off int64) (int, error) {
+	return p.readAt(b, off)
+}
+
+// Reads on behalf of a file handle. Sequential reads of the handle are served from the read-ahead buffer
+func (p *RemoteROFileProxy) ReadAtForHandle(fhID uint64, b []byte, off int64) (int, error) {
+	// the block cache fetches whole blocks anyway
+	if ReadAheadMaxWindow <= 0 || off < 0 || p.blockCache != nil {
+		return p.readAt(b, off)
+	}
+
 	p.file.lockFileHandles()
-	defer p.file.unlockFileHandles()
+	if p.readAheads == nil {
+		p.readAheads = make(map[uint64]*readAhead)
+	}
+	ra, ok := p.readAheads[fhID]
+	if !ok {
+		ra = newReadAhead(p.file)
+		p.readAheads[fhID] = ra
+	}
+	p.file.unlockFileHandles()
 
+	// concurrent reads of the same handle are not sequential
+	if !ra.mutex.TryLock() {
+		return p.readAt(b, off)
+	}
+	defer ra.mutex.Unlock()
+	return ra.ReadAt(b, off, p.readAt)
+}
+
+// Releases the read-ahead buffer of a closed file handle
+func (p *RemoteROFileProxy) ReleaseHandle(fhID uint64) {
+	p.file.lockFileHandles()
+	ra, ok := p.readAheads[fhID]
+	delete(p.readAheads, fhID)
+	p.file.unlockFileHandles()
+	if ok {
+		ra.mutex.Lock()
+		defer ra.mutex.Unlock()
+		ra.Close()
+	}
+}
+
+func (p *RemoteROFileProxy) readAt(b []byte, off int64) (int, error) {
 	logger.Debug("RemoteFileProxy ReadAt", p.file.logInfo(logger.Fields{Operation: Read, Offset: off}))
 
 	if off < 0 {
@@ -42,24 +108,12 @@ func (p *RemoteROFileProxy) ReadAt(b []byte, off int64) (int, error) {
 	}
 	maxBytesToRead := len(b)
 
-	if err := p.hdfsReader.Seek(off); err != nil {
-		return 0, err
-	}
-
-	var err error = nil
-	var n int = 0
-	for len(b) > 0 {
-		m, e := p.hdfsReader.Read(b)
-
-		if m > 0 {
-			n += m
-			b = b[m:]
-		}
-
-		if e != nil {
-			err = e
-			break
-		}
+	var n int
+	var err error
+	if p.blockCache != nil {
+		n, err = p.readCached(b, off)
+	} else {
+		n, err = p.readers.ReadAt(b, off)
 	}
 
 	if err != nil && err == io.EOF && n > 0 {
@@ -73,25 +127,59 @@ func (p *RemoteROFileProxy) ReadAt(b []byte, off int64) (int, error) {
 	return n, err
 }
 
+// Reads the blocks from the block cache. Missing blocks are fetched entirely and added to the cache
+func (p *RemoteROFileProxy) readCached(b []byte, off int64) (int, error) {
+	n := 0
+	for n < len(b) && off+int64(n) < p.fileSize {
+		cur := off + int64(n)
+		index := cur / blockCacheBlockSize
+		blockStart := index * blockCacheBlockSize
+		if m, ok := p.blockCache.ReadAt(p.fileID, index, b[n:], cur-blockStart); ok && m > 0 {
+			n += m
+			continue
+		}
+
+		blockSize := p.fileSize - blockStart
+		if blockSize > blockCacheBlockSize {
+			blockSize = blockCacheBlockSize
+		}
+		block := make([]byte, blockSize)
+		m, err := p.readers.ReadAt(block, blockStart)
+		if err != nil && !(err == io.EOF && int64(m) == blockSize) {
+			if err == io.EOF {
+				// the file is shorter than when it was opened, it is not cached
+				logger.Warn("File has shrunk since it was opened", p.file.logInfo(logger.Fields{Operation: Read, Offset: blockStart, FileSize: p.fileSize}))
+				if cur-blockStart < int64(m) {
+					n += copy(b[n:], block[cur-blockStart:m])
+				}
+			}
+			return n, err
+		}
+		p.blockCache.Put(p.fileID, p.fileMtime, uint64(p.fileSize), index, block)
+		n += copy(b[n:], block[cur-blockStart:])
+	}
+
+	// the file is read as it was when it was opened
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
 func (p *RemoteROFileProxy) SeekToStart() (err error) {
 	p.file.lockFileHandles()
 	defer p.file.unlockFileHandles()
 
-	err = p.hdfsReader.Seek(0)
-	if err != nil {
-		logger.Debug("RemoteFileProxy SeekToStart failed", p.file.logInfo(logger.Fields{Operation: SeekToStart, Offset: 0, Error: err}))
-		return err
-	} else {
-		logger.Debug("RemoteFileProxy SeekToStart", p.file.logInfo(logger.Fields{Operation: SeekToStart, Offset: 0}))
-		return nil
-	}
-
+	p.readPos = 0
+	logger.Debug("RemoteFileProxy SeekToStart", p.file.logInfo(logger.Fields{Operation: SeekToStart, Offset: 0}))
+	return nil
 }
 
 func (p *RemoteROFileProxy) Read(b []byte) (n int, err error) {
 	p.file.lockFileHandles()
 	defer p.file.unlockFileHandles()
-	n, err = p.hdfsReader.Read(b)
+	n, err = p.readAt(b, p.readPos)
+	p.readPos += int64(n)
 
 	if err != nil {
 		logger.Debug("RemoteFileProxy Read", p.file.logInfo(logger.Fields{Operation: Read, MaxBytesToRead: len(b), Error: err}))
@@ -104,7 +192,13 @@ func (p *RemoteROFileProxy) Read(b []byte) (n int, err error) {
 
 func (p *RemoteROFileProxy) Close() error {
 	//NOTE: Locking is done in File.go
-	err := p.hdfsReader.Close()
+	for fhID, ra := range p.readAheads {
+		ra.mutex.Lock()
+		ra.Close()
+		ra.mutex.Unlock()
+		delete(p.readAheads, fhID)
+	}
+	err := p.readers.Close()
 	if err != nil {
 		logger.Debug("RemoteFileProxy Close failed", p.file.logInfo(logger.Fields{Operation: Close, Error: err}))
 		return err
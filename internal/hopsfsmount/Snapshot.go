@@ -0,0 +1,90 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"os"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// The snapshots of a snapshottable directory are exposed in its virtual .snapshot directory, as in
+// HDFS: <dir>/.snapshot/<name> is the directory as it was when the snapshot was taken. HopsFS does not
+// list .snapshot in the directory, the mount adds it. The entries of the snapshots are read-only.
+// They keep the file ids of the live files, so they are given local inode numbers instead, both in
+// the listings and in their attributes, and their blocks are not cached, see addOrUpdateChildInodeAttrs
+
+// Name of the virtual directory of the snapshots
+const snapshotDirName = ".snapshot"
+
+// Returns true if snapshots of the directory can be taken. The attributes of the root are not read
+// from HopsFS, the source directory is looked up. This is only done when .snapshot is looked up
+func (dir *DirINode) isSnapshottable() bool {
+	if dir.Snapshot {
+		return false
+	}
+	if dir.Parent != nil {
+		return dir.Attrs.Snapshottable
+	}
+	attrs, err := dir.FileSystem.getDFSConnector().Stat(dir.AbsolutePath())
+	if err != nil {
+		logger.Debug("Stat failed on backend", logger.Fields{Operation: Lookup, Path: dir.AbsolutePath(), Error: err})
+		return false
+	}
+	return attrs.Snapshottable
+}
+
+// Returns true for the .snapshot directory, whose attributes are derived from its parent
+func (dir *DirINode) isSnapshotDir() bool {
+	return dir.Snapshot && dir.Parent != nil && !dir.Parent.Snapshot
+}
+
+// Returns the inode of the .snapshot directory. The caller must check that the directory is snapshottable
+func (dir *DirINode) lookupSnapshotDir(operation string) fs.Node {
+	if node := dir.getChildInode(operation, snapshotDirName); node != nil {
+		return node
+	}
+	attrs := dir.Attrs
+	attrs.Inode = 0
+	attrs.Name = snapshotDirName
+	attrs.Mode = os.ModeDir | (dir.Attrs.Mode.Perm() &^ 0222)
+	attrs.Snapshottable = false
+	node := dir.addOrUpdateChildInodeAttrs(operation, snapshotDirName, attrs).(*DirINode)
+	node.Snapshot = true
+	return node
+}
+
+// Appends the .snapshot directory to the listing of a snapshottable directory, under the inode number
+// given on lookup. It is not listed at the root of the mount, whose attributes are not known, but it
+// can be looked up there
+func (dir *DirINode) appendSnapshotDirent(entries []fuse.Dirent) []fuse.Dirent {
+	if dir.Snapshot || !dir.Attrs.Snapshottable {
+		return entries
+	}
+	node := dir.lookupSnapshotDir(ReadDir)
+	return append(entries, fuse.Dirent{
+		Inode: dir.FileSystem.inodeNumber(dir, snapshotDirName, node),
+		Name:  snapshotDirName,
+		Type:  fuse.DT_Dir})
+}
+
+// Returns EROFS if the entry of the directory is in a snapshot, or is the .snapshot directory
+func (dir *DirINode) checkNotInSnapshot(name string) error {
+	if dir.Snapshot || (name == snapshotDirName && dir.isSnapshottable()) {
+		return syscall.EROFS
+	}
+	return nil
+}
+
+// Returns true if the node is the .snapshot directory or one of its entries
+func isInSnapshot(node fs.Node) bool {
+	if fnode, ok := node.(*FileINode); ok {
+		return fnode.Snapshot
+	} else if dnode, ok := node.(*DirINode); ok {
+		return dnode.Snapshot
+	}
+	return false
+}
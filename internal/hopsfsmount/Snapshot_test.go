@@ -0,0 +1,127 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotDir(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	assert.Nil(t, mem.MkdirAll("/data/sub", os.ModeDir|0755))
+	memWriteFile(t, mem, "/data/a", "old")
+	memWriteFile(t, mem, "/data/sub/b", "b")
+	assert.Nil(t, mem.AllowSnapshots("/data"))
+	snapshotPath, err := mem.CreateSnapshot("/data", "s1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/data/.snapshot/s1", snapshotPath)
+	memWriteFile(t, mem, "/data/a", "new")
+	assert.Nil(t, mem.Remove("/data/sub/b"))
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "data")
+	assert.Nil(t, err)
+	data := node.(*DirINode)
+
+	// .snapshot is only listed in snapshottable directories
+	entries, err := readDir(data)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, snapshotDirName, entries[2].Name)
+	assert.Equal(t, fuse.DT_Dir, entries[2].Type)
+	snapshotDirent := entries[2]
+	entries, err = readDir(root.(*DirINode))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	node, err = data.Lookup(nil, "sub")
+	assert.Nil(t, err)
+	_, err = node.(*DirINode).Lookup(nil, snapshotDirName)
+	assert.Equal(t, syscall.ENOENT, err)
+
+	// the snapshots are listed in .snapshot
+	node, err = data.Lookup(nil, snapshotDirName)
+	assert.Nil(t, err)
+	snapshots := node.(*DirINode)
+	assert.True(t, snapshots.Snapshot)
+	assert.Equal(t, "/data/.snapshot", snapshots.AbsolutePath())
+	a := &fuse.Attr{}
+	assert.Nil(t, snapshots.Attr(nil, a))
+	assert.Equal(t, os.ModeDir|0555, a.Mode)
+	assert.Equal(t, snapshotDirent.Inode, a.Inode)
+	assert.True(t, a.Inode >= localINodeIDBase)
+	entries, err = readDir(snapshots)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "s1", entries[0].Name)
+	s1Dirent := entries[0]
+
+	// the old versions are read from the snapshots, under local inode numbers
+	node, err = snapshots.Lookup(nil, "s1")
+	assert.Nil(t, err)
+	s1 := node.(*DirINode)
+	assert.Nil(t, s1.Attr(nil, a))
+	assert.Equal(t, s1Dirent.Inode, a.Inode)
+	assert.True(t, a.Inode >= localINodeIDBase)
+	entries, err = readDir(s1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "a", entries[0].Name)
+	node, err = s1.Lookup(nil, "a")
+	assert.Nil(t, err)
+	file := node.(*FileINode)
+	assert.True(t, file.Snapshot)
+	assert.Equal(t, uint64(0), file.Attrs.Inode)
+	assert.Nil(t, file.Attr(nil, a))
+	assert.Equal(t, entries[0].Inode, a.Inode)
+	assert.True(t, a.Inode >= localINodeIDBase)
+	live, err := data.Lookup(nil, "a")
+	assert.Nil(t, err)
+	assert.NotEqual(t, live, file)
+	assert.Equal(t, data, live.(*FileINode).Parent)
+	liveAttr := &fuse.Attr{}
+	assert.Nil(t, live.(*FileINode).Attr(nil, liveAttr))
+	assert.Equal(t, live.(*FileINode).Attrs.Inode, liveAttr.Inode)
+	assert.NotEqual(t, liveAttr.Inode, a.Inode)
+	h, err := file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	assert.Nil(t, err)
+	resp := &fuse.ReadResponse{Data: make([]byte, 10)}
+	assert.Nil(t, h.(*FileHandle).Read(nil, &fuse.ReadRequest{Size: 10}, resp))
+	assert.Equal(t, "old", string(resp.Data))
+	assert.Nil(t, h.(*FileHandle).Release(nil, &fuse.ReleaseRequest{}))
+	node, err = s1.Lookup(nil, "sub")
+	assert.Nil(t, err)
+	_, err = node.(*DirINode).Lookup(nil, "b")
+	assert.Nil(t, err)
+
+	// the snapshots are read-only
+	_, err = file.Open(nil, &fuse.OpenRequest{Flags: fuse.OpenReadWrite}, &fuse.OpenResponse{})
+	assert.Equal(t, syscall.EROFS, err)
+	assert.Equal(t, syscall.EROFS, file.Setattr(nil, &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: 0600}, &fuse.SetattrResponse{}))
+	assert.Equal(t, syscall.EROFS, file.Setxattr(nil, &fuse.SetxattrRequest{Name: "user.tag", Xattr: []byte("x")}))
+	assert.Equal(t, syscall.EROFS, s1.Remove(nil, &fuse.RemoveRequest{Name: "a"}))
+	_, err = s1.Mkdir(nil, &fuse.MkdirRequest{Name: "new", Mode: os.ModeDir | 0755})
+	assert.Equal(t, syscall.EROFS, err)
+	_, _, err = s1.Create(nil, &fuse.CreateRequest{Name: "new", Mode: 0644}, &fuse.CreateResponse{})
+	assert.Equal(t, syscall.EROFS, err)
+	assert.Equal(t, syscall.EROFS, snapshots.Remove(nil, &fuse.RemoveRequest{Name: "s1", Dir: true}))
+	assert.Equal(t, syscall.EROFS, data.Remove(nil, &fuse.RemoveRequest{Name: snapshotDirName, Dir: true}))
+	assert.Equal(t, syscall.EROFS, s1.Rename(nil, &fuse.RenameRequest{OldName: "a", NewName: "a"}, data))
+	assert.Equal(t, syscall.EROFS, data.Rename(nil, &fuse.RenameRequest{OldName: "a", NewName: "a"}, s1))
+	assert.Equal(t, "old", memReadFile(t, mem, "/data/.snapshot/s1/a"))
+	assert.Equal(t, "new", memReadFile(t, mem, "/data/a"))
+
+	// .snapshot can be looked up at the root of a mount of a snapshottable directory
+	fs, _ = NewFileSystem([]HdfsAccessor{mem}, "/data", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ = fs.Root()
+	node, err = root.(*DirINode).Lookup(nil, snapshotDirName)
+	assert.Nil(t, err)
+	entries, err = readDir(node.(*DirINode))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "s1", entries[0].Name)
+}
@@ -0,0 +1,212 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package hopsfsmount
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// Writes the data of a newly created file straight to HopsFS, without a staging copy.
+// Works as long as the file is written sequentially. On the first operation that can not
+// be served from the stream (backward seek, rewrite, read, truncate) the stream is closed,
+// and the file continues with a LocalRWFileProxy that fetches the data written so far on demand
+type StreamingWriteFileProxy struct {
+	hdfsWriter HdfsWriter // nil once the stream is closed
+	file       *FileINode
+	offset     int64             // number of bytes written to the stream
+	staging    *LocalRWFileProxy // set once the proxy has fallen back to staging
+}
+
+var _ FileProxy = (*StreamingWriteFileProxy)(nil)
+
+func (p *StreamingWriteFileProxy) Truncate(size int64) (int64, error) {
+	p.file.lockFileHandles()
+	if p.staging == nil && size == p.offset {
+		p.file.unlockFileHandles()
+		return 0, nil
+	}
+	staging, err := p.fallBackToStaging(Truncate)
+	p.file.unlockFileHandles()
+	if err != nil {
+		return 0, err
+	}
+	return staging.Truncate(size)
+}
+
+func (p *StreamingWriteFileProxy) WriteAt(b []byte, off int64) (int, error) {
+	p.file.lockFileHandles()
+	if p.staging == nil && p.hdfsWriter != nil && off >= p.offset {
+		defer p.file.unlockFileHandles()
+		return p.writeAtEnd(b, off)
+	}
+	staging, err := p.fallBackToStaging(Write)
+	p.file.unlockFileHandles()
+	if err != nil {
+		return 0, err
+	}
+	return staging.WriteAt(b, off)
+}
+
+func (p *StreamingWriteFileProxy) ReadAt(b []byte, off int64) (int, error) {
+	p.file.lockFileHandles()
+	if p.staging == nil && off >= p.offset {
+		p.file.unlockFileHandles()
+		return 0, io.EOF
+	}
+	staging, err := p.fallBackToStaging(Read)
+	p.file.unlockFileHandles()
+	if err != nil {
+		return 0, err
+	}
+	return staging.ReadAt(b, off)
+}
+
+func (p *StreamingWriteFileProxy) SeekToStart() error {
+	p.file.lockFileHandles()
+	staging, err := p.fallBackToStaging(SeekToStart)
+	p.file.unlockFileHandles()
+	if err != nil {
+		return err
+	}
+	return staging.SeekToStart()
+}
+
+func (p *StreamingWriteFileProxy) Read(b []byte) (int, error) {
+	p.file.lockFileHandles()
+	staging, err := p.fallBackToStaging(Read)
+	p.file.unlockFileHandles()
+	if err != nil {
+		return 0, err
+	}
+	return staging.Read(b)
+}
+
+func (p *StreamingWriteFileProxy) Close() error {
+	//NOTE: Locking is done in File.go
+	if p.staging != nil {
+		return p.staging.Close()
+	}
+	return p.closeStream()
+}
+
+// Persists the data written so far on the datanodes. The stream remains open
+func (p *StreamingWriteFileProxy) Sync() error {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	if p.staging != nil {
+		return p.staging.localFile.Sync()
+	}
+	if p.hdfsWriter == nil {
+		return nil
+	}
+	return p.hdfsWriter.Flush()
+}
+
+// Makes the data written so far durable. Fsync keeps the stream open, Flush closes it,
+// so that the file is complete in HopsFS when the application closes its descriptor.
+// A write after Flush falls back to staging
+func (p *StreamingWriteFileProxy) Commit(operation string) error {
+	if operation == Fsync {
+		return p.Sync()
+	}
+
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	if err := p.closeStream(); err != nil {
+		logger.Error("Failed to close file in DFS", p.file.logInfo(logger.Fields{Operation: operation, Error: err}))
+		return err
+	}
+	logger.Info("Uploaded to DFS", p.file.logInfo(logger.Fields{Operation: operation, Bytes: p.offset}))
+	return nil
+}
+
+// Size of the file as written so far
+func (p *StreamingWriteFileProxy) Size() int64 {
+	p.file.lockFileHandles()
+	defer p.file.unlockFileHandles()
+	return p.offset
+}
+
+// Zeros written to the stream to fill the holes, a chunk at a time
+var streamingZeros = make([]byte, 64*1024)
+
+func (p *StreamingWriteFileProxy) writeAtEnd(b []byte, off int64) (int, error) {
+	// a forward seek leaves a hole, which is filled with zeros. The offset counts the zeros written
+	// so far, so that a failed write leaves the proxy consistent with the stream
+	for p.offset < off {
+		chunk := streamingZeros
+		if off-p.offset < int64(len(chunk)) {
+			chunk = chunk[:off-p.offset]
+		}
+		nw, err := p.hdfsWriter.Write(chunk)
+		p.offset += int64(nw)
+		if err != nil {
+			logger.Error("Failed to write to DFS", p.file.logInfo(logger.Fields{Operation: Write, Offset: p.offset, Holes: off - p.offset, Error: err}))
+			return 0, err
+		}
+	}
+
+	nw, err := p.hdfsWriter.Write(b)
+	p.offset += int64(nw)
+	if err != nil {
+		logger.Error("Failed to write to DFS", p.file.logInfo(logger.Fields{Operation: Write, Offset: off, Error: err}))
+	}
+	return nw, err
+}
+
+func (p *StreamingWriteFileProxy) closeStream() error {
+	if p.hdfsWriter == nil {
+		return nil
+	}
+	err := p.hdfsWriter.Close()
+	p.hdfsWriter = nil
+	p.file.Attrs.Size = uint64(p.offset)
+	return err
+}
+
+// Closes the stream and switches to a staging file backed by the data written so far.
+// Caller must hold the file handles lock
+func (p *StreamingWriteFileProxy) fallBackToStaging(operation string) (*LocalRWFileProxy, error) {
+	if p.staging != nil {
+		return p.staging, nil
+	}
+
+	logger.Info("Can not continue streaming, falling back to staging", p.file.logInfo(logger.Fields{Operation: operation, Bytes: p.offset}))
+	if err := p.closeStream(); err != nil {
+		logger.Error("Failed to close file in DFS", p.file.logInfo(logger.Fields{Operation: operation, Error: err}))
+		return nil, err
+	}
+
+	if err := p.file.checkDiskSpace(); err != nil {
+		return nil, err
+	}
+
+	stagingFile, err := ioutil.TempFile(StagingDir, "stage")
+	if err != nil {
+		logger.Error("Failed to create staging file", p.file.logInfo(logger.Fields{Operation: operation, Error: err}))
+		return nil, err
+	}
+	os.Remove(stagingFile.Name())
+	logger.Info("Created staging file", p.file.logInfo(logger.Fields{Operation: operation, TmpFile: stagingFile.Name()}))
+
+	// the content in DFS is complete, otherwise the stream must have failed. It is fetched on demand
+	if err := stagingFile.Truncate(p.offset); err != nil {
+		logger.Error("Failed to resize staging file", p.file.logInfo(logger.Fields{Operation: operation, Error: err}))
+		stagingFile.Close()
+		return nil, err
+	}
+
+	// the attributes of the closed file validate the next append, it is rewritten if they are not known
+	remote, err := p.file.FileSystem.getDFSConnector().Stat(p.file.AbsolutePath())
+	if err != nil || int64(remote.Size) != p.offset {
+		remote = Attrs{Size: uint64(p.offset)}
+	}
+	p.staging = newLocalRWFileProxy(stagingFile, p.file, remote)
+	p.file.fileProxy = p.staging
+	return p.staging, nil
+}
@@ -0,0 +1,131 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+
+package hopsfsmount
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamingWriteFile(t *testing.T) {
+	defer func(old bool) { StreamingWrites = old }(StreamingWrites)
+	StreamingWrites = true
+
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileName := "/testStreamingWriteFile"
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().CreateFile(fileName, os.FileMode(0757), false).Return(hdfswriter, nil)
+	remoteSize := uint64(0)
+	hdfsAccessor.EXPECT().Stat(fileName).DoAndReturn(func(string) (Attrs, error) {
+		return Attrs{Name: fileName, Size: remoteSize}, nil
+	}).AnyTimes()
+	hdfsAccessor.EXPECT().Chown(fileName, gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	root, _ := fs.Root()
+	_, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: fileName,
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0757)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+	_, ok := fileHandle.File.fileProxy.(*StreamingWriteFileProxy)
+	assert.True(t, ok)
+
+	// sequential writes go straight to the stream
+	gomock.InOrder(
+		hdfswriter.EXPECT().Write([]byte("hello ")).Return(6, nil),
+		hdfswriter.EXPECT().Write([]byte("world")).Return(5, nil),
+		hdfswriter.EXPECT().Close().Return(nil),
+	)
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("hello "), Offset: 0}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("world"), Offset: 6}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+
+	attr := fuse.Attr{}
+	assert.Nil(t, fileHandle.File.Attr(nil, &attr))
+	assert.Equal(t, uint64(11), attr.Size)
+
+	// flush completes the file in DFS without uploading anything
+	assert.Nil(t, fileHandle.Flush(nil, nil))
+	remoteSize = 11
+
+	// rewriting the beginning of the file falls back to staging
+	content := bytes.NewReader([]byte("hello world"))
+	reader := NewMockReadSeekCloser(mockCtrl)
+	reader.EXPECT().Seek(int64(0)).DoAndReturn(func(pos int64) error {
+		_, err := content.Seek(pos, io.SeekStart)
+		return err
+	})
+	reader.EXPECT().Read(gomock.Any()).DoAndReturn(content.Read).AnyTimes()
+	reader.EXPECT().Close().Return(nil)
+	hdfsAccessor.EXPECT().OpenRead(fileName).Return(reader, nil)
+
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("J"), Offset: 0}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	_, ok = fileHandle.File.fileProxy.(*LocalRWFileProxy)
+	assert.True(t, ok)
+
+	buffer := make([]byte, 64)
+	readResp := &fuse.ReadResponse{Data: buffer}
+	assert.Nil(t, fileHandle.Read(nil, &fuse.ReadRequest{Offset: 0, Size: len(buffer)}, readResp))
+	assert.Equal(t, "Jello world", string(readResp.Data))
+
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
+
+func TestStreamingWriteFileWithHole(t *testing.T) {
+	defer func(old bool) { StreamingWrites = old }(StreamingWrites)
+	StreamingWrites = true
+
+	mockCtrl := gomock.NewController(t)
+	mockClock := &MockClock{}
+	hdfsAccessor := NewMockHdfsAccessor(mockCtrl)
+	fileName := "/testStreamingWriteFileWithHole"
+	fs, _ := NewFileSystem([]HdfsAccessor{hdfsAccessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+
+	hdfswriter := NewMockHdfsWriter(mockCtrl)
+	hdfsAccessor.EXPECT().CreateFile(fileName, os.FileMode(0757), false).Return(hdfswriter, nil)
+	hdfsAccessor.EXPECT().Stat(fileName).Return(Attrs{Name: fileName}, nil).AnyTimes()
+	hdfsAccessor.EXPECT().Chown(fileName, gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+
+	root, _ := fs.Root()
+	_, h, err := root.(*DirINode).Create(nil, &fuse.CreateRequest{Name: fileName,
+		Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: os.FileMode(0757)}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	fileHandle := h.(*FileHandle)
+
+	// the hole is filled with chunks of zeros, not with a buffer of its size
+	chunk := int64(len(streamingZeros))
+	gomock.InOrder(
+		hdfswriter.EXPECT().Write(streamingZeros).Return(len(streamingZeros), nil),
+		hdfswriter.EXPECT().Write(make([]byte, 10)).Return(10, nil),
+		hdfswriter.EXPECT().Write([]byte("end")).Return(3, nil),
+	)
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("end"), Offset: chunk + 10}, &fuse.WriteResponse{})
+	assert.Nil(t, err)
+	assert.Equal(t, chunk+13, fileHandle.File.fileProxy.(*StreamingWriteFileProxy).Size())
+
+	// a failed hole writes nothing of the data, the size accounts for the zeros written
+	gomock.InOrder(
+		hdfswriter.EXPECT().Write(streamingZeros).Return(100, syscall.EIO),
+		hdfswriter.EXPECT().Close().Return(nil),
+	)
+	resp := &fuse.WriteResponse{}
+	err = fileHandle.Write(nil, &fuse.WriteRequest{Data: []byte("more"), Offset: 2*chunk + 13}, resp)
+	assert.Equal(t, syscall.EIO, err)
+	assert.Equal(t, 0, resp.Size)
+	assert.Equal(t, chunk+113, fileHandle.File.fileProxy.(*StreamingWriteFileProxy).Size())
+
+	assert.Nil(t, fileHandle.Release(nil, nil))
+}
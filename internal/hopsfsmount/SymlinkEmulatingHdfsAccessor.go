@@ -0,0 +1,123 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// Sticky bit as stored in HopsFS permissions. HopsFS keeps it on files but ignores it
+const hdfsStickyBit os.FileMode = 01000
+
+// Stores symbolic links as marker files, as the HopsFS client can not create symlinks. Only used
+// with -emulateSymlinks, as a stopgap until the client implements the createSymlink call.
+// A link is a regular file which holds the target and has the sticky bit set. Other HopsFS
+// clients see the marker files as small regular files. Links created by HopsFS are still supported
+// Concurrency: thread safe: handles unlimited number of concurrent requests
+type SymlinkEmulatingHdfsAccessor struct {
+	HdfsAccessor // accessor of the marker files, all the other calls are passed to it
+}
+
+var _ HdfsAccessor = (*SymlinkEmulatingHdfsAccessor)(nil) // ensure SymlinkEmulatingHdfsAccessor implements HdfsAccessor
+
+// Creates an instance of SymlinkEmulatingHdfsAccessor
+func NewSymlinkEmulatingHdfsAccessor(impl HdfsAccessor) *SymlinkEmulatingHdfsAccessor {
+	return &SymlinkEmulatingHdfsAccessor{HdfsAccessor: impl}
+}
+
+// Enumerates HDFS directory, marker files are returned as links
+func (sea *SymlinkEmulatingHdfsAccessor) ReadDir(path string) ([]Attrs, error) {
+	allAttrs, err := sea.HdfsAccessor.ReadDir(path)
+	for i := range allAttrs {
+		allAttrs[i] = attrsOfSymlinkMarker(allAttrs[i])
+	}
+	return allAttrs, err
+}
+
+// Opens HDFS directory for enumerating it page by page, marker files are returned as links
+func (sea *SymlinkEmulatingHdfsAccessor) OpenDir(path string) (DirLister, error) {
+	lister, err := sea.HdfsAccessor.OpenDir(path)
+	if err != nil {
+		return nil, err
+	}
+	return &symlinkEmulatingDirLister{DirLister: lister}, nil
+}
+
+// Retrieves file/directory attributes, marker files are returned as links
+func (sea *SymlinkEmulatingHdfsAccessor) Stat(path string) (Attrs, error) {
+	attrs, err := sea.HdfsAccessor.Stat(path)
+	if err != nil {
+		return Attrs{}, err
+	}
+	return attrsOfSymlinkMarker(attrs), nil
+}
+
+// Creates a marker file holding the target
+func (sea *SymlinkEmulatingHdfsAccessor) CreateSymlink(target string, link string) error {
+	writer, err := sea.HdfsAccessor.CreateFile(link, os.ModePerm|hdfsStickyBit, false)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write([]byte(target)); err != nil {
+		writer.Close()
+		sea.HdfsAccessor.Remove(link)
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		sea.HdfsAccessor.Remove(link)
+		return err
+	}
+	return nil
+}
+
+// Returns the target of a link, read from its marker file
+func (sea *SymlinkEmulatingHdfsAccessor) ReadLink(path string) (string, error) {
+	attrs, err := sea.HdfsAccessor.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !isSymlinkMarker(attrs) {
+		return sea.HdfsAccessor.ReadLink(path)
+	}
+	reader, err := sea.HdfsAccessor.OpenRead(path)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	target, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	if len(target) == 0 {
+		return "", syscall.EIO
+	}
+	return string(target), nil
+}
+
+type symlinkEmulatingDirLister struct {
+	DirLister
+}
+
+// Returns the next page of the listing, marker files are returned as links
+func (l *symlinkEmulatingDirLister) Next(n int) ([]Attrs, error) {
+	allAttrs, err := l.DirLister.Next(n)
+	for i := range allAttrs {
+		allAttrs[i] = attrsOfSymlinkMarker(allAttrs[i])
+	}
+	return allAttrs, err
+}
+
+func isSymlinkMarker(attrs Attrs) bool {
+	return attrs.Mode.IsRegular() && attrs.Mode&hdfsStickyBit != 0
+}
+
+// Returns the attributes of the link stored in the marker file, the attributes of other files as they are.
+// The size of the marker file is the length of the target, like the size of a link
+func attrsOfSymlinkMarker(attrs Attrs) Attrs {
+	if isSymlinkMarker(attrs) {
+		attrs.Mode = os.ModeSymlink | os.ModePerm
+	}
+	return attrs
+}
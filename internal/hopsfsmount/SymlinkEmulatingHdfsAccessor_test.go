@@ -0,0 +1,66 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSymlinkEmulation(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "a")
+	fs, _ := NewFileSystem([]HdfsAccessor{NewSymlinkEmulatingHdfsAccessor(mem)}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	dir := node.(*DirINode)
+
+	// the link is stored as a marker file holding the target
+	link, err := dir.Symlink(nil, &fuse.SymlinkRequest{NewName: "l", Target: "../x/y"})
+	assert.Nil(t, err)
+	attrs, err := mem.Stat("/dir/l")
+	assert.Nil(t, err)
+	assert.True(t, attrs.Mode.IsRegular())
+	assert.Equal(t, hdfsStickyBit, attrs.Mode&hdfsStickyBit)
+	assert.Equal(t, "../x/y", memReadFile(t, mem, "/dir/l"))
+
+	var attr fuse.Attr
+	assert.Nil(t, link.Attr(nil, &attr))
+	assert.Equal(t, os.ModeSymlink, attr.Mode&os.ModeType)
+	assert.Equal(t, uint64(6), attr.Size)
+	target, err := link.(*FileINode).Readlink(nil, &fuse.ReadlinkRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, "../x/y", target)
+
+	// marker files are listed as links, the other files are left as they are
+	entries, err := readDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(entries))
+	for _, e := range entries {
+		if e.Name == "l" {
+			assert.Equal(t, fuse.DT_Link, e.Type)
+		} else {
+			assert.Equal(t, fuse.DT_File, e.Type)
+		}
+	}
+	lister, err := fs.getDFSConnector().OpenDir("/dir")
+	assert.Nil(t, err)
+	page, err := lister.Next(10)
+	assert.Nil(t, err)
+	assert.Equal(t, os.ModeSymlink, page[1].Mode&os.ModeType)
+	_, err = lister.Next(10)
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, lister.Close())
+
+	// links created by HopsFS are still supported
+	assert.Nil(t, mem.CreateSymlink("a", "/dir/native"))
+	target, err = fs.getDFSConnector().ReadLink("/dir/native")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", target)
+}
@@ -0,0 +1,115 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/colinmarc/hdfs/v2"
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// Removed entries are moved to the trash of the user, as done by `hdfs dfs -rm`. The layout is the one
+// of Hadoop: an entry is moved to .Trash/Current under the home directory of the user, at its full path.
+// The trash emptier of HopsFS, or `hdfs dfs -expunge`, renames Current to checkpoints named after
+// their creation time and deletes the expired checkpoints
+
+// Name of the trash directory in the home directory of the user
+const trashDirName = ".Trash"
+
+// Subdirectory of the trash which receives the removed entries, until the next checkpoint
+const trashCurrent = "Current"
+
+// Settings of the trash
+type TrashPolicy struct {
+	Root             string   // trash directory of the user, see UserTrashRoot
+	ExcludedPrefixes []string // HopsFS paths under which the entries are deleted permanently
+	MaxSize          int64    // entries larger than this number of bytes are deleted permanently, no limit if 0
+}
+
+// Returns the trash directory of the user on whose behalf HopsFS is accessed
+func UserTrashRoot() (string, error) {
+	userName, err := resolveHadoopUserName()
+	if err != nil {
+		return "", err
+	}
+	return path.Join("/user", userName, trashDirName), nil
+}
+
+// Returns true if the entry must be deleted permanently instead of being moved to the trash
+func (trash *TrashPolicy) isExcluded(p string) bool {
+	// the entries in the trash are expunged
+	if p == trash.Root || strings.HasPrefix(p, trash.Root+"/") {
+		return true
+	}
+	for _, prefix := range trash.ExcludedPrefixes {
+		prefix = path.Clean("/" + prefix)
+		if prefix == "/" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Moves a removed file or empty directory to the trash. Returns false, without an error, if the
+// entry must be deleted permanently instead: the trash is disabled, the entry is excluded or too large,
+// or the directory is not empty, in which case the removal fails
+func (filesystem *FileSystem) moveToTrash(p string, isDir bool) (bool, error) {
+	trash := filesystem.Trash
+	if trash == nil || trash.isExcluded(p) {
+		return false, nil
+	}
+	connector := filesystem.getDFSConnector()
+	if isDir || trash.MaxSize > 0 {
+		summary, err := connector.GetContentSummary(p)
+		if err != nil {
+			return false, err
+		}
+		if isDir && (summary.FileCount > 0 || summary.DirectoryCount > 1) {
+			return false, nil
+		}
+		if trash.MaxSize > 0 && summary.Length > trash.MaxSize {
+			logger.Info("Deleting path larger than the trash threshold", logger.Fields{Operation: Trash, Path: p, FileSize: summary.Length})
+			return false, nil
+		}
+	}
+
+	trashPath := path.Join(trash.Root, trashCurrent, p)
+	baseTrashPath := path.Dir(trashPath)
+	err := connector.MkdirAll(baseTrashPath, 0700)
+	if err == syscall.EEXIST || err == syscall.ENOTDIR {
+		// a file is in the way, the entry is moved to a sibling directory, like Hadoop does
+		baseTrashPath += trashTimestamp(filesystem.Clock)
+		trashPath = path.Join(baseTrashPath, path.Base(p))
+		err = connector.MkdirAll(baseTrashPath, 0700)
+	}
+	if err != nil {
+		logger.Warn("Unable to create the trash directory", logger.Fields{Operation: Trash, Path: baseTrashPath, Error: err})
+		return false, err
+	}
+
+	attrs, err := connector.Stat(trashPath)
+	if err == nil {
+		if isDir && attrs.Mode.IsDir() {
+			// the directory was created for the entries removed from it, nothing is lost by deleting it
+			return false, nil
+		}
+		trashPath += trashTimestamp(filesystem.Clock)
+	} else if err != syscall.ENOENT {
+		return false, err
+	}
+	if err := connector.Rename2(p, trashPath, hdfs.RenameOptions(hdfs.RENAME_NOREPLACE)); err != nil {
+		logger.Warn("Unable to move path to trash", logger.Fields{Operation: Trash, From: p, To: trashPath, Error: err})
+		return false, err
+	}
+	logger.Info("Moved path to trash", logger.Fields{Operation: Trash, From: p, To: trashPath})
+	return true, nil
+}
+
+// Suffix of the trash entries whose name is taken, the milliseconds since epoch as in Hadoop
+func trashTimestamp(clock Clock) string {
+	return strconv.FormatInt(clock.Now().UnixNano()/int64(1000000), 10)
+}
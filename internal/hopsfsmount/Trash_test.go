@@ -0,0 +1,100 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoveToTrash(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	mockClock.now = time.Unix(1000, 0)
+	assert.Nil(t, mem.MkdirAll("/data/sub", os.ModeDir|0755))
+	assert.Nil(t, mem.MkdirAll("/data/full", os.ModeDir|0755))
+	assert.Nil(t, mem.MkdirAll("/scratch", os.ModeDir|0755))
+	memWriteFile(t, mem, "/data/a", "a")
+	memWriteFile(t, mem, "/data/big", "larger than the threshold")
+	memWriteFile(t, mem, "/data/sub/b", "b")
+	memWriteFile(t, mem, "/data/full/c", "c")
+	memWriteFile(t, mem, "/scratch/tmp", "tmp")
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.Trash = &TrashPolicy{Root: "/user/alice/.Trash", ExcludedPrefixes: []string{"scratch"}, MaxSize: 10}
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "data")
+	assert.Nil(t, err)
+	data := node.(*DirINode)
+	node, err = root.(*DirINode).Lookup(nil, "scratch")
+	assert.Nil(t, err)
+	scratch := node.(*DirINode)
+	node, err = data.Lookup(nil, "sub")
+	assert.Nil(t, err)
+	sub := node.(*DirINode)
+
+	// removed files are moved to the trash at their full path
+	assert.Nil(t, data.Remove(nil, &fuse.RemoveRequest{Name: "a"}))
+	_, err = mem.Stat("/data/a")
+	assert.Equal(t, syscall.ENOENT, err)
+	assert.Equal(t, "a", memReadFile(t, mem, "/user/alice/.Trash/Current/data/a"))
+	_, err = data.Lookup(nil, "a")
+	assert.Equal(t, syscall.ENOENT, err)
+
+	// the name is made unique if taken
+	memWriteFile(t, mem, "/data/a", "a2")
+	assert.Nil(t, data.Remove(nil, &fuse.RemoveRequest{Name: "a"}))
+	assert.Equal(t, "a2", memReadFile(t, mem, "/user/alice/.Trash/Current/data/a1000000"))
+
+	// the directories emptied by rm -r are deleted once their entries are in the trash
+	assert.Nil(t, sub.Remove(nil, &fuse.RemoveRequest{Name: "b"}))
+	assert.Nil(t, data.Remove(nil, &fuse.RemoveRequest{Name: "sub", Dir: true}))
+	_, err = mem.Stat("/data/sub")
+	assert.Equal(t, syscall.ENOENT, err)
+	assert.Equal(t, "b", memReadFile(t, mem, "/user/alice/.Trash/Current/data/sub/b"))
+
+	// the directories which are not empty are not moved
+	err = data.Remove(nil, &fuse.RemoveRequest{Name: "full", Dir: true})
+	assert.Equal(t, syscall.ENOTEMPTY, err)
+	assert.Equal(t, "c", memReadFile(t, mem, "/data/full/c"))
+
+	// large files and excluded prefixes are deleted permanently
+	assert.Nil(t, data.Remove(nil, &fuse.RemoveRequest{Name: "big"}))
+	_, err = mem.Stat("/user/alice/.Trash/Current/data/big")
+	assert.Equal(t, syscall.ENOENT, err)
+	assert.Nil(t, scratch.Remove(nil, &fuse.RemoveRequest{Name: "tmp"}))
+	_, err = mem.Stat("/user/alice/.Trash/Current/scratch")
+	assert.Equal(t, syscall.ENOENT, err)
+
+	// the entries of the trash are deleted permanently
+	node, err = root.(*DirINode).Lookup(nil, "user")
+	assert.Nil(t, err)
+	for _, name := range []string{"alice", ".Trash", "Current", "data"} {
+		node, err = node.(*DirINode).Lookup(nil, name)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, node.(*DirINode).Remove(nil, &fuse.RemoveRequest{Name: "a"}))
+	allAttrs, err := mem.ReadDir("/user/alice/.Trash/Current/data")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(allAttrs))
+}
+
+func TestMoveToTrashWithFileInTheWay(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	mockClock.now = time.Unix(1000, 0)
+	assert.Nil(t, mem.MkdirAll("/data", os.ModeDir|0755))
+	assert.Nil(t, mem.MkdirAll("/user/alice/.Trash/Current", os.ModeDir|0700))
+	memWriteFile(t, mem, "/data/a", "a")
+	memWriteFile(t, mem, "/user/alice/.Trash/Current/data", "file")
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.Trash = &TrashPolicy{Root: "/user/alice/.Trash"}
+
+	trashed, err := fs.moveToTrash("/data/a", false)
+	assert.Nil(t, err)
+	assert.True(t, trashed)
+	assert.Equal(t, "a", memReadFile(t, mem, "/user/alice/.Trash/Current/data1000000/a"))
+	assert.Equal(t, "file", memReadFile(t, mem, "/user/alice/.Trash/Current/data"))
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// A new version of a file is uploaded to a hidden sibling, see TempSiblingPath, which is then renamed
+// over the file. The siblings are hidden from the listings. A record of the upload is kept in the
+// local journal until the sibling is renamed or removed, the siblings left behind by a crash are
+// removed on the next mount, see RemoveStaleUploads
+
+// Suffix of the upload records in the journal
+const uploadRecordSuffix = ".upload"
+
+// Record of an upload in progress
+type uploadRecord struct {
+	TmpPath string `json:"tmp"`
+}
+
+// Stores the record of an upload to tmpPath in the journal. Returns the path of the record,
+// empty if the journal is disabled
+func (filesystem *FileSystem) writeUploadRecord(tmpPath string) (string, error) {
+	return filesystem.writeJournalRecord(filepath.Base(tmpPath)+uploadRecordSuffix, &uploadRecord{TmpPath: tmpPath})
+}
+
+func removeUploadRecord(journalPath string) {
+	if journalPath == "" {
+		return
+	}
+	if err := os.Remove(journalPath); err != nil {
+		logger.Warn("Unable to remove an upload record", logger.Fields{Operation: Flush, Path: journalPath, Error: err})
+	}
+}
+
+// Removes the hidden siblings of the uploads which were interrupted while this file system was
+// last mounted. The records of the siblings which can not be removed are kept for the next mount
+func (filesystem *FileSystem) RemoveStaleUploads() {
+	if filesystem.JournalDir == "" {
+		return
+	}
+	files, err := os.ReadDir(filesystem.JournalDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("Unable to read the upload journal", logger.Fields{Operation: Flush, Path: filesystem.JournalDir, Error: err})
+		}
+		return
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), uploadRecordSuffix) {
+			continue
+		}
+		journalPath := filepath.Join(filesystem.JournalDir, file.Name())
+		var record uploadRecord
+		data, err := os.ReadFile(journalPath)
+		if err == nil {
+			err = json.Unmarshal(data, &record)
+		}
+		if err != nil {
+			logger.Warn("Unable to read an upload record", logger.Fields{Operation: Flush, Path: journalPath, Error: err})
+			continue
+		}
+		if err := filesystem.getDFSConnector().Remove(record.TmpPath); err != nil && err != syscall.ENOENT {
+			logger.Warn("Unable to delete the temporary file of an interrupted upload", logger.Fields{Operation: Flush, TmpFile: record.TmpPath, Error: err})
+			continue
+		}
+		logger.Info("Deleted the temporary file of an interrupted upload", logger.Fields{Operation: Flush, TmpFile: record.TmpPath})
+		removeUploadRecord(journalPath)
+	}
+}
+
+// Returns if name is the name of a hidden sibling, see TempSiblingPath
+func isTempSibling(name string) bool {
+	if !strings.HasPrefix(name, ".") || !strings.HasSuffix(name, TempFileSuffix) {
+		return false
+	}
+	stem := strings.TrimSuffix(name, TempFileSuffix)
+	dot := strings.LastIndexByte(stem, '.')
+	if dot < 1 || len(stem)-dot-1 != 16 {
+		return false
+	}
+	for _, c := range stem[dot+1:] {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTempSiblingsAreHidden(t *testing.T) {
+	assert.True(t, isTempSibling(path.Base(TempSiblingPath("/dir/a"))))
+	assert.True(t, isTempSibling(path.Base(TempSiblingPath("/dir/.a.b"))))
+	assert.False(t, isTempSibling(".a"+TempFileSuffix))
+	assert.False(t, isTempSibling("a.0123456789abcdef"+TempFileSuffix))
+	assert.False(t, isTempSibling(".a.0123456789abcdeg"+TempFileSuffix))
+
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "a")
+	tmpPath := TempSiblingPath("/dir/a")
+	memWriteFile(t, mem, tmpPath, "b")
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	dir := node.(*DirINode)
+
+	entries, err := readDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, "a", entries[0].Name)
+	_, err = dir.Lookup(nil, path.Base(tmpPath))
+	assert.Equal(t, syscall.ENOENT, err)
+}
+
+func TestRemoveStaleUploads(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	fs.JournalDir = t.TempDir()
+	root, _ := fs.Root()
+	node, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	dir := node.(*DirINode)
+
+	// the record of an upload is removed with its sibling
+	_, h, err := dir.Create(nil, &fuse.CreateRequest{Name: "a", Flags: fuse.OpenReadWrite | fuse.OpenCreate, Mode: 0644}, &fuse.CreateResponse{})
+	assert.Nil(t, err)
+	handle := h.(*FileHandle)
+	assert.Nil(t, handle.Write(nil, &fuse.WriteRequest{Data: []byte("a")}, &fuse.WriteResponse{}))
+	assert.Nil(t, handle.Flush(nil, nil))
+	assert.Nil(t, handle.Release(nil, nil))
+	assert.Equal(t, "a", memReadFile(t, mem, "/dir/a"))
+	records, err := os.ReadDir(fs.JournalDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+
+	// interrupted while uploading
+	uploading := TempSiblingPath("/dir/a")
+	_, err = fs.writeUploadRecord(uploading)
+	assert.Nil(t, err)
+	memWriteFile(t, mem, uploading, "b")
+	// interrupted before the sibling was created
+	notStarted := TempSiblingPath("/dir/a")
+	_, err = fs.writeUploadRecord(notStarted)
+	assert.Nil(t, err)
+
+	fs.RemoveStaleUploads()
+	_, err = mem.Stat(uploading)
+	assert.Equal(t, syscall.ENOENT, err)
+	allAttrs, err := mem.ReadDir("/dir")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(allAttrs))
+	assert.Equal(t, "a", memReadFile(t, mem, "/dir/a"))
+	records, err = os.ReadDir(fs.JournalDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+}
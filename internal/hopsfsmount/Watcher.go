@@ -0,0 +1,199 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"strings"
+	"time"
+
+	"bazil.org/fuse/fs"
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// Polls subtrees of the mount for changes made by other clients, so that they show up without waiting
+// for the cached attributes to expire. Only the directories and files known to the mount are refreshed.
+// A directory is listed again if its mtime has changed, which means that entries were added or removed,
+// or if it has known children whose attributes may have changed. Updated attributes are stored in the
+// inodes and the kernel is told to drop its cached data of the changed ones.
+// Concurrency: Start and Stop are called once each, the polling runs in its own goroutine
+type Watcher struct {
+	FileSystem    *FileSystem
+	Paths         []string      // Watched subtrees, relative to the mount point
+	Interval      time.Duration // Delay between polls
+	MaxRPCsPerSec int           // Maximum rate of the requests sent to the name node by the watcher
+	nextRPC       time.Time     // time at which the next request may be sent
+	mtimes        map[string]time.Time
+	stop          chan struct{}
+}
+
+// Creates a watcher of the given subtrees
+func NewWatcher(filesystem *FileSystem, paths []string, interval time.Duration, maxRPCsPerSec int) *Watcher {
+	return &Watcher{
+		FileSystem:    filesystem,
+		Paths:         paths,
+		Interval:      interval,
+		MaxRPCsPerSec: maxRPCsPerSec,
+		mtimes:        make(map[string]time.Time),
+		stop:          make(chan struct{})}
+}
+
+// Starts polling in the background
+func (w *Watcher) Start() {
+	logger.Info("Watching for remote changes", logger.Fields{Operation: Watch, Path: strings.Join(w.Paths, ","), Delay: w.Interval})
+	go func() {
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-w.FileSystem.Clock.After(w.Interval):
+				w.Poll()
+			}
+		}
+	}()
+}
+
+// Stops polling
+func (w *Watcher) Stop() {
+	close(w.stop)
+}
+
+// Checks all the watched subtrees once
+func (w *Watcher) Poll() {
+	root, _ := w.FileSystem.Root()
+	for _, p := range w.Paths {
+		dir := root.(*DirINode)
+		var parent *DirINode
+		for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+			if name == "" {
+				continue
+			}
+			child, ok := dir.getChildInode(Watch, name).(*DirINode)
+			if !ok {
+				// not known to the mount, nothing is cached for it
+				dir = nil
+				break
+			}
+			parent, dir = dir, child
+		}
+		if dir == nil {
+			continue
+		}
+		changed, ok := w.statDir(parent, dir)
+		if !ok || !w.pollDir(dir, changed) {
+			return
+		}
+	}
+}
+
+// Refreshes the attributes of the root of a watched subtree. Returns true if its mtime has changed,
+// and false for ok if the watcher has been stopped
+func (w *Watcher) statDir(parent, dir *DirINode) (changed, ok bool) {
+	if !w.throttle() {
+		return false, false
+	}
+	attrs, err := w.FileSystem.getDFSConnector().Stat(dir.AbsolutePath())
+	if err != nil {
+		logger.Warn("Failed to stat watched directory", logger.Fields{Operation: Watch, Path: dir.AbsolutePath(), Error: err})
+		return false, true
+	}
+	if parent != nil {
+		parent.addOrUpdateChildInodeAttrs(Watch, dir.Attrs.Name, attrs)
+	}
+	// the mtimes of the watched roots are kept here, as the mount root does not have backend attributes
+	last, seen := w.mtimes[dir.AbsolutePath()]
+	w.mtimes[dir.AbsolutePath()] = attrs.Mtime
+	return seen && !last.Equal(attrs.Mtime), true
+}
+
+// Lists the directory if anything may have changed in it and refreshes its known children,
+// then its known subdirectories. Returns false if the watcher has been stopped
+func (w *Watcher) pollDir(dir *DirINode, changed bool) bool {
+	known := dir.childInodes()
+	if !changed && len(known) == 0 {
+		return true
+	}
+	if !w.throttle() {
+		return false
+	}
+
+	dir.lockMutex()
+	absolutePath := dir.AbsolutePath()
+	allAttrs, err := dir.FileSystem.getDFSConnector().ReadDir(absolutePath)
+	if err != nil {
+		dir.unlockMutex()
+		logger.Warn("Failed to list watched directory", logger.Fields{Operation: Watch, Path: absolutePath, Error: err})
+		return true
+	}
+
+	var subdirs []*DirINode
+	var subdirsChanged []bool
+	seen := make(map[string]bool, len(allAttrs))
+	for _, a := range allAttrs {
+		if !dir.FileSystem.IsPathAllowed(dir.AbsolutePathForChild(a.Name)) || isTempSibling(a.Name) {
+			continue
+		}
+		seen[a.Name] = true
+		node, ok := known[a.Name]
+		if !ok {
+			if changed {
+				// the kernel may have cached that the name does not exist
+				dir.FileSystem.invalidateEntry(dir, a.Name)
+			}
+			continue
+		}
+		old := *attrsOfInode(node)
+		node = dir.addOrUpdateChildInodeAttrs(Watch, a.Name, a)
+		if subdir, ok := node.(*DirINode); ok {
+			subdirs = append(subdirs, subdir)
+			subdirsChanged = append(subdirsChanged, !old.Mtime.Equal(a.Mtime))
+		}
+	}
+	if changed {
+		for name := range known {
+			if !seen[name] {
+				logger.Info("Remote delete detected", logger.Fields{Operation: Watch, Parent: absolutePath, Child: name})
+				dir.removeChildInode(Watch, name)
+				dir.FileSystem.invalidateEntry(dir, name)
+			}
+		}
+		dir.invalidateListing(Watch)
+	}
+	dir.unlockMutex()
+	logger.Debug("Polled watched directory", logger.Fields{Operation: Watch, Path: absolutePath, Entries: len(allAttrs)})
+
+	for i, subdir := range subdirs {
+		if !w.pollDir(subdir, subdirsChanged[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Waits until the next request may be sent to the name node. Returns false if the watcher has been stopped
+func (w *Watcher) throttle() bool {
+	now := w.FileSystem.Clock.Now()
+	if wait := w.nextRPC.Sub(now); wait > 0 {
+		select {
+		case <-w.stop:
+			return false
+		case <-w.FileSystem.Clock.After(wait):
+		}
+		now = w.nextRPC
+	}
+	w.nextRPC = now.Add(time.Second / time.Duration(w.MaxRPCsPerSec))
+	return true
+}
+
+// Returns the children of the directory known to the mount, keyed by name
+func (dir *DirINode) childInodes() map[string]fs.Node {
+	dir.lockChildrenMutex()
+	defer dir.unlockChildrenMutex()
+
+	nodes := make(map[string]fs.Node, len(dir.children))
+	for name, id := range dir.children {
+		if node := dir.FileSystem.INodes.Peek(id); node != nil && dir.isChildInode(name, node) {
+			nodes[name] = node
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcher(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	assert.Nil(t, mem.MkdirAll("/proj/out", os.ModeDir|0755))
+	assert.Nil(t, mem.Mkdir("/other", os.ModeDir|0755))
+	memWriteFile(t, mem, "/proj/out/a", "a")
+	accessor := &listingCountingAccessor{MemHdfsAccessor: mem}
+	fileSystem, _ := NewFileSystem([]HdfsAccessor{accessor}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	invalidator := &recordingInvalidator{calls: make(chan invalidation, 10)}
+	fileSystem.Invalidator = invalidator
+	root, _ := fileSystem.Root()
+	node, err := root.(*DirINode).Lookup(nil, "proj")
+	assert.Nil(t, err)
+	node, err = node.(*DirINode).Lookup(nil, "out")
+	assert.Nil(t, err)
+	out := node.(*DirINode)
+	a, err := out.Lookup(nil, "a")
+	assert.Nil(t, err)
+
+	// subtrees which are not known to the mount are skipped
+	watcher := NewWatcher(fileSystem, []string{"proj", "other/x"}, time.Second, 1000)
+	watcher.Poll()
+	assert.Equal(t, 0, len(invalidator.calls))
+
+	// changes made by other clients are picked up without waiting for the cached attributes to expire
+	mockClock.NotifyTimeElapsed(time.Second)
+	memAppendFile(t, mem, "/proj/out/a", "bc")
+	memWriteFile(t, mem, "/proj/out/b", "b")
+	watcher.Poll()
+	assert.Equal(t, uint64(3), a.(*FileINode).Attrs.Size)
+	calls := []invalidation{invalidator.next(t), invalidator.next(t), invalidator.next(t)}
+	assert.ElementsMatch(t, []invalidation{{node: a}, {node: out}, {node: out, name: "b"}}, calls)
+
+	// removed files are dropped, the entries of a changed directory which are not known are invalidated
+	mockClock.NotifyTimeElapsed(time.Second)
+	assert.Nil(t, mem.Remove("/proj/out/a"))
+	watcher.Poll()
+	calls = []invalidation{invalidator.next(t), invalidator.next(t), invalidator.next(t)}
+	assert.ElementsMatch(t, []invalidation{{node: out}, {node: out, name: "a"}, {node: out, name: "b"}}, calls)
+	assert.Equal(t, 0, len(out.childInodes()))
+
+	// directories without changes or known children are not listed
+	listings := accessor.listings
+	watcher.Poll()
+	assert.Equal(t, listings+1, accessor.listings)
+	assert.Equal(t, 0, len(invalidator.calls))
+
+	// the requests are spaced by the rate cap
+	assert.True(t, mockClock.LastSleepDuration > 0)
+}
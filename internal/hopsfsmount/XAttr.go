@@ -0,0 +1,232 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+	"hopsworks.ai/hopsfsmount/internal/hopsfsmount/logger"
+)
+
+// Prefix of the extended attributes which are passed through to the HopsFS xattrs.
+// The other namespaces are not supported
+const userXAttrPrefix = "user."
+
+// Prefix of the virtual extended attributes which expose the HopsFS metadata of files and directories.
+// They are queried from the name node on demand and are not listed, so that the tools copying
+// xattrs do not try to recreate them elsewhere. Only the replication can be set.
+// The block locations and the under construction status are not exposed, as the HopsFS client
+// only calls getBlockLocations internally when reading
+const hopsfsXAttrPrefix = "hopsfs."
+
+// Virtual extended attributes
+const (
+	hopsfsReplicationXAttr    = hopsfsXAttrPrefix + "replication"     // replication factor of a file
+	hopsfsBlockSizeXAttr      = hopsfsXAttrPrefix + "block_size"      // block size of a file in bytes
+	hopsfsStoragePolicyXAttr  = hopsfsXAttrPrefix + "storage_policy"  // name, or id if unknown, of the storage policy
+	hopsfsContentSummaryXAttr = hopsfsXAttrPrefix + "content_summary" // summary of the subtree
+)
+
+// Names of the built-in storage policies, keyed by id
+var hdfsStoragePolicies = map[uint32]string{
+	0:  "UNSPECIFIED",
+	2:  "COLD",
+	5:  "WARM",
+	7:  "HOT",
+	10: "ONE_SSD",
+	12: "ALL_SSD",
+	15: "LAZY_PERSIST",
+}
+
+// Responds on FUSE Getxattr request
+func (dir *DirINode) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return getXAttr(dir.FileSystem, dir.AbsolutePath(), req, resp)
+}
+
+// Responds on FUSE Listxattr request
+func (dir *DirINode) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return listXAttrs(dir.FileSystem, dir.AbsolutePath(), resp)
+}
+
+// Responds on FUSE Setxattr request
+func (dir *DirINode) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return setXAttr(dir.FileSystem, dir, dir.AbsolutePath(), req)
+}
+
+// Responds on FUSE Removexattr request
+func (dir *DirINode) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return removeXAttr(dir.FileSystem, dir, dir.AbsolutePath(), req)
+}
+
+// Responds on FUSE Getxattr request
+func (file *FileINode) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return getXAttr(file.FileSystem, file.AbsolutePath(), req, resp)
+}
+
+// Responds on FUSE Listxattr request
+func (file *FileINode) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return listXAttrs(file.FileSystem, file.AbsolutePath(), resp)
+}
+
+// Responds on FUSE Setxattr request
+func (file *FileINode) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return setXAttr(file.FileSystem, file, file.AbsolutePath(), req)
+}
+
+// Responds on FUSE Removexattr request
+func (file *FileINode) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return removeXAttr(file.FileSystem, file, file.AbsolutePath(), req)
+}
+
+func getXAttr(filesystem *FileSystem, path string, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	var value []byte
+	var err error
+	if strings.HasPrefix(req.Name, hopsfsXAttrPrefix) {
+		value, err = getHopsfsXAttr(filesystem.getDFSConnector(), path, req.Name)
+	} else if strings.HasPrefix(req.Name, userXAttrPrefix) {
+		value, err = filesystem.getDFSConnector().GetXAttr(path, req.Name)
+	} else {
+		// answered locally, the kernel asks for security.capability on every write
+		return fuse.ErrNoXattr
+	}
+	if err != nil {
+		if err != syscall.ENODATA {
+			logger.Warn("Failed to get xattr", logger.Fields{Operation: GetXAttr, Path: path, XAttr: req.Name, Error: err})
+		}
+		return err
+	}
+	resp.Xattr = value
+	return nil
+}
+
+func listXAttrs(filesystem *FileSystem, path string, resp *fuse.ListxattrResponse) error {
+	names, err := filesystem.getDFSConnector().ListXAttrs(path)
+	if err != nil {
+		logger.Warn("Failed to list xattrs", logger.Fields{Operation: ListXAttr, Path: path, Error: err})
+		return err
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, userXAttrPrefix) {
+			resp.Append(name)
+		}
+	}
+	return nil
+}
+
+func setXAttr(filesystem *FileSystem, node fs.Node, path string, req *fuse.SetxattrRequest) error {
+	if isInSnapshot(node) {
+		return syscall.EROFS
+	}
+	if strings.HasPrefix(req.Name, hopsfsXAttrPrefix) {
+		return setHopsfsXAttr(filesystem.getDFSConnector(), path, req)
+	}
+	if !strings.HasPrefix(req.Name, userXAttrPrefix) {
+		return syscall.ENOTSUP
+	}
+	connector := filesystem.getDFSConnector()
+	if req.Flags&(unix.XATTR_CREATE|unix.XATTR_REPLACE) != 0 {
+		// HopsFS creates or replaces the attribute, the flags are checked here
+		_, err := connector.GetXAttr(path, req.Name)
+		if err != nil && err != syscall.ENODATA {
+			return err
+		}
+		if err == nil && req.Flags&unix.XATTR_CREATE != 0 {
+			return syscall.EEXIST
+		}
+		if err == syscall.ENODATA && req.Flags&unix.XATTR_REPLACE != 0 {
+			return syscall.ENODATA
+		}
+	}
+	logger.Info("Setting xattr", logger.Fields{Operation: SetXAttr, Path: path, XAttr: req.Name})
+	err := connector.SetXAttr(path, req.Name, req.Xattr)
+	if err != nil {
+		logger.Warn("Failed to set xattr", logger.Fields{Operation: SetXAttr, Path: path, XAttr: req.Name, Error: err})
+	}
+	return err
+}
+
+func removeXAttr(filesystem *FileSystem, node fs.Node, path string, req *fuse.RemovexattrRequest) error {
+	if isInSnapshot(node) {
+		return syscall.EROFS
+	}
+	if strings.HasPrefix(req.Name, hopsfsXAttrPrefix) {
+		return syscall.EPERM
+	}
+	if !strings.HasPrefix(req.Name, userXAttrPrefix) {
+		return syscall.ENOTSUP
+	}
+	logger.Info("Removing xattr", logger.Fields{Operation: RemoveXAttr, Path: path, XAttr: req.Name})
+	err := filesystem.getDFSConnector().RemoveXAttr(path, req.Name)
+	if err != nil && err != syscall.ENODATA {
+		logger.Warn("Failed to remove xattr", logger.Fields{Operation: RemoveXAttr, Path: path, XAttr: req.Name, Error: err})
+	}
+	return err
+}
+
+// Returns the value of a virtual extended attribute, ENODATA for unknown names
+// and for the attributes which do not apply to directories
+func getHopsfsXAttr(connector HdfsAccessor, path string, name string) ([]byte, error) {
+	switch name {
+	case hopsfsReplicationXAttr, hopsfsBlockSizeXAttr, hopsfsStoragePolicyXAttr:
+	case hopsfsContentSummaryXAttr:
+		summary, err := connector.GetContentSummary(path)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(fmt.Sprintf("length=%d files=%d directories=%d space_consumed=%d quota=%d space_quota=%d",
+			summary.Length, summary.FileCount, summary.DirectoryCount, summary.SpaceConsumed,
+			summary.NameQuota, summary.SpaceQuota)), nil
+	default:
+		return nil, syscall.ENODATA
+	}
+
+	metadata, err := connector.GetMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case hopsfsReplicationXAttr:
+		if metadata.Replication == 0 {
+			return nil, syscall.ENODATA
+		}
+		return []byte(strconv.Itoa(int(metadata.Replication))), nil
+	case hopsfsBlockSizeXAttr:
+		if metadata.BlockSize == 0 {
+			return nil, syscall.ENODATA
+		}
+		return []byte(strconv.FormatUint(metadata.BlockSize, 10)), nil
+	default:
+		if policy, ok := hdfsStoragePolicies[metadata.StoragePolicy]; ok {
+			return []byte(policy), nil
+		}
+		return []byte(strconv.FormatUint(uint64(metadata.StoragePolicy), 10)), nil
+	}
+}
+
+// Applies a virtual extended attribute. Only the replication of files can be set, the others are read-only
+func setHopsfsXAttr(connector HdfsAccessor, path string, req *fuse.SetxattrRequest) error {
+	if req.Name != hopsfsReplicationXAttr {
+		return syscall.EPERM
+	}
+	if req.Flags&unix.XATTR_CREATE != 0 {
+		// the replication always exists
+		return syscall.EEXIST
+	}
+	replication, err := strconv.ParseUint(strings.TrimSpace(string(req.Xattr)), 10, 16)
+	if err != nil || replication == 0 {
+		return syscall.EINVAL
+	}
+	logger.Info("Setting replication", logger.Fields{Operation: SetXAttr, Path: path, Replication: replication})
+	err = connector.SetReplication(path, uint16(replication))
+	if err != nil {
+		logger.Warn("Failed to set replication", logger.Fields{Operation: SetXAttr, Path: path, Replication: replication, Error: err})
+	}
+	return err
+}
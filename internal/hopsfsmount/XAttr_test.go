@@ -0,0 +1,125 @@
+// Copyright (c) Hopsworks AB. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for details.
+package hopsfsmount
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+type xattrNode interface {
+	fs.NodeGetxattrer
+	fs.NodeListxattrer
+	fs.NodeSetxattrer
+	fs.NodeRemovexattrer
+}
+
+func getXAttrValue(node xattrNode, name string) (string, error) {
+	resp := &fuse.GetxattrResponse{}
+	err := node.Getxattr(nil, &fuse.GetxattrRequest{Name: name}, resp)
+	return string(resp.Xattr), err
+}
+
+func listXAttrNames(t *testing.T, node xattrNode) []string {
+	resp := &fuse.ListxattrResponse{}
+	assert.Nil(t, node.Listxattr(nil, &fuse.ListxattrRequest{}, resp))
+	return strings.FieldsFunc(string(resp.Xattr), func(r rune) bool { return r == 0 })
+}
+
+func TestXAttrs(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "a")
+	assert.Nil(t, mem.SetXAttr("/dir/a", "trusted.hidden", []byte("x")))
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	dir, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	file, err := dir.(*DirINode).Lookup(nil, "a")
+	assert.Nil(t, err)
+
+	for _, node := range []xattrNode{dir.(*DirINode), file.(*FileINode)} {
+		// user attributes are stored in HopsFS
+		assert.Nil(t, node.Setxattr(nil, &fuse.SetxattrRequest{Name: "user.provenance", Xattr: []byte("job-1")}))
+		value, err := getXAttrValue(node, "user.provenance")
+		assert.Nil(t, err)
+		assert.Equal(t, "job-1", value)
+		assert.Equal(t, []string{"user.provenance"}, listXAttrNames(t, node))
+
+		// the create and replace flags are honored
+		err = node.Setxattr(nil, &fuse.SetxattrRequest{Name: "user.provenance", Xattr: []byte("job-2"), Flags: unix.XATTR_CREATE})
+		assert.Equal(t, syscall.EEXIST, err)
+		err = node.Setxattr(nil, &fuse.SetxattrRequest{Name: "user.other", Xattr: []byte("x"), Flags: unix.XATTR_REPLACE})
+		assert.Equal(t, syscall.ENODATA, err)
+		assert.Nil(t, node.Setxattr(nil, &fuse.SetxattrRequest{Name: "user.provenance", Xattr: []byte("job-2"), Flags: unix.XATTR_REPLACE}))
+		value, _ = getXAttrValue(node, "user.provenance")
+		assert.Equal(t, "job-2", value)
+
+		assert.Nil(t, node.Removexattr(nil, &fuse.RemovexattrRequest{Name: "user.provenance"}))
+		_, err = getXAttrValue(node, "user.provenance")
+		assert.Equal(t, syscall.ENODATA, err)
+		assert.Equal(t, syscall.ENODATA, node.Removexattr(nil, &fuse.RemovexattrRequest{Name: "user.provenance"}))
+		assert.Equal(t, 0, len(listXAttrNames(t, node)))
+
+		// the other namespaces are not exposed
+		_, err = getXAttrValue(node, "security.capability")
+		assert.Equal(t, fuse.ErrNoXattr, err)
+		assert.Equal(t, syscall.ENOTSUP, node.Setxattr(nil, &fuse.SetxattrRequest{Name: "trusted.x", Xattr: []byte("x")}))
+	}
+	_, err = getXAttrValue(file.(*FileINode), "trusted.hidden")
+	assert.Equal(t, fuse.ErrNoXattr, err)
+}
+
+func TestHopsfsXAttrs(t *testing.T) {
+	mem, mockClock := newTestMemHdfsAccessor(t)
+	assert.Nil(t, mem.Mkdir("/dir", os.ModeDir|0755))
+	memWriteFile(t, mem, "/dir/a", "abc")
+	fs, _ := NewFileSystem([]HdfsAccessor{mem}, "/", []string{"*"}, false, NewDefaultRetryPolicy(mockClock), mockClock)
+	root, _ := fs.Root()
+	dir, err := root.(*DirINode).Lookup(nil, "dir")
+	assert.Nil(t, err)
+	file, err := dir.(*DirINode).Lookup(nil, "a")
+	assert.Nil(t, err)
+
+	// the metadata is queried from the name node, the virtual attributes are not listed
+	value, err := getXAttrValue(file.(*FileINode), "hopsfs.replication")
+	assert.Nil(t, err)
+	assert.Equal(t, "3", value)
+	value, err = getXAttrValue(file.(*FileINode), "hopsfs.block_size")
+	assert.Nil(t, err)
+	assert.Equal(t, "134217728", value)
+	value, err = getXAttrValue(file.(*FileINode), "hopsfs.storage_policy")
+	assert.Nil(t, err)
+	assert.Equal(t, "UNSPECIFIED", value)
+	assert.Equal(t, 0, len(listXAttrNames(t, file.(*FileINode))))
+	_, err = getXAttrValue(file.(*FileINode), "hopsfs.unknown")
+	assert.Equal(t, syscall.ENODATA, err)
+	_, err = getXAttrValue(file.(*FileINode), "hopsfs.block_locations")
+	assert.Equal(t, syscall.ENODATA, err)
+
+	// the replication of files can be set, the other attributes are read-only
+	assert.Nil(t, file.(*FileINode).Setxattr(nil, &fuse.SetxattrRequest{Name: "hopsfs.replication", Xattr: []byte("2\n")}))
+	value, _ = getXAttrValue(file.(*FileINode), "hopsfs.replication")
+	assert.Equal(t, "2", value)
+	err = file.(*FileINode).Setxattr(nil, &fuse.SetxattrRequest{Name: "hopsfs.replication", Xattr: []byte("two")})
+	assert.Equal(t, syscall.EINVAL, err)
+	err = file.(*FileINode).Setxattr(nil, &fuse.SetxattrRequest{Name: "hopsfs.block_size", Xattr: []byte("1024")})
+	assert.Equal(t, syscall.EPERM, err)
+	assert.Equal(t, syscall.EPERM, file.(*FileINode).Removexattr(nil, &fuse.RemovexattrRequest{Name: "hopsfs.replication"}))
+
+	// directories have a content summary but no replication
+	_, err = getXAttrValue(dir.(*DirINode), "hopsfs.replication")
+	assert.Equal(t, syscall.ENODATA, err)
+	err = dir.(*DirINode).Setxattr(nil, &fuse.SetxattrRequest{Name: "hopsfs.replication", Xattr: []byte("2")})
+	assert.Equal(t, syscall.EISDIR, err)
+	value, err = getXAttrValue(dir.(*DirINode), "hopsfs.content_summary")
+	assert.Nil(t, err)
+	assert.Equal(t, "length=3 files=1 directories=1 space_consumed=6 quota=-1 space_quota=-1", value)
+}
@@ -4,7 +4,10 @@
 package hopsfsmount
 
 import (
+	"fmt"
+	"math/rand"
 	"os"
+	"path"
 	"syscall"
 	"time"
 
@@ -101,33 +104,51 @@ func getGroupName(path string, gid uint32) (string, error) {
 	}
 }
 
+// Applies the access and modification times of a Setattr request to the file in HopsFS.
+// HopsFS sets both times at once, the time which is not changed is sent as it is
 func UpdateTS(attrs *Attrs, fileSystem *FileSystem, path string, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
-
-	// in future if we need access time then we can update the file system client to support it
-	if req.Valid.Atime() {
-		logger.Debug("The stat op in hopsfs client returns os.FileInfo which does not have access time. Ignoring atime for now", nil)
+	if req.Valid.Handle() {
+		logger.Warn("Setattr Handle is not implemented yet.", nil)
 	}
 
-	if req.Valid.Mtime() {
-		attrs.Mtime = time.Unix(int64(req.Mtime.Second()), 0)
+	if req.Valid.LockOwner() {
+		logger.Warn("Setattr LockOwner is not implemented yet.", nil)
 	}
 
-	if req.Valid.Handle() {
-		logger.Warn("Setattr Handle is not implemented yet.", nil)
+	if !req.Valid.Atime() && !req.Valid.Mtime() && !req.Valid.AtimeNow() && !req.Valid.MtimeNow() {
+		return nil
 	}
 
+	atime, mtime := attrs.Atime, attrs.Mtime
+	if atime.IsZero() || mtime.IsZero() {
+		// the attributes of new files are not read from HopsFS
+		current, err := fileSystem.getDFSConnector().Stat(path)
+		if err != nil {
+			return err
+		}
+		atime, mtime = current.Atime, current.Mtime
+	}
+	now := fileSystem.Clock.Now()
 	if req.Valid.AtimeNow() {
-		logger.Debug("Setattr AtimeNow is not implemented yet.", nil)
+		atime = now
+	} else if req.Valid.Atime() {
+		atime = req.Atime
 	}
-
 	if req.Valid.MtimeNow() {
-		logger.Debug("Setattr MtimeNow is not implemented yet.", nil)
+		mtime = now
+	} else if req.Valid.Mtime() {
+		mtime = req.Mtime
 	}
+	// HopsFS keeps the times with a precision of seconds
+	atime, mtime = time.Unix(atime.Unix(), 0), time.Unix(mtime.Unix(), 0)
 
-	if req.Valid.LockOwner() {
-		logger.Warn("Setattr LockOwner is not implemented yet.", nil)
+	logger.Info("Setting times", logger.Fields{Operation: SetTimes, Path: path, Atime: atime, Mtime: mtime})
+	if err := fileSystem.getDFSConnector().SetTimes(path, atime, mtime); err != nil {
+		logger.Warn("Unable to set times", logger.Fields{Operation: SetTimes, Path: path, Error: err})
+		return err
 	}
-
+	attrs.Atime, attrs.Mtime = atime, mtime
+	resp.Attr.Atime, resp.Attr.Mtime = atime, mtime
 	return nil
 }
 
@@ -152,3 +173,12 @@ func ComputePermissions(defaultPerm os.FileMode) os.FileMode {
 	}
 	return mode
 }
+
+// Suffix of the hidden files used to stage new versions of files in DFS
+const TempFileSuffix = ".hopsfs-mount.tmp"
+
+// Returns a hidden path in the same directory as p, for staging a new version of p
+func TempSiblingPath(p string) string {
+	dir, name := path.Split(p)
+	return path.Join(dir, fmt.Sprintf(".%s.%016x%s", name, rand.Uint64(), TempFileSuffix))
+}
@@ -18,6 +18,7 @@ import (
 )
 
 var CacheAttrsTimeDuration = 5 * time.Second
+var CacheDirListingTimeDuration = 5 * time.Second
 var StagingDir string = "/tmp"
 var MntSrcDir string = "/"
 var LogFile string = ""
@@ -30,13 +31,31 @@ var AllowedPrefixesString string = "*"
 var ReadOnly bool = false
 var Tls bool = false
 var Connectors int
+var MetadataConnections int = 4
+var ConnectorProbeInterval = 5 * time.Second
 var Version bool = false
 var ForceOverrideUsername string = ""
 var UseGroupFromHopsFsDatasetPath bool = false
 var AllowOther bool = false
 var HopfsProjectDatasetGroupRegex = regexp.MustCompile(`/*Projects/(?P<projectName>\w+)/(?P<datasetName>\w+)/\/*`)
 var EnablePageCache = false
+var StreamingWrites = false
+var EmulateSymlinks = false
+var TrashEnabled = false
+var TrashExcludedPrefixesString string = ""
+var TrashMaxSizeMB int64 = 0
+var MaxReadersPerFile = 4
+var BlockCacheDir = ""
+var BlockCacheSizeMB int64 = 10240
+var ReadAheadMinWindow = 128 * 1024
+var ReadAheadMaxWindow = 4 * 1024 * 1024
+var ReadAheadBufferSize = 16 * 1024 * 1024
 var CacheAttrsTimeSecs = 5
+var CacheDirListingTimeSecs = 5
+var ReadDirPageSize = 1000
+var WatchPathsString string = ""
+var WatchIntervalSecs = 30
+var WatchMaxRPCsPerSec = 20
 var FallBackUser = "root"
 var FallBackGroup = "root"
 var UserUmask string = ""
@@ -59,12 +78,30 @@ func ParseArgsAndInitLogger(retryPolicy *RetryPolicy) {
 	flag.StringVar(&MntSrcDir, "srcDir", "/", "HopsFS src directory")
 	flag.StringVar(&LogFile, "logFile", "", "Log file path. By default the log is written to console")
 	flag.IntVar(&Connectors, "numConnections", 1, "Number of connections with the namenode")
+	flag.IntVar(&MetadataConnections, "numMetadataConnections", 4, "Maximum number of concurrent metadata operations per connection with the namenode")
+	flag.DurationVar(&ConnectorProbeInterval, "connectionProbeInterval", 5*time.Second, "Delay between attempts to reconnect a failed connection with the namenode. Failed connections are not used until they reconnect")
 	flag.StringVar(&ForceOverrideUsername, "hopsFSUserName", "", "HopsFS username")
 	flag.BoolVar(&UseGroupFromHopsFsDatasetPath, "getGroupFromHopsFSDatasetPath", false, "Get the group from hopsfs dataset path. This will work if a hopsworks project is mounted")
 	flag.BoolVar(&AllowOther, "allowOther", true, "Allow other users to use the filesystem")
 	flag.BoolVar(&Version, "version", false, "Print version")
 	flag.BoolVar(&EnablePageCache, "enablePageCache", false, "Enable Linux Page Cache")
+	flag.BoolVar(&EmulateSymlinks, "emulateSymlinks", false, "Store symbolic links as marker files. A stopgap until the HopsFS client can create symlinks, other HopsFS clients see the links as small files, with the sticky bit set, holding the link target, and the marker files are seen as regular files by the mounts that do not enable this option. Symlinks created by other clients are followed either way. If disabled, creating a symlink fails with ENOTSUP")
+	flag.BoolVar(&TrashEnabled, "trash", false, "Move the removed files and directories to .Trash/Current in the HopsFS home directory of the user, like hdfs dfs -rm, instead of deleting them")
+	flag.StringVar(&TrashExcludedPrefixesString, "trashExcludedPrefixes", "", "Comma-separated list of HopsFS path prefixes under which the removed entries are deleted permanently")
+	flag.Int64Var(&TrashMaxSizeMB, "trashMaxSizeMB", 0, "Files larger than this size in MB are deleted permanently instead of being moved to the trash. No limit if 0")
+	flag.BoolVar(&StreamingWrites, "streamingWrites", false, "Stream sequentially written new files directly to HopsFS without a copy in the staging dir. Falls back to staging if the file is not written sequentially")
+	flag.StringVar(&BlockCacheDir, "blockCacheDir", "", "Directory of the persistent cache of the blocks read from HopsFS. The cache is disabled if not set")
+	flag.Int64Var(&BlockCacheSizeMB, "blockCacheSizeMB", 10240, "Maximum size of the block cache in MB. The least recently used blocks are evicted")
+	flag.IntVar(&MaxReadersPerFile, "maxReadersPerFile", 4, "Maximum number of concurrent reads of a file. Each of them uses its own connection with the datanodes")
+	flag.IntVar(&ReadAheadMinWindow, "readAheadMinWindow", 128*1024, "Size in bytes of the first window prefetched when a file is read sequentially")
+	flag.IntVar(&ReadAheadMaxWindow, "readAheadMaxWindow", 4*1024*1024, "Maximum size in bytes of the windows prefetched when a file is read sequentially. The windows double in size while the reads stay sequential. Set to 0 to disable read-ahead")
+	flag.IntVar(&ReadAheadBufferSize, "readAheadBufferSize", 16*1024*1024, "Maximum number of bytes prefetched per open file handle")
 	flag.IntVar(&CacheAttrsTimeSecs, "cacheAttrsTimeSecs", 5, "Cache INodes' Attrs. Set to 0 to disable caching INode attrs.")
+	flag.IntVar(&ReadDirPageSize, "readDirPageSize", 1000, "Number of directory entries fetched from HopsFS at a time when a directory is listed")
+	flag.IntVar(&CacheDirListingTimeSecs, "cacheDirListingTimeSecs", 5, "Cache directory listings. Changes made through this mount are applied to the cached listings. Set to 0 to disable caching directory listings.")
+	flag.StringVar(&WatchPathsString, "watchPaths", "", "Comma-separated list of subtrees, relative to the mount point, which are polled for changes made by other clients. Use / to watch the whole mount. Disabled if not set")
+	flag.IntVar(&WatchIntervalSecs, "watchIntervalSecs", 30, "Delay between polls of the watched subtrees")
+	flag.IntVar(&WatchMaxRPCsPerSec, "watchMaxRPCsPerSec", 20, "Maximum number of requests per second sent to the namenode to poll the watched subtrees")
 	flag.StringVar(&FallBackUser, "fallBackUser", "root", "Local user name if the DFS user is not found on the local file system")
 	flag.StringVar(&FallBackGroup, "fallBackGroup", "root", "Local group name if the DFS group is not found on the local file system.")
 	flag.StringVar(&UserUmask, "umask", "", "Umask for the file system. Must be a 4 digit octal number. Default is system umask")
@@ -90,12 +127,46 @@ func ParseArgsAndInitLogger(retryPolicy *RetryPolicy) {
 	}
 	logger.InitLogger(LogLevel, false, LogFile)
 
+	if MetadataConnections < 1 {
+		log.Fatalf("Invalid config. numMetadataConnections must be at least 1")
+	}
+
+	if BlockCacheDir != "" && BlockCacheSizeMB <= 0 {
+		log.Fatalf("Invalid config. blockCacheSizeMB must be positive")
+	}
+
+	if TrashMaxSizeMB < 0 {
+		log.Fatalf("Invalid config. trashMaxSizeMB must not be negative")
+	}
+
+	if MaxReadersPerFile < 1 {
+		log.Fatalf("Invalid config. maxReadersPerFile must be at least 1")
+	}
+
+	if ReadAheadMaxWindow > 0 && (ReadAheadMinWindow <= 0 || ReadAheadMinWindow > ReadAheadMaxWindow || ReadAheadBufferSize < ReadAheadMaxWindow) {
+		log.Fatalf("Invalid config. readAheadMinWindow must be positive and at most readAheadMaxWindow, which must be at most readAheadBufferSize")
+	}
+
 	if CacheAttrsTimeSecs < 0 {
 		log.Fatalf("Invalid config. cacheAttrsTimeSecs can not be negative ")
 	} else {
 		CacheAttrsTimeDuration = time.Second * time.Duration(CacheAttrsTimeSecs)
 	}
 
+	if ReadDirPageSize < 1 {
+		log.Fatalf("Invalid config. readDirPageSize must be at least 1")
+	}
+
+	if WatchPathsString != "" && (WatchIntervalSecs < 1 || WatchMaxRPCsPerSec < 1) {
+		log.Fatalf("Invalid config. watchIntervalSecs and watchMaxRPCsPerSec must be at least 1")
+	}
+
+	if CacheDirListingTimeSecs < 0 {
+		log.Fatalf("Invalid config. cacheDirListingTimeSecs can not be negative ")
+	} else {
+		CacheDirListingTimeDuration = time.Second * time.Duration(CacheDirListingTimeSecs)
+	}
+
 	Umask, err := ValidateUmask(UserUmask)
 	if err != nil {
 		log.Fatalf("Invalid umask provided: %v", err)
@@ -140,6 +211,7 @@ func checkLogFileCreation() error {
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  %s [Options] Namenode:Port MountPoint\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s [Options] %s MountPoint (in-memory backend, for testing)\n", os.Args[0], MemNameNodeScheme)
 	fmt.Fprintf(os.Stderr, "  \nOptions:\n")
 	flag.PrintDefaults()
 }
@@ -154,7 +226,7 @@ func GetMountOptions(ro bool) []fuse.MountOption {
 	if EnablePageCache {
 		// https://www.kernel.org/doc/Documentation/filesystems/fuse-io.txt
 		logger.Warn("Linux page caches is enabled. "+
-			"It may cause problems in reading a file updated by external clients", nil)
+			"Files updated by external clients may be read stale until the change is seen by a lookup or a directory listing", nil)
 		mountOptions = append(mountOptions, fuse.WritebackCache())
 	}
 
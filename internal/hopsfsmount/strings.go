@@ -23,13 +23,24 @@ const (
 	Create                        = "create"
 	Rename                        = "rename"
 	Rename2                       = "rename2"
+	Exchange                      = "exchange"
+	Trash                         = "trash"
 	From                          = "from"
 	To                            = "to"
 	Chmod                         = "chmod"
 	Link                          = "link"
 	ReadLink                      = "read_link"
 	Symlink                       = "symlink"
+	GetXAttr                      = "getxattr"
+	ListXAttr                     = "listxattr"
+	SetXAttr                      = "setxattr"
+	RemoveXAttr                   = "removexattr"
+	XAttr                         = "xattr"
+	Replication                   = "replication"
 	Chown                         = "chown"
+	SetTimes                      = "set_times"
+	Atime                         = "atime"
+	Mtime                         = "mtime"
 	Fsync                         = "fsync"
 	Flush                         = "flush"
 	Close                         = "close"
@@ -68,7 +79,10 @@ const (
 	IsRegular                     = "is_regular"
 	NumChildren                   = "num_children"
 	Forget                        = "forget"
+	Invalidate                    = "invalidate"
+	Watch                         = "watch"
 	GetGroupFromHopsFSDatasetPath = "get_group_from_dataset_path"
 	HopsFSUserName                = "hopsfs_user_name"
 	ID                            = "id"
+	Connector                     = "connector"
 )
@@ -46,7 +46,6 @@ func LookupUId(userName string) uint32 {
 		return FallBackUID
 	}
 
-	// Note: this method is called under MetadataClientMutex, so accessing the cache dirctionary is safe
 	cacheEntry, ok := userNameToUidCache[userName]
 	if ok && time.Now().Before(cacheEntry.expires) {
 		return cacheEntry.id
@@ -79,7 +78,6 @@ func LookupGid(groupName string) uint32 {
 		logger.Trace("Could not find GID. Retruning fallback GID", logger.Fields{"Group": groupName, "FallBackGID": FallBackGID})
 		return FallBackGID
 	}
-	// Note: this method is called under MetadataClientMutex, so accessing the cache dictionary is safe
 	cacheEntry, ok := groupNameToUidCache[groupName]
 	if ok && time.Now().Before(cacheEntry.expires) {
 		return cacheEntry.id